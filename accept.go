@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var acceptCmd = &cobra.Command{
+	Use:           "accept",
+	Short:         "Accept the remote item waiting to be written to the clipboard",
+	Args:          cobra.MaximumNArgs(0),
+	SilenceUsage:  true,
+	SilenceErrors: true,
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		if err := viper.BindPFlags(cmd.PersistentFlags()); err != nil {
+			return err
+		}
+		return viper.BindPFlags(cmd.Flags())
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+
+		socket, err := controlSocketFor("accept")
+		if err != nil {
+			return err
+		}
+
+		command := "accept"
+		switch {
+		case viper.GetBool("accept.reject"):
+			command = "reject"
+		case viper.GetBool("accept.show"):
+			command = "staged"
+		}
+
+		item := controlItem{}
+		msg, err := callControl(socket, &item, command)
+		if err != nil {
+			return err
+		}
+
+		if item.ID == "" {
+			fmt.Println(msg)
+			return nil
+		}
+
+		if msg != "" {
+			fmt.Fprintln(os.Stderr, msg)
+		}
+		fmt.Println(item)
+
+		return nil
+	},
+}
+
+func init() {
+	acceptCmd.Flags().Bool("reject", false, "Reject the waiting item instead")
+	_ = viper.BindPFlag("accept.reject", acceptCmd.Flags().Lookup("reject"))
+
+	acceptCmd.Flags().Bool("show", false, "Only show the waiting item")
+	_ = viper.BindPFlag("accept.show", acceptCmd.Flags().Lookup("show"))
+
+	acceptCmd.Flags().String("control-socket", "", "Path to the control socket of listen. Defaults to the one configured for listen")
+	_ = viper.BindPFlag("accept.control-socket", acceptCmd.Flags().Lookup("control-socket"))
+}
@@ -0,0 +1,46 @@
+package cboard
+
+import (
+	"fmt"
+)
+
+// URIListType is the MIME type used by file
+// managers to copy a list of files.
+const URIListType = "text/uri-list"
+
+// ReadURIList returns the URI list held by the clipboard,
+// or nil if it does not hold one. It always returns nil if
+// the ClipboardManager is not a MIMEClipboardManager.
+func ReadURIList(cb ClipboardManager) ([]byte, error) {
+
+	mcb, ok := cb.(MIMEClipboardManager)
+	if !ok {
+		return nil, nil
+	}
+
+	types, err := mcb.Types()
+	if err != nil {
+		return nil, fmt.Errorf("unable to list clipboard types: %w", err)
+	}
+
+	for _, t := range types {
+		if t == URIListType {
+			return mcb.ReadType(URIListType)
+		}
+	}
+
+	return nil, nil
+}
+
+// WriteURIList writes the given URI list to the clipboard, so
+// file managers can paste the files. If the ClipboardManager is
+// not a MIMEClipboardManager, the given text is written instead.
+func WriteURIList(cb ClipboardManager, uris []byte, text []byte) error {
+
+	mcb, ok := cb.(MIMEClipboardManager)
+	if !ok {
+		return cb.Write(text)
+	}
+
+	return mcb.WriteType(URIListType, uris)
+}
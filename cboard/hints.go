@@ -0,0 +1,59 @@
+package cboard
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// secretHintTypes are the MIME types used by password
+// managers to flag the clipboard content as sensitive.
+// The associated function tells if the value of the type
+// means the content is secret. If nil, the presence of
+// the type is enough.
+var secretHintTypes = map[string]func([]byte) bool{
+	"x-kde-passwordManagerHint": func(v []byte) bool {
+		return bytes.Equal(bytes.TrimSpace(v), []byte("secret"))
+	},
+	"application/x-nspasteboard-concealed-type": nil,
+	"org.nspasteboard.ConcealedType":            nil,
+}
+
+// HasSecretHint returns true if the current content of the clipboard
+// has been flagged as sensitive by the application that set it. It
+// always returns false if the ClipboardManager is not a
+// MIMEClipboardManager.
+func HasSecretHint(cb ClipboardManager) (bool, error) {
+
+	mcb, ok := cb.(MIMEClipboardManager)
+	if !ok {
+		return false, nil
+	}
+
+	types, err := mcb.Types()
+	if err != nil {
+		return false, fmt.Errorf("unable to list clipboard types: %w", err)
+	}
+
+	for _, t := range types {
+
+		check, ok := secretHintTypes[t]
+		if !ok {
+			continue
+		}
+
+		if check == nil {
+			return true, nil
+		}
+
+		v, err := mcb.ReadType(t)
+		if err != nil {
+			return false, fmt.Errorf("unable to read clipboard type %s: %w", t, err)
+		}
+
+		if check(v) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
@@ -7,5 +7,15 @@ import "context"
 type ClipboardManager interface {
 	Read() ([]byte, error)
 	Write([]byte) error
+	Clear() error
 	Watch(context.Context) (<-chan []byte, <-chan error)
 }
+
+// A MIMEClipboardManager is a ClipboardManager that knows
+// about the MIME types offered by the clipboard content.
+type MIMEClipboardManager interface {
+	ClipboardManager
+	Types() ([]string, error)
+	ReadType(mime string) ([]byte, error)
+	WriteType(mime string, data []byte) error
+}
@@ -32,6 +32,11 @@ func (c *libClipboardManager) Write(data []byte) error {
 	return nil
 }
 
+func (c *libClipboardManager) Clear() error {
+	clipboard.Write(clipboard.FmtText, []byte{})
+	return nil
+}
+
 func (c *libClipboardManager) Watch(ctx context.Context) (<-chan []byte, <-chan error) {
 	chout := clipboard.Watch(ctx, clipboard.FmtText)
 	return chout, make(chan error)
@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"io"
 	"os/exec"
+	"strings"
 )
 
 const ClipboardEmptyErrorString = "Nothing is copied\n"
@@ -48,6 +49,44 @@ func (c *toolsClipboardManager) Read() ([]byte, error) {
 	return stdout.Bytes(), nil
 }
 
+func (c *toolsClipboardManager) Types() ([]string, error) {
+
+	cmd := exec.Command("wl-paste", "--list-types")
+
+	stdout := bytes.NewBuffer(nil)
+	cmd.Stdout = stdout
+	stderr := bytes.NewBuffer(nil)
+	cmd.Stderr = stderr
+
+	if err := cmd.Run(); err != nil {
+		if stderr.String() == ClipboardEmptyErrorString {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("unable to run list types command: %w", err)
+	}
+
+	return strings.Fields(stdout.String()), nil
+}
+
+func (c *toolsClipboardManager) ReadType(mime string) ([]byte, error) {
+
+	cmd := exec.Command("wl-paste", "--no-newline", "--type", mime)
+
+	stdout := bytes.NewBuffer(nil)
+	cmd.Stdout = stdout
+	stderr := bytes.NewBuffer(nil)
+	cmd.Stderr = stderr
+
+	if err := cmd.Run(); err != nil {
+		if stderr.String() == ClipboardEmptyErrorString {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("unable to run read command: %w", err)
+	}
+
+	return stdout.Bytes(), nil
+}
+
 func (c *toolsClipboardManager) Write(data []byte) error {
 
 	cmd := exec.Command("wl-copy", "--trim-newline")
@@ -69,6 +108,27 @@ func (c *toolsClipboardManager) Write(data []byte) error {
 	return nil
 }
 
+func (c *toolsClipboardManager) WriteType(mime string, data []byte) error {
+
+	cmd := exec.Command("wl-copy", "--type", mime)
+	cmd.Stdin = bytes.NewReader(data)
+
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("unable to run write command: %w", err)
+	}
+
+	return nil
+}
+
+func (c *toolsClipboardManager) Clear() error {
+
+	if err := exec.Command("wl-copy", "--clear").Run(); err != nil {
+		return fmt.Errorf("unable to run clear command: %w", err)
+	}
+
+	return nil
+}
+
 func (c *toolsClipboardManager) Watch(ctx context.Context) (<-chan []byte, <-chan error) {
 
 	chout := make(chan []byte)
@@ -1,21 +1,33 @@
 package main
 
 import (
-	"bytes"
-	"crypto/sha256"
+	"context"
 	"crypto/tls"
 	"crypto/x509"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
 
 	"github.com/primalmotion/netboard/cboard"
 	"github.com/primalmotion/netboard/client"
+	"github.com/primalmotion/netboard/protocol"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 	"go.aporeto.io/tg/tglib"
 )
 
+// defaultMaxFilesSize is the maximum size of the copied files
+// packed before the limits of the server are known.
+const defaultMaxFilesSize = 10 << 20
+
 var listenCmd = &cobra.Command{
 	Use:           "listen",
 	Short:         "Sync data between clipboard and server",
@@ -30,7 +42,7 @@ var listenCmd = &cobra.Command{
 	},
 	RunE: func(cmd *cobra.Command, args []string) error {
 
-		addr := viper.GetString("listen.url")
+		urls := viper.GetStringSlice("listen.url")
 		certPath := os.ExpandEnv(viper.GetString("listen.cert"))
 		certKeyPath := os.ExpandEnv(viper.GetString("listen.cert-key"))
 		certKeyPass := viper.GetString("listen.cert-key-pass")
@@ -38,97 +50,642 @@ var listenCmd = &cobra.Command{
 		skipVerify := viper.GetBool("listen.insecure-skip-verify")
 		mode := viper.GetString("listen.mode")
 		useWebsocket := viper.GetBool("listen.websocket")
+		backoffInitial := viper.GetDuration("listen.backoff-initial")
+		backoffMax := viper.GetDuration("listen.backoff-max")
+		backoffMaxAttempts := viper.GetInt("listen.backoff-max-attempts")
+		outboxPath := os.ExpandEnv(viper.GetString("listen.outbox"))
+		outboxSize := viper.GetInt("listen.outbox-size")
+		defaultTTL := viper.GetDuration("listen.ttl")
+		blobCache := os.ExpandEnv(viper.GetString("listen.blob-cache"))
+		downloadDir := os.ExpandEnv(viper.GetString("listen.download-dir"))
+		compression := viper.GetString("listen.compression")
+		compressionThreshold := viper.GetInt("listen.compression-threshold")
+		notify := viper.GetBool("listen.notify")
+		notifyInterval := viper.GetDuration("listen.notify-interval")
+		notifyPreview := viper.GetInt("listen.notify-preview")
+		doNotDisturb := viper.GetBool("listen.do-not-disturb")
+		confirm := viper.GetBool("listen.confirm")
+		autoAccept := viper.GetStringSlice("listen.auto-accept")
+		controlSocket, controlSocketErr := expandRuntimePath(viper.GetString("listen.control-socket"))
+		trayIcon := viper.GetBool("listen.tray")
 
-		x509Cert, x509Key, err := tglib.ReadCertificatePEM(certPath, certKeyPath, certKeyPass)
+		direction, err := client.ParseSyncMode(viper.GetString("listen.direction"))
 		if err != nil {
-			return fmt.Errorf("unable to read certificate: %w", err)
+			return err
+		}
+
+		if compression == "none" {
+			compression = ""
+		}
+		if compression != "" && !protocol.IsSupportedEncoding(compression) {
+			return fmt.Errorf("unsupported compression %s", compression)
 		}
 
-		tlsCert, err := tglib.ToTLSCertificate(x509Cert, x509Key)
+		filterCfg := client.FilterConfig{SecretHints: true}
+		if err := viper.UnmarshalKey("listen.filters", &filterCfg); err != nil {
+			return fmt.Errorf("unable to read filters configuration: %w", err)
+		}
+
+		filter, err := client.NewFilter(filterCfg)
 		if err != nil {
-			return fmt.Errorf("unable to convert to tls certificate: %w", err)
+			return fmt.Errorf("unable to prepare filters: %w", err)
 		}
 
-		var serverCAPool *x509.CertPool
-		if serverCAPath != "" {
-			serverCAData, err := os.ReadFile(serverCAPath)
-			if err != nil {
-				return fmt.Errorf("unable to read client certificate: %w", err)
+		var transformCfgs []client.TransformConfig
+		if err := viper.UnmarshalKey("listen.transforms", &transformCfgs); err != nil {
+			return fmt.Errorf("unable to read transforms configuration: %w", err)
+		}
+
+		transformer, err := client.NewTransformer(transformCfgs)
+		if err != nil {
+			return fmt.Errorf("unable to prepare transforms: %w", err)
+		}
+
+		tlsConf, x509Cert, err := newClientTLSConfig(certPath, certKeyPath, certKeyPass, serverCAPath, skipVerify)
+		if err != nil {
+			return err
+		}
+
+		cb, err := newClipboardManager(mode)
+		if err != nil {
+			return err
+		}
+		slog.Info("using clipboard mode", "mode", mode)
+
+		watchChan, watchErrChan := cb.Watch(cmd.Context())
+
+		if len(urls) == 0 {
+			return fmt.Errorf("no server url given")
+		}
+		servers := client.NewServers(tlsConf, urls...)
+
+		backoff := client.DefaultBackoff
+		backoff.Initial = backoffInitial
+		backoff.Max = backoffMax
+		backoff.MaxAttempts = backoffMaxAttempts
+
+		outbox, err := client.NewOutbox(outboxPath, outboxSize)
+		if err != nil {
+			return fmt.Errorf("unable to prepare outbox: %w", err)
+		}
+
+		if err := client.PruneBlobCache(blobCache, 24*time.Hour); err != nil {
+			slog.Warn("unable to prune blob cache", "error", err)
+		}
+
+		// flushChan is notified when the queued changes
+		// should be sent, for instance after a reconnection.
+		flushChan := make(chan struct{}, 1)
+		triggerFlush := func() {
+			select {
+			case flushChan <- struct{}{}:
+			default:
 			}
-			serverCAPool = x509.NewCertPool()
-			serverCAPool.AppendCertsFromPEM(serverCAData)
-		} else {
-			serverCAPool, err = x509.SystemCertPool()
+		}
+
+		if outbox.Len() > 0 {
+			slog.Info("queued changes loaded from outbox", "count", outbox.Len())
+			triggerFlush()
+		}
+
+		go runOutbox(cmd.Context(), outbox, flushChan, servers, backoff)
+
+		// serverLimits holds the limits enforced by the server,
+		// so we do not even try to publish items it would refuse.
+		// They are retrieved every time we connect.
+		var serverLimits atomic.Pointer[protocol.Limits]
+		updateLimits := func() {
+			limits, err := client.FetchLimits(servers.Current(), servers.Client())
 			if err != nil {
-				return fmt.Errorf("unable to prepare cert pool from system: %w", err)
+				slog.Warn("unable to retrieve server limits", "url", servers.Current(), "error", err)
+				return
+			}
+			serverLimits.Store(limits)
+		}
+
+		// connState holds the latest connection state,
+		// reported by the control socket.
+		var connState atomic.Pointer[client.StateEvent]
+
+		subCfg := client.SubscribeConfig{
+			TLSConfig: tlsConf,
+			Backoff:   backoff,
+			MaxSize:   int64(filterCfg.MaxSize),
+			StateFunc: func(evt client.StateEvent) {
+				logStateEvent(evt)
+				connState.Store(&evt)
+				if evt.State == client.StateConnected {
+					go updateLimits()
+					triggerFlush()
+				}
+			},
+		}
+		if compression != "" {
+			subCfg.Encodings = []string{compression}
+		}
+
+		transport := "chunked"
+		if useWebsocket {
+			transport = "websocket"
+		}
+
+		var listenChan chan *protocol.Item
+		var listenDone chan struct{}
+		var stopListen context.CancelFunc
+
+		// subscribe subscribes to the servers until
+		// stopListen is called or the command ends.
+		subscribe := func() {
+			var listenCtx context.Context
+			listenCtx, stopListen = context.WithCancel(cmd.Context())
+			slog.Info("subscribing", "transport", transport)
+			if useWebsocket {
+				listenChan, listenDone = client.SubscribeWS(listenCtx, servers, subCfg)
+			} else {
+				listenChan, listenDone = client.SubscribeChunked(listenCtx, servers, subCfg)
 			}
 		}
+		subscribe()
+		defer func() { stopListen() }()
 
-		tlsConf := &tls.Config{
-			Certificates:       []tls.Certificate{tlsCert},
-			RootCAs:            serverCAPool,
-			InsecureSkipVerify: skipVerify,
+		var notifier *client.Notifier
+		if notify {
+			if notifier, err = client.NewNotifier(notifyInterval, notifyPreview); err != nil {
+				return fmt.Errorf("unable to prepare notifications: %w", err)
+			}
+			notifier.SetDoNotDisturb(doNotDisturb)
 		}
 
-		var cb cboard.ClipboardManager
+		// SIGUSR1 pauses and resumes the sync.
+		pauseChan := make(chan os.Signal, 1)
+		signal.Notify(pauseChan, syscall.SIGUSR1)
+		defer signal.Stop(pauseChan)
+
+		// SIGUSR2 toggles do not disturb.
+		dndChan := make(chan os.Signal, 1)
+		signal.Notify(dndChan, syscall.SIGUSR2)
+		defer signal.Stop(dndChan)
+
+		// Without its control socket, listen
+		// runs but cannot be controlled.
+		controlChan := make(chan controlRequest)
+		switch {
+		case controlSocketErr != nil:
+			slog.Warn("running without control socket", "error", controlSocketErr)
+		case controlSocket != "":
+			err := serveControl(cmd.Context(), controlSocket, controlChan)
+			if errors.Is(err, errControlSocketInUse) {
+				slog.Warn("control socket is in use: is another listen running? running without it", "path", controlSocket)
+			} else if err != nil {
+				return err
+			}
+		}
+
+		if trayIcon {
+			if err := runTray(cmd.Context(), controlChan); err != nil {
+				return err
+			}
+		}
+
+		selfID := protocol.Fingerprint(x509Cert.Raw)
+		recent := client.NewRecentItems(32, 10*time.Second)
+		expirations := client.NewExpirations()
+
+		// paused is true while the sync is suspended.
+		var paused bool
+		started := time.Now()
+
+		// history holds the latest items sent or received,
+		// reported by the control socket.
+		history := newControlHistory(20)
+
+		// apply writes the given remote item to the clipboard.
+		// ids are the IDs it is known by, so its echo is ignored.
+		apply := func(item *protocol.Item, ids []string) bool {
+
+			slog.Info("remote clipboard changed: updating local", "item", item.ID, "device", item.Origin, "size", len(item.Data), "mime", item.MIME)
+			if err := writeClipboard(cb, item, downloadDir); err != nil {
+				slog.Error("unable to write to local clipboard", "item", item.ID, "error", err)
+				return false
+			}
+
+			// The clipboard backend may alter the data when writing it.
+			// We also remember what it actually holds now, so the
+			// upcoming local change is recognized as an echo.
+			if data, err := cb.Read(); err == nil {
+				ids = append(ids, protocol.ComputeID(data))
+			}
+			recent.Add(client.OriginRemote, ids...)
+
+			if item.Expires != nil {
+				expirations.Track(cmd.Context(), item.ID, *item.Expires, ids...)
+			}
+
+			history.add("remote", item)
+
+			return true
+		}
 
-		switch mode {
-		case "lib":
-			cb, err = cboard.NewLibClipboardManager()
+		// publish queues the given local clipboard content to be sent
+		// to the server, unless it is filtered out. If force is false,
+		// the content is skipped if it is the echo of a recent item.
+		// It returns true if the item was queued.
+		publish := func(data []byte, force bool) bool {
+
+			var files bool
+			if downloadDir != "" {
+				packed, err := readClipboardFiles(cb, serverLimits.Load(), int64(filterCfg.MaxSize))
+				if err != nil {
+					slog.Warn("local clipboard changed: unable to pack copied files, sending their list instead", "error", err)
+				} else if packed != nil {
+					data, files = packed, true
+				}
+			}
+
+			item := protocol.NewItem(data, time.Now())
+			if files {
+				item.MIME = protocol.FilesMIME
+			}
+			looseID := protocol.ComputeLooseID(data)
+
+			if !force && recent.IsEcho(client.OriginLocal, item.ID, looseID) {
+				return false
+			}
+			recent.Add(client.OriginLocal, item.ID, looseID)
+
+			secret, err := cboard.HasSecretHint(cb)
 			if err != nil {
-				log.Fatalf("unable to use lib mode: %s", err)
+				slog.Warn("unable to check clipboard hints", "error", err)
+			}
+
+			if reason := filter.Check(item, secret); reason != "" {
+				slog.Info("local clipboard changed: skipping item", "item", item.ID, "size", len(item.Data), "reason", reason)
+				return false
 			}
-			log.Println("using lib mode")
-		case "wl-clipboard":
-			cb, err = cboard.NewToolsClipboardManager()
+
+			transformed, err := transformer.Apply(cmd.Context(), client.DirectionPublish, item)
 			if err != nil {
-				log.Fatalf("unable to use wl-clipboard mode: %s", err)
+				slog.Warn("local clipboard changed: skipping item", "item", item.ID, "size", len(item.Data), "error", err)
+				return false
+			}
+			if transformed == nil {
+				slog.Info("local clipboard changed: skipping item", "item", item.ID, "size", len(item.Data), "reason", "dropped by transforms")
+				return false
 			}
-			log.Println("using wl-clipboard mode")
-		default:
-			log.Fatalf("unknown mode %s", mode)
+			localIDs := []string{item.ID, looseID}
+			if transformed.ID != item.ID {
+				recent.Add(client.OriginLocal, transformed.ID, protocol.ComputeLooseID(transformed.Data))
+				item = transformed
+			}
+
+			if limits := serverLimits.Load(); limits != nil {
+				if max := limits.For(item.MIME); max > 0 && int64(len(item.Data)) > max {
+					slog.Warn("local clipboard changed: skipping item", "item", item.ID, "size", len(item.Data), "mime", item.MIME, "reason", "exceeds the server maximum size", "limit", max)
+					return false
+				}
+				if limits.BlobThreshold > 0 && int64(len(item.Data)) > limits.BlobThreshold {
+					item.Blob = true
+					item.Size = int64(len(item.Data))
+				}
+			}
+
+			ttl := filter.TTL(item, secret)
+			if ttl == 0 || (defaultTTL > 0 && defaultTTL < ttl) {
+				ttl = defaultTTL
+			}
+			if ttl > 0 {
+				expires := item.Time.Add(ttl)
+				item.Expires = &expires
+				expirations.Track(cmd.Context(), item.ID, expires, localIDs...)
+			}
+
+			// The history reports the size of the content.
+			sent := item
+
+			item, ok := compressItem(sent, serverLimits.Load(), compression, compressionThreshold)
+			if !ok {
+				return false
+			}
+
+			slog.Info("local clipboard changed: updating remote", "item", item.ID, "size", len(item.Data), "mime", item.MIME, "encoding", item.Encoding, "blob", item.Blob)
+			if err := outbox.Push(item); err != nil {
+				slog.Error("unable to queue item", "item", item.ID, "error", err)
+				return false
+			}
+			history.add("local", sent)
+			triggerFlush()
+
+			return true
 		}
 
-		watchChan, watchErrChan := cb.Watch(cmd.Context())
+		// staged is the remote item waiting to be accepted,
+		// along with its IDs, when confirmation is required.
+		var staged *protocol.Item
+		var stagedIDs []string
 
-		var listenChan chan []byte
-		var listenDone chan struct{}
-		if useWebsocket {
-			listenChan, listenDone = client.SubscribeWS(cmd.Context(), addr, tlsConf)
-			log.Println("using websockets")
-		} else {
-			listenChan, listenDone = client.SubscribeChunked(cmd.Context(), addr, tlsConf)
-			log.Println("using chunked http encoding")
+		// stage makes the given remote item wait to be accepted.
+		stage := func(item *protocol.Item, ids []string) {
+
+			slog.Info("remote clipboard changed: waiting for acceptance", "item", item.ID, "device", item.Origin, "size", newControlItem(item).Size, "mime", item.MIME)
+			staged, stagedIDs = item, ids
+
+			if notifier != nil {
+				id := item.ID
+				notifier.NotifyStaged(item, func() {
+					select {
+					case controlChan <- newControlRequest("accept", id):
+					case <-cmd.Context().Done():
+					}
+				})
+			}
+		}
+
+		// blobChan receives the blobs downloaded in the background.
+		blobChan := make(chan fetchedBlob)
+
+		// fetchBlob downloads the content of the given blob item in
+		// the background, once it is about to be applied, so the
+		// loop is not blocked meanwhile. accepted tells the item
+		// was accepted by the user.
+		fetchBlob := func(item *protocol.Item, accepted bool) {
+
+			slog.Info("remote clipboard changed: fetching blob", "item", item.ID, "size", item.Size)
+
+			var maxSize int64
+			if limits := serverLimits.Load(); limits != nil {
+				maxSize = limits.For(item.MIME)
+			}
+
+			url := servers.Current()
+			go func() {
+				data, err := client.FetchBlob(cmd.Context(), url, servers.Client(), item.ID, maxSize, blobCache)
+				if err == nil && item.Expires != nil {
+					// The content of an expiring item
+					// does not outlive it in the cache.
+					time.AfterFunc(time.Until(*item.Expires), func() {
+						if err := client.RemoveCachedBlob(blobCache, item.ID); err != nil {
+							slog.Warn("unable to remove expired blob", "item", item.ID, "error", err)
+						}
+					})
+				}
+				select {
+				case blobChan <- fetchedBlob{item: item, data: data, accepted: accepted, err: err}:
+				case <-cmd.Context().Done():
+				}
+			}()
+		}
+
+		// receive handles the given remote item, holding its content.
+		// Unless it is an echo or dropped by the transforms, it is
+		// written to the clipboard, or waits to be accepted if it
+		// must be and was not already.
+		receive := func(item *protocol.Item, accepted bool) {
+
+			looseID := protocol.ComputeLooseID(item.Data)
+			if recent.IsEcho(client.OriginRemote, item.ID, looseID) {
+				return
+			}
+
+			ids := []string{item.ID, looseID}
+
+			transformed, err := transformer.Apply(cmd.Context(), client.DirectionReceive, item)
+			if err != nil {
+				slog.Warn("remote clipboard changed: skipping item", "item", item.ID, "device", item.Origin, "size", len(item.Data), "error", err)
+				return
+			}
+			if transformed == nil {
+				slog.Info("remote clipboard changed: skipping item", "item", item.ID, "device", item.Origin, "size", len(item.Data), "reason", "dropped by transforms")
+				return
+			}
+			if transformed.ID != item.ID {
+				ids = append(ids, transformed.ID, protocol.ComputeLooseID(transformed.Data))
+				item = transformed
+			}
+
+			if !accepted && confirm && !fromDevices(autoAccept, item) {
+				stage(item, ids)
+				return
+			}
+
+			if apply(item, ids) && notifier != nil {
+				notifier.Notify(item)
+			}
 		}
 
-		var lastH []byte
 		for {
 			select {
 			case err := <-watchErrChan:
 				return fmt.Errorf("error during watch: %w", err)
 
 			case data := <-watchChan:
-				h := sha256.New().Sum(data)
-				if !bytes.Equal(lastH, h) {
-					log.Println("local clipboard changed. updating remote")
-					if err := client.Publish(bytes.NewBuffer(data), addr, tlsConf); err != nil {
-						log.Printf("error sending data: %s", err)
+				if len(data) == 0 {
+					continue
+				}
+
+				if paused || !direction.Sends() {
+					slog.Debug("local clipboard changed: not sending", "paused", paused, "direction", direction)
+					continue
+				}
+
+				publish(data, false)
+
+			case item := <-listenChan:
+				if item.IsClear() {
+					if staged != nil && slices.Contains(stagedIDs, item.ID) {
+						staged, stagedIDs = nil, nil
+					}
+					expireItem(cb, expirations, item.ID)
+					continue
+				}
+
+				if item.Origin == selfID || item.Expired() {
+					continue
+				}
+
+				if paused || !direction.Receives() {
+					slog.Debug("remote clipboard changed: not receiving", "item", item.ID, "paused", paused, "direction", direction)
+					continue
+				}
+
+				// The blobs are only downloaded when applied.
+				if item.Blob {
+					if recent.IsEcho(client.OriginRemote, item.ID) {
+						continue
+					}
+					if confirm && !fromDevices(autoAccept, item) {
+						stage(item, []string{item.ID})
 						continue
 					}
-					lastH = h
+					fetchBlob(item, false)
+					continue
 				}
 
-			case data := <-listenChan:
-				h := sha256.New().Sum(data)
-				if !bytes.Equal(lastH, h) {
-					log.Println("remote clipboard changed. updating local")
-					if err := cb.Write(data); err != nil {
-						log.Printf("unable to write to local clipboard: %s", err)
+				receive(item, false)
+
+			case res := <-blobChan:
+				if res.err != nil {
+					slog.Warn("remote clipboard changed: skipping item", "item", res.item.ID, "device", res.item.Origin, "size", res.item.Size, "error", res.err)
+					continue
+				}
+
+				if !res.accepted && (paused || !direction.Receives()) {
+					continue
+				}
+
+				item := *res.item
+				item.Data = res.data
+				receive(&item, res.accepted)
+
+			case <-pauseChan:
+				paused = !paused
+				slog.Info("sync state changed", "paused", paused)
+
+			case <-dndChan:
+				if notifier == nil {
+					continue
+				}
+				notifier.SetDoNotDisturb(!notifier.DoNotDisturb())
+				slog.Info("do not disturb toggled", "enabled", notifier.DoNotDisturb())
+
+			case req := <-controlChan:
+				switch req.Command {
+
+				case "staged":
+					if staged == nil {
+						req.reply <- controlReply{Message: "no item is waiting"}
+						continue
+					}
+					req.reply <- controlReply{Data: newControlItem(staged)}
+
+				case "accept", "reject":
+					if staged == nil || (len(req.Args) > 0 && req.Args[0] != staged.ID) {
+						req.reply <- controlReply{Error: "no item is waiting"}
+						continue
+					}
+					item, ids := staged, stagedIDs
+					staged, stagedIDs = nil, nil
+
+					if item.Expired() {
+						req.reply <- controlReply{Error: "the waiting item expired"}
+						continue
+					}
+
+					if req.Command == "reject" {
+						slog.Info("remote item rejected", "item", item.ID, "device", item.Origin)
+						req.reply <- controlReply{Message: "item rejected", Data: newControlItem(item)}
+						continue
+					}
+
+					if item.Blob && len(item.Data) == 0 {
+						fetchBlob(item, true)
+						req.reply <- controlReply{Message: "item accepted: fetching its content", Data: newControlItem(item)}
+						continue
+					}
+
+					if !apply(item, ids) {
+						req.reply <- controlReply{Error: "unable to write to local clipboard"}
+						continue
+					}
+					req.reply <- controlReply{Message: "item accepted", Data: newControlItem(item)}
+
+				case "status":
+					status := controlStatus{
+						State:        "connecting",
+						URL:          servers.Current(),
+						Transport:    transport,
+						Paused:       paused,
+						Direction:    string(direction),
+						DoNotDisturb: notifier != nil && notifier.DoNotDisturb(),
+						Queued:       outbox.Len(),
+						Started:      started,
+					}
+					if evt := connState.Load(); evt != nil {
+						status.State = evt.State.String()
+					}
+					if staged != nil {
+						item := newControlItem(staged)
+						status.Staged = &item
+					}
+					req.reply <- controlReply{Data: status}
+
+				case "pause", "resume":
+					paused = req.Command == "pause"
+					slog.Info("sync state changed", "paused", paused)
+					req.reply <- controlReply{Message: fmt.Sprintf("sync %sd", req.Command)}
+
+				case "direction":
+					if len(req.Args) == 0 {
+						req.reply <- controlReply{Message: string(direction)}
+						continue
+					}
+					mode, err := client.ParseSyncMode(req.Args[0])
+					if err != nil {
+						req.reply <- controlReply{Error: err.Error()}
 						continue
 					}
-					lastH = h
+					direction = mode
+					slog.Info("sync direction changed", "direction", direction)
+					req.reply <- controlReply{Message: fmt.Sprintf("sync direction set to %s", direction)}
+
+				case "push":
+					data, err := cb.Read()
+					if err != nil {
+						req.reply <- controlReply{Error: fmt.Sprintf("unable to read local clipboard: %s", err)}
+						continue
+					}
+					if len(data) == 0 {
+						req.reply <- controlReply{Error: "the clipboard is empty"}
+						continue
+					}
+					if !publish(data, true) {
+						req.reply <- controlReply{Error: "the clipboard content was not sent: see the logs of listen"}
+						continue
+					}
+					req.reply <- controlReply{Message: "clipboard content queued"}
+
+				case "recent":
+					req.reply <- controlReply{Data: history.list()}
+
+				case "copy":
+					if len(req.Args) == 0 {
+						req.reply <- controlReply{Error: "missing item id"}
+						continue
+					}
+					item, err := history.get(req.Args[0])
+					if err != nil {
+						req.reply <- controlReply{Error: err.Error()}
+						continue
+					}
+					if err := writeClipboard(cb, item, downloadDir); err != nil {
+						req.reply <- controlReply{Error: fmt.Sprintf("unable to write to local clipboard: %s", err)}
+						continue
+					}
+					if item.Expires != nil {
+						expirations.Track(cmd.Context(), item.ID, *item.Expires, item.ID)
+					}
+					slog.Info("recent item copied again", "item", item.ID, "size", len(item.Data), "mime", item.MIME)
+					req.reply <- controlReply{Message: "item copied to the clipboard", Data: newControlItem(item)}
+
+				case "reconnect":
+					stopListen()
+					<-listenDone
+					servers.Reset()
+					subscribe()
+					req.reply <- controlReply{Message: "reconnecting"}
+
+				default:
+					req.reply <- controlReply{Error: fmt.Sprintf("unknown command %s", req.Command)}
 				}
 
+			case id := <-expirations.C():
+				expireItem(cb, expirations, id)
+
+			case <-listenDone:
+				if cmd.Context().Err() != nil {
+					return nil
+				}
+				return fmt.Errorf("unable to connect to the server: giving up")
+
 			case <-cmd.Context().Done():
 				<-listenDone
 				return nil
@@ -137,8 +694,242 @@ var listenCmd = &cobra.Command{
 	},
 }
 
+// newClientTLSConfig returns the TLS configuration of the clients,
+// using the given certificate and server CA, and the parsed certificate.
+func newClientTLSConfig(certPath string, certKeyPath string, certKeyPass string, serverCAPath string, skipVerify bool) (*tls.Config, *x509.Certificate, error) {
+
+	x509Cert, x509Key, err := tglib.ReadCertificatePEM(certPath, certKeyPath, certKeyPass)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to read certificate: %w", err)
+	}
+
+	tlsCert, err := tglib.ToTLSCertificate(x509Cert, x509Key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to convert to tls certificate: %w", err)
+	}
+
+	var serverCAPool *x509.CertPool
+	if serverCAPath != "" {
+		serverCAData, err := os.ReadFile(serverCAPath)
+		if err != nil {
+			return nil, nil, fmt.Errorf("unable to read client certificate: %w", err)
+		}
+		serverCAPool = x509.NewCertPool()
+		serverCAPool.AppendCertsFromPEM(serverCAData)
+	} else {
+		serverCAPool, err = x509.SystemCertPool()
+		if err != nil {
+			return nil, nil, fmt.Errorf("unable to prepare cert pool from system: %w", err)
+		}
+	}
+
+	return &tls.Config{
+		Certificates:       []tls.Certificate{tlsCert},
+		RootCAs:            serverCAPool,
+		InsecureSkipVerify: skipVerify,
+	}, x509Cert, nil
+}
+
+// newClipboardManager returns the ClipboardManager of the given mode.
+func newClipboardManager(mode string) (cboard.ClipboardManager, error) {
+
+	switch mode {
+	case "lib":
+		cb, err := cboard.NewLibClipboardManager()
+		if err != nil {
+			return nil, fmt.Errorf("unable to use lib mode: %w", err)
+		}
+		return cb, nil
+	case "wl-clipboard":
+		cb, err := cboard.NewToolsClipboardManager()
+		if err != nil {
+			return nil, fmt.Errorf("unable to use wl-clipboard mode: %w", err)
+		}
+		return cb, nil
+	default:
+		return nil, fmt.Errorf("unknown mode %s", mode)
+	}
+}
+
+// fetchedBlob is the result of the download of the content of a blob item.
+type fetchedBlob struct {
+	item     *protocol.Item
+	data     []byte
+	accepted bool
+	err      error
+}
+
+// fromDevices returns true if the given item was published by one
+// of the given devices, named by fingerprint or certificate common name.
+func fromDevices(devices []string, item *protocol.Item) bool {
+
+	for _, d := range devices {
+		if strings.EqualFold(d, item.Origin) || (item.OriginName != "" && d == item.OriginName) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// readClipboardFiles returns the archive of the local files
+// copied in the clipboard, or nil if it does not hold files.
+// The archive is bounded by the given limits of the server,
+// if known, and the given maximum size, if greater than 0.
+func readClipboardFiles(cb cboard.ClipboardManager, limits *protocol.Limits, maxSize int64) ([]byte, error) {
+
+	uris, err := cboard.ReadURIList(cb)
+	if err != nil || uris == nil {
+		return nil, err
+	}
+
+	paths := client.LocalPaths(uris)
+	if len(paths) == 0 {
+		return nil, nil
+	}
+
+	max := maxSize
+	switch {
+	case limits != nil:
+		if l := limits.For(protocol.FilesMIME); l > 0 && (max == 0 || l < max) {
+			max = l
+		}
+	case max == 0:
+		max = defaultMaxFilesSize
+	}
+
+	return client.PackFiles(paths, max)
+}
+
+// writeClipboard writes the given item to the clipboard. Copied
+// files are materialized in a subdirectory of downloadDir, and
+// their new location is written instead.
+func writeClipboard(cb cboard.ClipboardManager, item *protocol.Item, downloadDir string) error {
+
+	if item.MIME != protocol.FilesMIME {
+		return cb.Write(item.Data)
+	}
+
+	if downloadDir == "" {
+		return fmt.Errorf("item holds files but no download directory is set")
+	}
+
+	// The ID names the directory receiving the files.
+	if !protocol.IsValidID(item.ID) {
+		return fmt.Errorf("invalid item id: %s", item.ID)
+	}
+
+	paths, err := client.UnpackFiles(item.Data, filepath.Join(downloadDir, item.ID[:12]))
+	if err != nil {
+		return err
+	}
+
+	uris, text := client.URIList(paths)
+
+	return cboard.WriteURIList(cb, uris, text)
+}
+
+// compressItem returns the given item compressed with the given
+// encoding if the server, enforcing the given limits, accepts it.
+// It returns false if the item cannot be compressed and must be
+// skipped.
+func compressItem(item *protocol.Item, limits *protocol.Limits, encoding string, threshold int) (*protocol.Item, bool) {
+
+	if limits == nil || item.Blob || !limits.Accepts(encoding) {
+		return item, true
+	}
+
+	compressed, err := item.Compressed(encoding, threshold)
+	if err != nil {
+		slog.Warn("local clipboard changed: skipping item", "item", item.ID, "size", len(item.Data), "error", err)
+		return nil, false
+	}
+
+	return compressed, true
+}
+
+// expireItem clears the local clipboard if it
+// still holds the expired item with the given id.
+func expireItem(cb cboard.ClipboardManager, expirations *client.Expirations, id string) {
+
+	ids := expirations.Take(id)
+	if ids == nil {
+		return
+	}
+
+	data, err := cb.Read()
+	if err != nil {
+		slog.Error("unable to read local clipboard", "error", err)
+		return
+	}
+
+	current := []string{protocol.ComputeID(data), protocol.ComputeLooseID(data)}
+	for _, a := range ids {
+		for _, b := range current {
+			if a == b {
+				slog.Info("item expired: clearing local clipboard", "item", id)
+				if err := cb.Clear(); err != nil {
+					slog.Error("unable to clear local clipboard", "item", id, "error", err)
+				}
+				return
+			}
+		}
+	}
+}
+
+// runOutbox publishes the queued changes each time flushChan is
+// notified, until the context is canceled. It runs apart from the
+// listen loop, as publishing may take long when servers are down.
+// If it fails, it tries again according to the given backoff or to
+// the delay requested by the server.
+func runOutbox(ctx context.Context, outbox *client.Outbox, flushChan <-chan struct{}, servers *client.Servers, backoff client.Backoff) {
+
+	var retryChan <-chan time.Time
+	var attempt int
+
+	for {
+		select {
+		case <-flushChan:
+		case <-retryChan:
+		case <-ctx.Done():
+			return
+		}
+
+		if err := outbox.Flush(servers); err != nil {
+			attempt++
+			d := backoff.Delay(attempt)
+			var rlErr *client.RateLimitError
+			if errors.As(err, &rlErr) && rlErr.RetryAfter > d {
+				d = rlErr.RetryAfter
+			}
+			slog.Warn("unable to send queued changes", "url", servers.Current(), "queued", outbox.Len(), "retry", d.Round(time.Millisecond), "error", err)
+			retryChan = time.After(d)
+			continue
+		}
+
+		attempt = 0
+		retryChan = nil
+	}
+}
+
+func logStateEvent(evt client.StateEvent) {
+
+	switch evt.State {
+	case client.StateConnecting:
+		if evt.Attempt > 0 {
+			slog.Info("reconnecting", "url", evt.URL, "attempt", evt.Attempt+1)
+		}
+	case client.StateConnected:
+		slog.Info("subscriber connected", "url", evt.URL)
+	case client.StateBackingOff:
+		slog.Warn("connection failed", "url", evt.URL, "retry", evt.Delay.Round(time.Millisecond), "error", evt.Err)
+	case client.StateGivingUp:
+		slog.Error("connection failed: giving up", "url", evt.URL, "attempts", evt.Attempt, "error", evt.Err)
+	}
+}
+
 func init() {
-	listenCmd.Flags().StringP("url", "u", "https://127.0.0.1:8989", "The address of the netboard server")
+	listenCmd.Flags().StringSliceP("url", "u", []string{"https://127.0.0.1:8989"}, "The addresses of the netboard servers. The first one is preferred, the others are used when it is down")
 	_ = viper.BindPFlag("listen.url", listenCmd.Flags().Lookup("url"))
 
 	listenCmd.Flags().StringP("cert", "c", "", "Path to the client public key")
@@ -161,4 +952,61 @@ func init() {
 
 	listenCmd.Flags().BoolP("websocket", "w", true, "Use websockets instead of chunked encoding")
 	_ = viper.BindPFlag("listen.websocket", listenCmd.Flags().Lookup("websocket"))
+
+	listenCmd.Flags().Duration("backoff-initial", client.DefaultBackoff.Initial, "Initial delay before reconnecting to the server")
+	_ = viper.BindPFlag("listen.backoff-initial", listenCmd.Flags().Lookup("backoff-initial"))
+
+	listenCmd.Flags().Duration("backoff-max", client.DefaultBackoff.Max, "Maximum delay between two reconnection attempts")
+	_ = viper.BindPFlag("listen.backoff-max", listenCmd.Flags().Lookup("backoff-max"))
+
+	listenCmd.Flags().Int("backoff-max-attempts", 0, "Number of failed reconnection attempts before giving up. 0 means never")
+	_ = viper.BindPFlag("listen.backoff-max-attempts", listenCmd.Flags().Lookup("backoff-max-attempts"))
+
+	listenCmd.Flags().String("outbox", "$HOME/.config/netboard/outbox.json", "Path to the file holding changes not yet sent. Empty keeps them in memory only")
+	_ = viper.BindPFlag("listen.outbox", listenCmd.Flags().Lookup("outbox"))
+
+	listenCmd.Flags().Int("outbox-size", 1, "Maximum number of changes kept while the server is unreachable. 1 only keeps the latest")
+	_ = viper.BindPFlag("listen.outbox-size", listenCmd.Flags().Lookup("outbox-size"))
+
+	listenCmd.Flags().Duration("ttl", 0, "Time to live of the published items. 0 means they never expire")
+	_ = viper.BindPFlag("listen.ttl", listenCmd.Flags().Lookup("ttl"))
+
+	listenCmd.Flags().String("blob-cache", "$HOME/.cache/netboard/blobs", "Path to the directory caching the large items fetched from the server")
+	_ = viper.BindPFlag("listen.blob-cache", listenCmd.Flags().Lookup("blob-cache"))
+
+	listenCmd.Flags().String("download-dir", "", "Path to the directory receiving the copied files, like $HOME/Downloads/netboard. Empty disables file transfer")
+	_ = viper.BindPFlag("listen.download-dir", listenCmd.Flags().Lookup("download-dir"))
+
+	listenCmd.Flags().String("compression", protocol.EncodingZstd, "Compression of the exchanged items. zstd, gzip or none")
+	_ = viper.BindPFlag("listen.compression", listenCmd.Flags().Lookup("compression"))
+
+	listenCmd.Flags().Int("compression-threshold", 1024, "Size in bytes below which the published items are not compressed")
+	_ = viper.BindPFlag("listen.compression-threshold", listenCmd.Flags().Lookup("compression-threshold"))
+
+	listenCmd.Flags().Bool("notify", false, "Show a desktop notification when a remote item is written to the clipboard")
+	_ = viper.BindPFlag("listen.notify", listenCmd.Flags().Lookup("notify"))
+
+	listenCmd.Flags().Duration("notify-interval", 5*time.Second, "Minimum delay between two notifications")
+	_ = viper.BindPFlag("listen.notify-interval", listenCmd.Flags().Lookup("notify-interval"))
+
+	listenCmd.Flags().Int("notify-preview", 80, "Number of characters of the text items shown in the notifications. 0 disables the preview")
+	_ = viper.BindPFlag("listen.notify-preview", listenCmd.Flags().Lookup("notify-preview"))
+
+	listenCmd.Flags().Bool("do-not-disturb", false, "Start with notifications silenced. SIGUSR2 toggles it")
+	_ = viper.BindPFlag("listen.do-not-disturb", listenCmd.Flags().Lookup("do-not-disturb"))
+
+	listenCmd.Flags().Bool("confirm", false, "Wait for remote items to be accepted before writing them to the clipboard")
+	_ = viper.BindPFlag("listen.confirm", listenCmd.Flags().Lookup("confirm"))
+
+	listenCmd.Flags().StringSlice("auto-accept", nil, "Fingerprints or names of the devices whose items are written without confirmation")
+	_ = viper.BindPFlag("listen.auto-accept", listenCmd.Flags().Lookup("auto-accept"))
+
+	listenCmd.Flags().String("direction", string(client.SyncBoth), "Direction of the sync. both, send-only or receive-only")
+	_ = viper.BindPFlag("listen.direction", listenCmd.Flags().Lookup("direction"))
+
+	listenCmd.Flags().Bool("tray", false, "Show an icon in the system tray, with the connection state and the recent items")
+	_ = viper.BindPFlag("listen.tray", listenCmd.Flags().Lookup("tray"))
+
+	listenCmd.Flags().String("control-socket", "$XDG_RUNTIME_DIR/netboard/listen.sock", "Path to the unix socket used to control listen. Empty disables it")
+	_ = viper.BindPFlag("listen.control-socket", listenCmd.Flags().Lookup("control-socket"))
 }
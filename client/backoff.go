@@ -0,0 +1,85 @@
+package client
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"time"
+)
+
+// DefaultBackoff is the Backoff used when none is configured.
+var DefaultBackoff = Backoff{
+	Initial: time.Second,
+	Max:     time.Minute,
+	Factor:  2,
+	Jitter:  0.2,
+}
+
+// Backoff describes how long to wait between
+// successive reconnection attempts.
+type Backoff struct {
+	// Initial is the delay before the first retry.
+	Initial time.Duration
+
+	// Max is the maximum delay between two retries.
+	Max time.Duration
+
+	// Factor is the multiplier applied to the delay
+	// after each failed attempt.
+	Factor float64
+
+	// Jitter is the ratio of randomness added to
+	// or removed from each delay (0.2 means +/- 20%).
+	Jitter float64
+
+	// MaxAttempts is the number of consecutive failed
+	// attempts after which to give up. 0 means never.
+	MaxAttempts int
+}
+
+// Delay returns the delay to wait before the given attempt.
+// Attempt starts at 1.
+func (b Backoff) Delay(attempt int) time.Duration {
+
+	if attempt < 1 {
+		attempt = 1
+	}
+
+	factor := b.Factor
+	if factor < 1 {
+		factor = 1
+	}
+
+	d := float64(b.Initial) * math.Pow(factor, float64(attempt-1))
+	if b.Max > 0 && d > float64(b.Max) {
+		d = float64(b.Max)
+	}
+
+	if b.Jitter > 0 {
+		d += d * b.Jitter * (rand.Float64()*2 - 1) // #nosec
+	}
+
+	return time.Duration(d)
+}
+
+// exhausted returns true if the given number of failed
+// attempts reached MaxAttempts.
+func (b Backoff) exhausted(attempt int) bool {
+	return b.MaxAttempts > 0 && attempt >= b.MaxAttempts
+}
+
+// wait blocks for the given duration or until the
+// context is canceled. It returns false if the context
+// was canceled.
+func wait(ctx context.Context, d time.Duration) bool {
+
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-t.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
@@ -0,0 +1,267 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/primalmotion/netboard/protocol"
+)
+
+// ErrBlobMismatch is returned by FetchBlob when the
+// downloaded content does not match the blob ID.
+var ErrBlobMismatch = errors.New("blob content does not match its id")
+
+// blobTimeout is the maximum time to upload or download a blob.
+const blobTimeout = 10 * time.Minute
+
+// UploadBlob uploads the data of the given item to the blob
+// storage of the server at the given url, using the given HTTP
+// client, unless the server already holds it.
+func UploadBlob(item *protocol.Item, url string, client *http.Client) error {
+
+	ctx, cancel := context.WithTimeout(context.Background(), blobTimeout)
+	defer cancel()
+
+	blobURL := url + "/blobs/" + item.ID
+
+	hr, err := http.NewRequestWithContext(ctx, http.MethodHead, blobURL, nil)
+	if err != nil {
+		return fmt.Errorf("unable to build request: %w", err)
+	}
+
+	head, err := client.Do(hr)
+	if err != nil {
+		return fmt.Errorf("unable to send request: %w", err)
+	}
+	head.Body.Close() // nolint
+
+	if head.StatusCode == http.StatusOK && head.ContentLength == int64(len(item.Data)) {
+		return nil
+	}
+
+	r, err := http.NewRequestWithContext(ctx, http.MethodPut, blobURL, bytes.NewReader(item.Data))
+	if err != nil {
+		return fmt.Errorf("unable to build request: %w", err)
+	}
+	// The server caps the upload with the limit of its type.
+	contentType := item.MIME
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	r.Header.Set("Content-Type", contentType)
+
+	resp, err := client.Do(r)
+	if err != nil {
+		return fmt.Errorf("unable to send request: %w", err)
+	}
+	defer resp.Body.Close() // nolint
+
+	switch resp.StatusCode {
+	case http.StatusRequestEntityTooLarge:
+		return ErrTooLarge
+	case http.StatusTooManyRequests:
+		return newRateLimitError(resp)
+	}
+
+	if resp.StatusCode != http.StatusNoContent {
+		return fmt.Errorf("server rejected the blob: %s", resp.Status)
+	}
+
+	return nil
+}
+
+// FetchBlob downloads the blob with the given id from the server
+// at the given url using the given HTTP client, and returns its
+// content. If maxSize is not 0, ErrTooLarge is returned as soon as
+// the blob exceeds it. Each download writes to its own temporary
+// file in cacheDir, so concurrent fetches of the same blob do not
+// collide. An interrupted download is kept as the partial blob, so
+// the next one resumes where it stopped, and an already downloaded
+// blob is not fetched again.
+func FetchBlob(ctx context.Context, url string, client *http.Client, id string, maxSize int64, cacheDir string) ([]byte, error) {
+
+	if !protocol.IsValidID(id) {
+		return nil, fmt.Errorf("invalid blob id: %s", id)
+	}
+
+	if err := os.MkdirAll(cacheDir, 0700); err != nil {
+		return nil, fmt.Errorf("unable to create blob cache: %w", err)
+	}
+
+	path := filepath.Join(cacheDir, id)
+	partial := path + ".partial"
+
+	if data, err := os.ReadFile(path); err == nil && protocol.ComputeID(data) == id {
+		return data, nil
+	}
+
+	tmp, err := os.CreateTemp(cacheDir, id+".partial-*")
+	if err != nil {
+		return nil, fmt.Errorf("unable to create partial blob: %w", err)
+	}
+	tmp.Close() // nolint
+	name := tmp.Name()
+
+	// The partial blob, if any, is claimed by renaming it, so
+	// only one of the concurrent downloads resumes it.
+	_ = os.Rename(partial, name)
+
+	// Unless the blob is stored, the content downloaded so far is
+	// kept as the partial blob if it is valid, or removed.
+	var stored bool
+	keep := true
+	defer func() {
+		switch {
+		case stored:
+		case keep:
+			os.Rename(name, partial) // nolint
+		default:
+			os.Remove(name) // nolint
+		}
+	}()
+
+	f, err := os.OpenFile(name, os.O_RDWR, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open partial blob: %w", err)
+	}
+	defer f.Close() // nolint
+
+	offset, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, fmt.Errorf("unable to seek partial blob: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, blobTimeout)
+	defer cancel()
+
+	r, err := http.NewRequestWithContext(ctx, http.MethodGet, url+"/blobs/"+id, nil)
+	if err != nil {
+		return nil, fmt.Errorf("unable to build request: %w", err)
+	}
+	if offset > 0 {
+		r.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+
+	resp, err := client.Do(r)
+	if err != nil {
+		return nil, fmt.Errorf("unable to send request: %w", err)
+	}
+	defer resp.Body.Close() // nolint
+
+	switch resp.StatusCode {
+
+	case http.StatusPartialContent:
+
+	case http.StatusOK:
+		// The server ignored the range. Start over.
+		if err := f.Truncate(0); err != nil {
+			return nil, fmt.Errorf("unable to truncate partial blob: %w", err)
+		}
+		if offset, err = f.Seek(0, io.SeekStart); err != nil {
+			return nil, fmt.Errorf("unable to seek partial blob: %w", err)
+		}
+
+	case http.StatusRequestedRangeNotSatisfiable:
+		// The partial blob is bigger than the blob. It is corrupted.
+		keep = false
+		return nil, ErrBlobMismatch
+
+	case http.StatusTooManyRequests:
+		return nil, newRateLimitError(resp)
+
+	default:
+		return nil, fmt.Errorf("server rejected the request: %s", resp.Status)
+	}
+
+	body := io.Reader(resp.Body)
+	if maxSize > 0 {
+		// One more byte is read to tell a blob that
+		// is too large from one of the maximum size.
+		body = io.LimitReader(resp.Body, maxSize-offset+1)
+	}
+
+	n, err := io.Copy(f, body)
+	if err != nil {
+		return nil, fmt.Errorf("unable to download blob: %w", err)
+	}
+
+	if maxSize > 0 && offset+n > maxSize {
+		keep = false
+		return nil, ErrTooLarge
+	}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("unable to seek partial blob: %w", err)
+	}
+
+	h := sha256.New()
+	data, err := io.ReadAll(io.TeeReader(f, h))
+	if err != nil {
+		return nil, fmt.Errorf("unable to read partial blob: %w", err)
+	}
+
+	if hex.EncodeToString(h.Sum(nil)) != id {
+		keep = false
+		return nil, ErrBlobMismatch
+	}
+
+	if err := os.Rename(name, path); err != nil {
+		return nil, fmt.Errorf("unable to store blob: %w", err)
+	}
+	stored = true
+
+	return data, nil
+}
+
+// RemoveCachedBlob removes the blob with the given id from
+// the given cache directory, if it is there.
+func RemoveCachedBlob(cacheDir string, id string) error {
+
+	if !protocol.IsValidID(id) {
+		return fmt.Errorf("invalid blob id: %s", id)
+	}
+
+	if err := os.Remove(filepath.Join(cacheDir, id)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("unable to remove cached blob: %w", err)
+	}
+
+	return nil
+}
+
+// PruneBlobCache removes the blobs of the given
+// cache directory older than the given age.
+func PruneBlobCache(cacheDir string, maxAge time.Duration) error {
+
+	entries, err := os.ReadDir(cacheDir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return fmt.Errorf("unable to list blob cache: %w", err)
+	}
+
+	limit := time.Now().Add(-maxAge)
+
+	for _, e := range entries {
+
+		info, err := e.Info()
+		if err != nil || info.ModTime().After(limit) {
+			continue
+		}
+
+		if err := os.Remove(filepath.Join(cacheDir, e.Name())); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("unable to remove cached blob: %w", err)
+		}
+	}
+
+	return nil
+}
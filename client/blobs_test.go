@@ -0,0 +1,292 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/primalmotion/netboard/protocol"
+)
+
+// blobServer serves the given blob content under the given id,
+// honoring the ranges, and records the requested ranges.
+type blobServer struct {
+	id      string
+	content []byte
+	ranges  []string
+	hits    atomic.Int32
+
+	sync.Mutex
+	*httptest.Server
+}
+
+func newBlobServer(t *testing.T, id string, content []byte) *blobServer {
+
+	t.Helper()
+
+	s := &blobServer{id: id, content: content}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		s.hits.Add(1)
+		s.Lock()
+		s.ranges = append(s.ranges, r.Header.Get("Range"))
+		s.Unlock()
+
+		if r.URL.Path != "/blobs/"+s.id {
+			http.Error(w, "blob not found", http.StatusNotFound)
+			return
+		}
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(s.content))
+	}))
+	t.Cleanup(s.Close)
+
+	return s
+}
+
+func TestFetchBlob(t *testing.T) {
+
+	content := bytes.Repeat([]byte("some blob content "), 100)
+	id := protocol.ComputeID(content)
+
+	tests := []struct {
+		name      string
+		served    []byte
+		partial   []byte
+		cached    []byte
+		maxSize   int64
+		wantErr   error
+		wantRange string
+		wantHits  int32
+	}{
+		{"downloaded", content, nil, nil, 0, nil, "", 1},
+		{"within maximum size", content, nil, nil, int64(len(content)), nil, "", 1},
+		{"already cached", content, nil, content, 0, nil, "", 0},
+		{"corrupted cache", content, nil, []byte("garbage"), 0, nil, "", 1},
+		{"resumed", content, content[:100], nil, 0, nil, "bytes=100-", 1},
+		{"partial bigger than the blob", content, append(bytes.Clone(content), "garbage"...), nil, 0, ErrBlobMismatch, "bytes=1807-", 1},
+		{"content mismatch", []byte("another content"), nil, nil, 0, ErrBlobMismatch, "", 1},
+		{"too large", content, nil, nil, int64(len(content)) - 1, ErrTooLarge, "", 1},
+		{"resumed too large", content, content[:100], nil, 50, ErrTooLarge, "bytes=100-", 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			srv := newBlobServer(t, id, tt.served)
+			dir := t.TempDir()
+
+			if tt.partial != nil {
+				if err := os.WriteFile(filepath.Join(dir, id+".partial"), tt.partial, 0600); err != nil {
+					t.Fatalf("unable to write partial blob: %s", err)
+				}
+			}
+			if tt.cached != nil {
+				if err := os.WriteFile(filepath.Join(dir, id), tt.cached, 0600); err != nil {
+					t.Fatalf("unable to write cached blob: %s", err)
+				}
+			}
+
+			data, err := FetchBlob(context.Background(), srv.URL, srv.Client(), id, tt.maxSize, dir)
+
+			if got := srv.hits.Load(); got != tt.wantHits {
+				t.Fatalf("got %d requests, want %d", got, tt.wantHits)
+			}
+			if tt.wantHits > 0 && srv.ranges[0] != tt.wantRange {
+				t.Fatalf("got range %q, want %q", srv.ranges[0], tt.wantRange)
+			}
+
+			entries, _ := os.ReadDir(dir)
+
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("got error %v, want %s", err, tt.wantErr)
+				}
+				// The invalid content is not kept.
+				if len(entries) != 0 {
+					t.Fatalf("got %d files in the cache, want none", len(entries))
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if !bytes.Equal(data, content) {
+				t.Fatalf("got %d bytes, want %d", len(data), len(content))
+			}
+
+			// Only the blob is left in the cache.
+			if len(entries) != 1 || entries[0].Name() != id {
+				t.Fatalf("unexpected files in the cache: %v", entries)
+			}
+		})
+	}
+}
+
+func TestFetchBlobInterrupted(t *testing.T) {
+
+	content := bytes.Repeat([]byte("some blob content "), 100)
+	id := protocol.ComputeID(content)
+	dir := t.TempDir()
+
+	// The server sends half the blob and drops the connection.
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", "1800")
+		_, _ = w.Write(content[:900])
+	}))
+	defer srv.Close()
+
+	if _, err := FetchBlob(context.Background(), srv.URL, srv.Client(), id, 0, dir); err == nil {
+		t.Fatalf("interrupted download succeeded")
+	}
+
+	// The downloaded content is kept to be resumed.
+	partial, err := os.ReadFile(filepath.Join(dir, id+".partial"))
+	if err != nil {
+		t.Fatalf("unable to read partial blob: %s", err)
+	}
+	if !bytes.Equal(partial, content[:900]) {
+		t.Fatalf("got %d bytes in the partial blob, want 900", len(partial))
+	}
+}
+
+func TestFetchBlobConcurrently(t *testing.T) {
+
+	content := bytes.Repeat([]byte("some blob content "), 10000)
+	id := protocol.ComputeID(content)
+	dir := t.TempDir()
+
+	srv := newBlobServer(t, id, content)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			data, err := FetchBlob(context.Background(), srv.URL, srv.Client(), id, 0, dir)
+			if err == nil && !bytes.Equal(data, content) {
+				err = ErrBlobMismatch
+			}
+			errs <- err
+		}()
+	}
+
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 1 || entries[0].Name() != id {
+		t.Fatalf("unexpected files in the cache: %v", entries)
+	}
+}
+
+func TestRemoveCachedBlob(t *testing.T) {
+
+	content := []byte("some blob content")
+	id := protocol.ComputeID(content)
+	dir := t.TempDir()
+
+	if err := os.WriteFile(filepath.Join(dir, id), content, 0600); err != nil {
+		t.Fatalf("unable to write cached blob: %s", err)
+	}
+
+	tests := []struct {
+		name    string
+		id      string
+		wantErr bool
+	}{
+		{"cached", id, false},
+		{"already removed", id, false},
+		{"invalid id", "../outbox.json", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			err := RemoveCachedBlob(dir, tt.id)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %t", err, tt.wantErr)
+			}
+
+			if _, err := os.Stat(filepath.Join(dir, id)); !errors.Is(err, os.ErrNotExist) {
+				t.Fatalf("blob still cached")
+			}
+		})
+	}
+}
+
+func TestUploadBlob(t *testing.T) {
+
+	item := protocol.NewItem(bytes.Repeat([]byte("some blob content "), 100), time.Now())
+	item.MIME = "image/png"
+
+	tests := []struct {
+		name        string
+		stored      bool
+		putStatus   int
+		wantErr     error
+		wantUploads int
+	}{
+		{"uploaded", false, http.StatusNoContent, nil, 1},
+		{"already stored", true, http.StatusNoContent, nil, 0},
+		{"too large", false, http.StatusRequestEntityTooLarge, ErrTooLarge, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			var uploads int
+			var contentType string
+
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+				if r.URL.Path != "/blobs/"+item.ID {
+					http.Error(w, "blob not found", http.StatusNotFound)
+					return
+				}
+
+				switch r.Method {
+				case http.MethodHead:
+					if !tt.stored {
+						http.Error(w, "blob not found", http.StatusNotFound)
+						return
+					}
+					http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(item.Data))
+				case http.MethodPut:
+					uploads++
+					contentType = r.Header.Get("Content-Type")
+					w.WriteHeader(tt.putStatus)
+				}
+			}))
+			defer srv.Close()
+
+			err := UploadBlob(item, srv.URL, srv.Client())
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+
+			if uploads != tt.wantUploads {
+				t.Fatalf("got %d uploads, want %d", uploads, tt.wantUploads)
+			}
+			if uploads > 0 && contentType != item.MIME {
+				t.Fatalf("got content type %s, want %s", contentType, item.MIME)
+			}
+		})
+	}
+}
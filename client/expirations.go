@@ -0,0 +1,62 @@
+package client
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Expirations keeps track of the items with a time to live
+// that have been written to or copied from the local clipboard,
+// and notifies when they expire.
+type Expirations struct {
+	items map[string][]string
+	ch    chan string
+
+	sync.Mutex
+}
+
+// NewExpirations returns a new Expirations.
+func NewExpirations() *Expirations {
+	return &Expirations{
+		items: map[string][]string{},
+		ch:    make(chan string),
+	}
+}
+
+// Track records that the item with the given id, expiring at the
+// given time, is known in the local clipboard under the given ids.
+// The id will be sent to the channel returned by C once it expires,
+// unless the given context is canceled before.
+func (e *Expirations) Track(ctx context.Context, id string, expires time.Time, ids ...string) {
+
+	e.Lock()
+	e.items[id] = append([]string{id}, ids...)
+	e.Unlock()
+
+	time.AfterFunc(time.Until(expires), func() {
+		select {
+		case e.ch <- id:
+		case <-ctx.Done():
+		}
+	})
+}
+
+// C returns the channel receiving the ids of the expired items.
+func (e *Expirations) C() <-chan string {
+	return e.ch
+}
+
+// Take returns the local ids of the item with the given id
+// and stops tracking it. It returns nil if the item is not
+// tracked, or has already been taken.
+func (e *Expirations) Take(id string) []string {
+
+	e.Lock()
+	defer e.Unlock()
+
+	ids := e.items[id]
+	delete(e.items, id)
+
+	return ids
+}
@@ -0,0 +1,67 @@
+package client
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestExpirations(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		ttl      time.Duration
+		ids      []string
+		cancel   bool
+		wantSent bool
+		wantIDs  []string
+	}{
+		{"expired", 10 * time.Millisecond, []string{"loose"}, false, true, []string{"a", "loose"}},
+		{"already expired", -time.Second, nil, false, true, []string{"a"}},
+		{"context canceled", 10 * time.Millisecond, nil, true, false, []string{"a"}},
+		{"not expired yet", time.Hour, nil, false, false, []string{"a"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			e := NewExpirations()
+			e.Track(ctx, "a", time.Now().Add(tt.ttl), tt.ids...)
+
+			if tt.cancel {
+				cancel()
+				// Once the item expired, nothing is waiting for
+				// the expiration, which is dropped as the context
+				// is canceled.
+				time.Sleep(5 * tt.ttl)
+			}
+
+			select {
+			case id := <-e.C():
+				if !tt.wantSent {
+					t.Fatalf("got expiration of %s, want none", id)
+				}
+				if id != "a" {
+					t.Fatalf("got expiration of %s, want a", id)
+				}
+			case <-time.After(100 * time.Millisecond):
+				if tt.wantSent {
+					t.Fatalf("no expiration received")
+				}
+			}
+
+			if got := e.Take("a"); !reflect.DeepEqual(got, tt.wantIDs) {
+				t.Fatalf("got ids %v, want %v", got, tt.wantIDs)
+			}
+
+			// An item is only taken once.
+			if got := e.Take("a"); got != nil {
+				t.Fatalf("got ids %v after the item was taken", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,262 @@
+package client
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// LocalPaths returns the paths of the local files of the given
+// URI list, as copied by file managers. It returns nil if the
+// list contains anything but local files.
+func LocalPaths(uriList []byte) []string {
+
+	hostname, _ := os.Hostname()
+
+	var paths []string
+
+	scanner := bufio.NewScanner(bytes.NewReader(uriList))
+	for scanner.Scan() {
+
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		u, err := url.Parse(line)
+		if err != nil || u.Scheme != "file" {
+			return nil
+		}
+
+		if u.Host != "" && u.Host != "localhost" && u.Host != hostname {
+			return nil
+		}
+
+		paths = append(paths, filepath.Clean(u.Path))
+	}
+
+	return paths
+}
+
+// URIList returns the URI list and the plain
+// text list of the given local paths.
+func URIList(paths []string) ([]byte, []byte) {
+
+	uris := bytes.NewBuffer(nil)
+	text := bytes.NewBuffer(nil)
+
+	for _, p := range paths {
+		u := url.URL{Scheme: "file", Path: p}
+		uris.WriteString(u.String() + "\r\n")
+		text.WriteString(p + "\n")
+	}
+
+	return uris.Bytes(), text.Bytes()
+}
+
+// PackFiles archives the given files and directories. The archive
+// only depends on the names, contents, permissions and modification
+// times of the files, so packing files unpacked by UnpackFiles gives
+// the same archive. If max is greater than 0, it returns ErrTooLarge
+// if the files are bigger than max bytes.
+func PackFiles(paths []string, max int64) ([]byte, error) {
+
+	buf := bytes.NewBuffer(nil)
+	tw := tar.NewWriter(buf)
+
+	var total int64
+
+	for _, root := range paths {
+
+		base := filepath.Dir(root)
+
+		err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+
+			if err != nil {
+				return err
+			}
+
+			// Only files and directories make sense on
+			// another machine. Links and devices are skipped.
+			if !d.IsDir() && !d.Type().IsRegular() {
+				return nil
+			}
+
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+
+			name, err := filepath.Rel(base, path)
+			if err != nil {
+				return err
+			}
+
+			hdr := &tar.Header{
+				Name:    filepath.ToSlash(name),
+				Mode:    int64(info.Mode().Perm()),
+				ModTime: info.ModTime().Truncate(time.Second),
+			}
+
+			if d.IsDir() {
+				hdr.Typeflag = tar.TypeDir
+				hdr.Name += "/"
+				return tw.WriteHeader(hdr)
+			}
+
+			total += info.Size()
+			if max > 0 && total > max {
+				return ErrTooLarge
+			}
+
+			hdr.Typeflag = tar.TypeReg
+			hdr.Size = info.Size()
+			if err := tw.WriteHeader(hdr); err != nil {
+				return err
+			}
+
+			f, err := os.Open(path)
+			if err != nil {
+				return err
+			}
+			defer f.Close() // nolint
+
+			_, err = io.CopyN(tw, f, hdr.Size)
+			return err
+		})
+
+		if err != nil {
+			if errors.Is(err, ErrTooLarge) {
+				return nil, err
+			}
+			return nil, fmt.Errorf("unable to pack %s: %w", root, err)
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return nil, fmt.Errorf("unable to pack files: %w", err)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// UnpackFiles extracts the files archived by PackFiles in the
+// given directory, and returns the paths of the top level files
+// and directories.
+func UnpackFiles(data []byte, dir string) ([]string, error) {
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("unable to create download directory: %w", err)
+	}
+
+	var roots []string
+	seen := map[string]struct{}{}
+
+	// Directories are written before their content, which updates
+	// their modification time, and may need to be writable while
+	// not being so in the archive. Their headers are applied last.
+	dirs := map[string]*tar.Header{}
+
+	tr := tar.NewReader(bytes.NewReader(data))
+
+	for {
+
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("unable to unpack files: %w", err)
+		}
+
+		name := filepath.FromSlash(strings.TrimSuffix(hdr.Name, "/"))
+		if !filepath.IsLocal(name) {
+			return nil, fmt.Errorf("unable to unpack files: invalid name %s", hdr.Name)
+		}
+
+		// PackFiles only archives files and directories.
+		// Anything else is skipped.
+		if hdr.Typeflag != tar.TypeDir && hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		path := filepath.Join(dir, name)
+
+		root := filepath.Join(dir, strings.SplitN(name, string(filepath.Separator), 2)[0])
+		if _, ok := seen[root]; !ok {
+			seen[root] = struct{}{}
+			roots = append(roots, root)
+		}
+
+		switch hdr.Typeflag {
+
+		case tar.TypeDir:
+			if err := os.MkdirAll(path, 0700); err != nil {
+				return nil, fmt.Errorf("unable to create directory %s: %w", name, err)
+			}
+			// It may exist from a previous unpack, with its final mode.
+			if err := os.Chmod(path, 0700); err != nil {
+				return nil, fmt.Errorf("unable to set mode of directory %s: %w", name, err)
+			}
+			dirs[path] = hdr
+
+		case tar.TypeReg:
+			if err := writeFile(path, tr, fs.FileMode(hdr.Mode).Perm()); err != nil {
+				return nil, fmt.Errorf("unable to write file %s: %w", name, err)
+			}
+			if err := os.Chtimes(path, hdr.ModTime, hdr.ModTime); err != nil {
+				return nil, fmt.Errorf("unable to set time of file %s: %w", name, err)
+			}
+		}
+	}
+
+	for path, hdr := range dirs {
+		if err := os.Chmod(path, fs.FileMode(hdr.Mode).Perm()); err != nil {
+			return nil, fmt.Errorf("unable to set mode of directory %s: %w", path, err)
+		}
+		if err := os.Chtimes(path, hdr.ModTime, hdr.ModTime); err != nil {
+			return nil, fmt.Errorf("unable to set time of directory %s: %w", path, err)
+		}
+	}
+
+	return roots, nil
+}
+
+func writeFile(path string, r io.Reader, mode fs.FileMode) error {
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+
+	// It may exist from a previous unpack, and not be writable.
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, mode)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close() // nolint
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	// The mode given to OpenFile is altered by
+	// the umask, but it is part of the archive.
+	return os.Chmod(path, mode)
+}
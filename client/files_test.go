@@ -0,0 +1,247 @@
+package client
+
+import (
+	"archive/tar"
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// tarEntry is an entry of a test archive.
+type tarEntry struct {
+	name     string
+	typeflag byte
+	mode     int64
+	content  string
+}
+
+func makeArchive(t *testing.T, entries ...tarEntry) []byte {
+
+	t.Helper()
+
+	buf := bytes.NewBuffer(nil)
+	tw := tar.NewWriter(buf)
+
+	for _, e := range entries {
+		hdr := &tar.Header{
+			Name:     e.name,
+			Typeflag: e.typeflag,
+			Mode:     e.mode,
+			Size:     int64(len(e.content)),
+			ModTime:  time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+		}
+		if e.typeflag == tar.TypeSymlink {
+			hdr.Linkname, hdr.Size = e.content, 0
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatalf("unable to write header: %s", err)
+		}
+		if hdr.Size > 0 {
+			if _, err := tw.Write([]byte(e.content)); err != nil {
+				t.Fatalf("unable to write content: %s", err)
+			}
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		t.Fatalf("unable to close archive: %s", err)
+	}
+
+	return buf.Bytes()
+}
+
+func TestUnpackFiles(t *testing.T) {
+
+	tests := []struct {
+		name      string
+		entries   []tarEntry
+		wantErr   string
+		wantRoots []string
+		wantFiles map[string]string
+	}{
+		{
+			"files and directories",
+			[]tarEntry{
+				{"notes.txt", tar.TypeReg, 0600, "notes"},
+				{"photos/", tar.TypeDir, 0755, ""},
+				{"photos/cat.png", tar.TypeReg, 0644, "cat"},
+				{"photos/2024/", tar.TypeDir, 0755, ""},
+				{"photos/2024/dog.png", tar.TypeReg, 0644, "dog"},
+			},
+			"",
+			[]string{"notes.txt", "photos"},
+			map[string]string{"notes.txt": "notes", "photos/cat.png": "cat", "photos/2024/dog.png": "dog"},
+		},
+		{
+			"parent directory",
+			[]tarEntry{{"../escaped.txt", tar.TypeReg, 0600, "escaped"}},
+			"invalid name ../escaped.txt",
+			nil,
+			nil,
+		},
+		{
+			"parent directory in the path",
+			[]tarEntry{{"photos/../../escaped.txt", tar.TypeReg, 0600, "escaped"}},
+			"invalid name photos/../../escaped.txt",
+			nil,
+			nil,
+		},
+		{
+			"absolute path",
+			[]tarEntry{{"/tmp/escaped.txt", tar.TypeReg, 0600, "escaped"}},
+			"invalid name /tmp/escaped.txt",
+			nil,
+			nil,
+		},
+		{
+			"links are skipped",
+			[]tarEntry{
+				{"notes.txt", tar.TypeReg, 0600, "notes"},
+				{"passwd", tar.TypeSymlink, 0777, "/etc/passwd"},
+			},
+			"",
+			[]string{"notes.txt"},
+			map[string]string{"notes.txt": "notes"},
+		},
+		{
+			"read only directory",
+			[]tarEntry{
+				{"photos/", tar.TypeDir, 0500, ""},
+				{"photos/cat.png", tar.TypeReg, 0400, "cat"},
+			},
+			"",
+			[]string{"photos"},
+			map[string]string{"photos/cat.png": "cat"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			base := t.TempDir()
+			dir := filepath.Join(base, "downloads")
+
+			// The read only directories must be
+			// writable again to be cleaned up.
+			t.Cleanup(func() {
+				_ = filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+					if err == nil && d.IsDir() {
+						_ = os.Chmod(path, 0700)
+					}
+					return nil
+				})
+			})
+
+			roots, err := UnpackFiles(makeArchive(t, tt.entries...), dir)
+
+			if _, err := os.Stat(filepath.Join(base, "escaped.txt")); err == nil {
+				t.Fatalf("file written outside of the directory")
+			}
+
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			var wantRoots []string
+			for _, r := range tt.wantRoots {
+				wantRoots = append(wantRoots, filepath.Join(dir, r))
+			}
+			if !reflect.DeepEqual(roots, wantRoots) {
+				t.Fatalf("got roots %v, want %v", roots, wantRoots)
+			}
+
+			files := map[string]string{}
+			_ = filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+				if err != nil || d.IsDir() {
+					return err
+				}
+				data, err := os.ReadFile(path)
+				if err != nil {
+					t.Fatalf("unable to read %s: %s", path, err)
+				}
+				name, _ := filepath.Rel(dir, path)
+				files[filepath.ToSlash(name)] = string(data)
+				return nil
+			})
+			if !reflect.DeepEqual(files, tt.wantFiles) {
+				t.Fatalf("got files %v, want %v", files, tt.wantFiles)
+			}
+
+			// The permissions are the ones of the archive.
+			for _, e := range tt.entries {
+				if e.typeflag == tar.TypeSymlink {
+					continue
+				}
+				info, err := os.Stat(filepath.Join(dir, filepath.FromSlash(e.name)))
+				if err != nil {
+					t.Fatalf("unable to stat %s: %s", e.name, err)
+				}
+				if int64(info.Mode().Perm()) != e.mode {
+					t.Fatalf("%s has mode %o, want %o", e.name, info.Mode().Perm(), e.mode)
+				}
+			}
+		})
+	}
+}
+
+func TestPackUnpackFiles(t *testing.T) {
+
+	src := t.TempDir()
+
+	if err := os.MkdirAll(filepath.Join(src, "photos", "2024"), 0700); err != nil {
+		t.Fatalf("unable to create directories: %s", err)
+	}
+	for name, content := range map[string]string{
+		"notes.txt":           "notes",
+		"photos/cat.png":      "cat",
+		"photos/2024/dog.png": "dog",
+	} {
+		if err := os.WriteFile(filepath.Join(src, filepath.FromSlash(name)), []byte(content), 0600); err != nil {
+			t.Fatalf("unable to write file: %s", err)
+		}
+	}
+
+	paths := []string{filepath.Join(src, "notes.txt"), filepath.Join(src, "photos")}
+
+	packed, err := PackFiles(paths, 0)
+	if err != nil {
+		t.Fatalf("unable to pack files: %s", err)
+	}
+
+	if _, err := PackFiles(paths, 10); err != ErrTooLarge {
+		t.Fatalf("got error %v, want %s", err, ErrTooLarge)
+	}
+
+	dst := t.TempDir()
+
+	// Unpacking twice overwrites the files.
+	for i := 0; i < 2; i++ {
+		roots, err := UnpackFiles(packed, dst)
+		if err != nil {
+			t.Fatalf("unable to unpack files: %s", err)
+		}
+		if len(roots) != 2 {
+			t.Fatalf("got roots %v, want 2", roots)
+		}
+	}
+
+	// Packing the unpacked files gives the same archive.
+	repacked, err := PackFiles([]string{filepath.Join(dst, "notes.txt"), filepath.Join(dst, "photos")}, 0)
+	if err != nil {
+		t.Fatalf("unable to pack files: %s", err)
+	}
+	if !bytes.Equal(packed, repacked) {
+		t.Fatalf("repacked archive differs")
+	}
+}
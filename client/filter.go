@@ -0,0 +1,205 @@
+package client
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/primalmotion/netboard/protocol"
+)
+
+// BuiltinDenyRules are the deny rules that can be
+// enabled by name in FilterConfig.DenyRules.
+var BuiltinDenyRules = map[string]string{
+	"aws-access-key": `\b(AKIA|ASIA)[0-9A-Z]{16}\b`,
+	"private-key":    `-----BEGIN ([A-Z0-9]+ )*PRIVATE KEY( BLOCK)?-----`,
+	"jwt":            `\beyJ[A-Za-z0-9_-]+\.eyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]*`,
+}
+
+// FilterConfig holds the configuration of a Filter.
+type FilterConfig struct {
+
+	// MaxSize is the maximum size of an item in bytes.
+	// 0 means no limit.
+	MaxSize int `mapstructure:"max-size"`
+
+	// SecretHints skips items flagged as secret
+	// by the application that copied them.
+	SecretHints bool `mapstructure:"secret-hints"`
+
+	// SecretTTL publishes the items flagged as secret with
+	// the given time to live instead of skipping them.
+	SecretTTL time.Duration `mapstructure:"secret-ttl"`
+
+	// TTLRules give a time to live to the matching items.
+	TTLRules []TTLRule `mapstructure:"ttl-rules"`
+
+	// DenyRules are the names of the builtin rules
+	// to enable. See BuiltinDenyRules.
+	DenyRules []string `mapstructure:"deny-rules"`
+
+	// DenyPatterns are additional named regular
+	// expressions. Items matching one are skipped.
+	DenyPatterns map[string]string `mapstructure:"deny-patterns"`
+
+	// AllowTypes are the MIME type patterns (like text/*)
+	// allowed to be published. Empty means all.
+	AllowTypes []string `mapstructure:"allow-types"`
+
+	// DenyTypes are the MIME type patterns
+	// not allowed to be published.
+	DenyTypes []string `mapstructure:"deny-types"`
+}
+
+// A TTLRule gives a time to live to the items matching
+// either a builtin deny rule or a pattern.
+type TTLRule struct {
+	Rule    string        `mapstructure:"rule"`
+	Pattern string        `mapstructure:"pattern"`
+	TTL     time.Duration `mapstructure:"ttl"`
+}
+
+type denyRule struct {
+	name string
+	re   *regexp.Regexp
+}
+
+type ttlRule struct {
+	re  *regexp.Regexp
+	ttl time.Duration
+}
+
+// A Filter decides which local clipboard items
+// can be published, and for how long.
+type Filter struct {
+	cfg      FilterConfig
+	rules    []denyRule
+	ttlRules []ttlRule
+}
+
+// NewFilter returns a new Filter from the given configuration.
+func NewFilter(cfg FilterConfig) (*Filter, error) {
+
+	f := &Filter{
+		cfg: cfg,
+	}
+
+	for _, name := range cfg.DenyRules {
+		expr, ok := BuiltinDenyRules[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown builtin deny rule '%s'", name)
+		}
+		f.rules = append(f.rules, denyRule{name: name, re: regexp.MustCompile(expr)})
+	}
+
+	names := make([]string, 0, len(cfg.DenyPatterns))
+	for name := range cfg.DenyPatterns {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		re, err := regexp.Compile(cfg.DenyPatterns[name])
+		if err != nil {
+			return nil, fmt.Errorf("invalid deny pattern '%s': %w", name, err)
+		}
+		f.rules = append(f.rules, denyRule{name: name, re: re})
+	}
+
+	for i, r := range cfg.TTLRules {
+
+		expr := r.Pattern
+		if r.Rule != "" {
+			var ok bool
+			if expr, ok = BuiltinDenyRules[r.Rule]; !ok {
+				return nil, fmt.Errorf("ttl rule %d: unknown builtin deny rule '%s'", i, r.Rule)
+			}
+		}
+
+		if expr == "" || r.TTL <= 0 {
+			return nil, fmt.Errorf("ttl rule %d: needs a rule or a pattern, and a positive ttl", i)
+		}
+
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("ttl rule %d: invalid pattern: %w", i, err)
+		}
+
+		f.ttlRules = append(f.ttlRules, ttlRule{re: re, ttl: r.TTL})
+	}
+
+	for _, p := range append(append([]string{}, cfg.AllowTypes...), cfg.DenyTypes...) {
+		if _, err := path.Match(p, ""); err != nil {
+			return nil, fmt.Errorf("invalid type pattern '%s': %w", p, err)
+		}
+	}
+
+	return f, nil
+}
+
+// Check returns a non empty reason if the given item must not
+// be published. secret tells if the item has been flagged as
+// secret by the application that copied it.
+func (f *Filter) Check(item *protocol.Item, secret bool) string {
+
+	if secret && f.cfg.SecretHints && f.cfg.SecretTTL <= 0 {
+		return "flagged as secret by the application that copied it"
+	}
+
+	if f.cfg.MaxSize > 0 && len(item.Data) > f.cfg.MaxSize {
+		return fmt.Sprintf("size of %d bytes exceeds the maximum of %d bytes", len(item.Data), f.cfg.MaxSize)
+	}
+
+	if len(f.cfg.AllowTypes) > 0 && !matchType(f.cfg.AllowTypes, item.MIME) {
+		return fmt.Sprintf("type %s is not allowed", item.MIME)
+	}
+
+	if matchType(f.cfg.DenyTypes, item.MIME) {
+		return fmt.Sprintf("type %s is denied", item.MIME)
+	}
+
+	for _, r := range f.rules {
+		if r.re.Match(item.Data) {
+			return fmt.Sprintf("content matches deny rule '%s'", r.name)
+		}
+	}
+
+	return ""
+}
+
+// TTL returns the time to live to give to the given item, or 0
+// if it should never expire. When several rules match, the
+// shortest time to live wins.
+func (f *Filter) TTL(item *protocol.Item, secret bool) time.Duration {
+
+	var ttl time.Duration
+
+	shortest := func(d time.Duration) {
+		if ttl == 0 || d < ttl {
+			ttl = d
+		}
+	}
+
+	if secret && f.cfg.SecretHints && f.cfg.SecretTTL > 0 {
+		shortest(f.cfg.SecretTTL)
+	}
+
+	for _, r := range f.ttlRules {
+		if r.re.Match(item.Data) {
+			shortest(r.ttl)
+		}
+	}
+
+	return ttl
+}
+
+func matchType(patterns []string, mime string) bool {
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, mime); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,79 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/primalmotion/netboard/protocol"
+)
+
+// FetchHistory retrieves at most limit items of the history
+// of the server, most recent first. The items do not hold their
+// content, but their Size is set. If limit is 0, all the items
+// kept by the server are returned.
+func FetchHistory(serverURL string, client *http.Client, limit int) ([]*protocol.Item, error) {
+
+	items := []*protocol.Item{}
+	if err := fetchJSON(serverURL+"/history?limit="+strconv.Itoa(limit), client, &items); err != nil {
+		return nil, err
+	}
+
+	return items, nil
+}
+
+// FetchHistoryItem retrieves the item with the given
+// id from the history of the server, with its content.
+// It returns an error if the server returns another item,
+// or if the content does not match its id.
+func FetchHistoryItem(serverURL string, client *http.Client, id string) (*protocol.Item, error) {
+
+	if !protocol.IsValidID(id) {
+		return nil, fmt.Errorf("invalid item id: %s", id)
+	}
+
+	item := &protocol.Item{}
+	if err := fetchJSON(serverURL+"/history/"+url.PathEscape(id), client, item); err != nil {
+		return nil, err
+	}
+
+	if item.ID != id {
+		return nil, fmt.Errorf("server returned another item: %s", item.ID)
+	}
+
+	if !item.Blob && protocol.ComputeID(item.Data) != id {
+		return nil, fmt.Errorf("item content does not match its id")
+	}
+
+	return item, nil
+}
+
+func fetchJSON(u string, client *http.Client, out any) error {
+
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+
+	r, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return fmt.Errorf("unable to build request: %w", err)
+	}
+
+	resp, err := client.Do(r)
+	if err != nil {
+		return fmt.Errorf("unable to send request: %w", err)
+	}
+	defer resp.Body.Close() // nolint
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("server rejected the request: %s", resp.Status)
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("unable to decode response: %w", err)
+	}
+
+	return nil
+}
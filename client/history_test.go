@@ -0,0 +1,65 @@
+package client
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/primalmotion/netboard/protocol"
+)
+
+func TestFetchHistoryItem(t *testing.T) {
+
+	item := protocol.NewItem([]byte("hello"), time.Now())
+	other := protocol.NewItem([]byte("other"), time.Now())
+
+	tampered := *item
+	tampered.Data = []byte("tampered")
+
+	blob := *item
+	blob.Blob = true
+	blob.Data = nil
+
+	tests := []struct {
+		name    string
+		id      string
+		served  *protocol.Item
+		wantErr string
+	}{
+		{"matching item", item.ID, item, ""},
+		{"blob item", item.ID, &blob, ""},
+		{"invalid id", "../../etc", item, "invalid item id"},
+		{"short id", item.ID[:12], item, "invalid item id"},
+		{"other item", item.ID, other, "server returned another item"},
+		{"tampered content", item.ID, &tampered, "does not match its id"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				_ = json.NewEncoder(w).Encode(tt.served)
+			}))
+			defer srv.Close()
+
+			got, err := FetchHistoryItem(srv.URL, srv.Client(), tt.id)
+
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if got.ID != tt.id {
+				t.Fatalf("got item %s, want %s", got.ID, tt.id)
+			}
+		})
+	}
+}
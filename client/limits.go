@@ -0,0 +1,40 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/primalmotion/netboard/protocol"
+)
+
+// FetchLimits retrieves the limits enforced by the server
+// on published items, using the given HTTP client.
+func FetchLimits(url string, client *http.Client) (*protocol.Limits, error) {
+
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+
+	r, err := http.NewRequestWithContext(ctx, http.MethodGet, url+"/limits", nil)
+	if err != nil {
+		return nil, fmt.Errorf("unable to build request: %w", err)
+	}
+
+	resp, err := client.Do(r)
+	if err != nil {
+		return nil, fmt.Errorf("unable to send request: %w", err)
+	}
+	defer resp.Body.Close() // nolint
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("server rejected the request: %s", resp.Status)
+	}
+
+	limits := &protocol.Limits{}
+	if err := json.NewDecoder(resp.Body).Decode(limits); err != nil {
+		return nil, fmt.Errorf("unable to decode limits: %w", err)
+	}
+
+	return limits, nil
+}
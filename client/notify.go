@@ -0,0 +1,228 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/primalmotion/netboard/protocol"
+)
+
+const (
+	// notifyTimeout is the maximum execution time of notify-send.
+	notifyTimeout = 5 * time.Second
+
+	// stagedNotifyTimeout is the maximum time a notification
+	// of a staged item waits for the user to accept it.
+	stagedNotifyTimeout = 10 * time.Minute
+)
+
+var markupReplacer = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+// A Notifier shows desktop notifications when remote items are
+// written to the clipboard, using notify-send, which talks to the
+// freedesktop notification service. At most one notification is
+// shown per interval: the updates received in between are only
+// counted in the next one.
+type Notifier struct {
+	interval     time.Duration
+	preview      int
+	last         time.Time
+	skipped      int
+	doNotDisturb bool
+
+	sync.Mutex
+}
+
+// NewNotifier returns a Notifier showing at most one notification
+// per interval. The notifications include a preview of the textual
+// items, truncated to preview characters. 0 disables the preview.
+func NewNotifier(interval time.Duration, preview int) (*Notifier, error) {
+
+	if _, err := exec.LookPath("notify-send"); err != nil {
+		return nil, fmt.Errorf("unable to find notify-send binary: install libnotify to get notifications")
+	}
+
+	return &Notifier{
+		interval: interval,
+		preview:  preview,
+	}, nil
+}
+
+// SetDoNotDisturb enables or disables do not disturb. While
+// enabled, no notification is shown.
+func (n *Notifier) SetDoNotDisturb(enabled bool) {
+
+	n.Lock()
+	defer n.Unlock()
+
+	n.doNotDisturb = enabled
+	n.skipped = 0
+}
+
+// DoNotDisturb returns true if do not disturb is enabled.
+func (n *Notifier) DoNotDisturb() bool {
+
+	n.Lock()
+	defer n.Unlock()
+
+	return n.doNotDisturb
+}
+
+// Notify shows a notification telling the given item was written
+// to the clipboard, unless do not disturb is enabled or one was
+// shown less than an interval ago. It does not wait for it to be
+// shown.
+func (n *Notifier) Notify(item *protocol.Item) {
+
+	n.Lock()
+	defer n.Unlock()
+
+	if n.doNotDisturb {
+		return
+	}
+
+	if time.Since(n.last) < n.interval {
+		n.skipped++
+		return
+	}
+
+	summary := fmt.Sprintf("Clipboard updated from %s", deviceName(item))
+	if n.skipped > 0 {
+		summary = fmt.Sprintf("%s (+%d more)", summary, n.skipped)
+	}
+
+	body := n.describe(item)
+
+	n.last = time.Now()
+	n.skipped = 0
+
+	go func() {
+
+		ctx, cancel := context.WithTimeout(context.Background(), notifyTimeout)
+		defer cancel()
+
+		cmd := exec.CommandContext(ctx, "notify-send", "--app-name=netboard", "--urgency=low", "--icon=edit-paste", summary, body) // #nosec
+		_ = cmd.Run()
+	}()
+}
+
+// NotifyStaged shows a notification telling the given item waits
+// to be accepted, with an action calling accept when clicked. It
+// is not rate limited, but it is not shown in do not disturb mode.
+// It does not wait for it to be shown.
+func (n *Notifier) NotifyStaged(item *protocol.Item, accept func()) {
+
+	n.Lock()
+	defer n.Unlock()
+
+	if n.doNotDisturb {
+		return
+	}
+
+	summary := fmt.Sprintf("Clipboard item from %s waiting", deviceName(item))
+	body := n.describe(item)
+
+	n.last = time.Now()
+
+	go func() {
+
+		ctx, cancel := context.WithTimeout(context.Background(), stagedNotifyTimeout)
+		defer cancel()
+
+		// notify-send prints the name of the
+		// clicked action, once the user chose.
+		cmd := exec.CommandContext(ctx, "notify-send", "--app-name=netboard", "--icon=edit-paste", "--wait", "--action=accept=Accept", summary, body) // #nosec
+		out, err := cmd.Output()
+		if err != nil {
+			return
+		}
+
+		if strings.TrimSpace(string(out)) == "accept" {
+			accept()
+		}
+	}()
+}
+
+// describe returns the body of the notification of the given item.
+// Items that expire, like the secrets copied from password managers,
+// are never previewed.
+func (n *Notifier) describe(item *protocol.Item) string {
+
+	switch {
+
+	case item.MIME == protocol.FilesMIME:
+		return "Copied files"
+
+	case n.preview > 0 && item.Expires == nil && len(item.Data) > 0 && strings.HasPrefix(item.MIME, "text/") && utf8.Valid(item.Data):
+		// The notification servers may interpret some markup.
+		return markupReplacer.Replace(truncate(item.Data, n.preview))
+
+	}
+
+	// The blobs not downloaded yet only have their size.
+	size := int64(len(item.Data))
+	if item.Blob && size == 0 {
+		size = item.Size
+	}
+
+	if item.MIME == "" {
+		return formatSize(size)
+	}
+
+	return fmt.Sprintf("%s, %s", item.MIME, formatSize(size))
+}
+
+// deviceName returns the name of the device
+// that published the given item.
+func deviceName(item *protocol.Item) string {
+
+	if item.OriginName != "" {
+		return item.OriginName
+	}
+
+	if len(item.Origin) > 12 {
+		return item.Origin[:12]
+	}
+
+	if item.Origin != "" {
+		return item.Origin
+	}
+
+	return "another device"
+}
+
+// truncate returns the first max characters of the given
+// text on a single line, with an ellipsis if truncated.
+func truncate(data []byte, max int) string {
+
+	text := strings.Join(strings.FieldsFunc(string(data), unicode.IsSpace), " ")
+
+	if utf8.RuneCountInString(text) <= max {
+		return text
+	}
+
+	return string([]rune(text)[:max]) + "…"
+}
+
+func formatSize(size int64) string {
+
+	const unit = 1024
+
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+
+	return fmt.Sprintf("%.1f %ciB", float64(size)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,235 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/primalmotion/netboard/protocol"
+)
+
+// An Outbox is a bounded queue of local clipboard changes
+// that could not be published yet. If it has a path, the
+// queued entries are persisted so they survive restarts.
+// The entry just copied is only persisted once it could not
+// be published, and the expiring entries never are, so the
+// secrets do not end up on the disk.
+type Outbox struct {
+	path    string
+	size    int
+	entries []*protocol.Item
+
+	// live is true while the first entry was pushed into an
+	// empty outbox and no attempt to publish it failed yet.
+	// The other entries are replayed.
+	live bool
+
+	sync.Mutex
+}
+
+// NewOutbox returns a new Outbox holding at most size entries.
+// When full, the oldest entry is dropped. If path is not empty,
+// the existing entries are loaded from it and the queued ones
+// are written back to it.
+func NewOutbox(path string, size int) (*Outbox, error) {
+
+	if size < 1 {
+		size = 1
+	}
+
+	o := &Outbox{
+		path: path,
+		size: size,
+	}
+
+	if path == "" {
+		return o, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return o, nil
+		}
+		return nil, fmt.Errorf("unable to read outbox: %w", err)
+	}
+
+	if err := json.Unmarshal(data, &o.entries); err != nil {
+		return nil, fmt.Errorf("unable to decode outbox: %w", err)
+	}
+
+	if len(o.entries) > size {
+		o.entries = o.entries[len(o.entries)-size:]
+	}
+
+	return o, nil
+}
+
+// Push adds a new item to the outbox.
+func (o *Outbox) Push(item *protocol.Item) error {
+
+	o.Lock()
+	defer o.Unlock()
+
+	if len(o.entries) == 0 {
+		o.live = true
+	}
+
+	o.entries = append(o.entries, item)
+	if len(o.entries) > o.size {
+		o.entries = o.entries[len(o.entries)-o.size:]
+		o.live = false
+	}
+
+	return o.save()
+}
+
+// Peek returns the oldest item without removing it. It also
+// returns true if the item is replayed: it was not just copied,
+// because it was loaded from disk, queued behind other items,
+// or could not be published at the first attempt.
+func (o *Outbox) Peek() (*protocol.Item, bool, bool) {
+
+	o.Lock()
+	defer o.Unlock()
+
+	if len(o.entries) == 0 {
+		return nil, false, false
+	}
+
+	return o.entries[0], !o.live, true
+}
+
+// Pop removes the oldest item.
+func (o *Outbox) Pop() error {
+
+	o.Lock()
+	defer o.Unlock()
+
+	if len(o.entries) == 0 {
+		return nil
+	}
+
+	o.entries = o.entries[1:]
+	o.live = false
+
+	return o.save()
+}
+
+// Len returns the number of items in the outbox.
+func (o *Outbox) Len() int {
+
+	o.Lock()
+	defer o.Unlock()
+
+	return len(o.entries)
+}
+
+// Flush publishes the entries of the outbox, oldest first.
+// The server stamps the live entries with the time it receives
+// them, and only orders the replayed ones by the time they were
+// copied at. Replayed entries rejected because the server already
+// holds a newer value, expired entries and entries too large for
+// the server are dropped. When the server in use is unreachable,
+// the next ones are tried, without changing the one used by the
+// subscriber. It stops at the first other error, or when no
+// server is reachable, keeping the remaining entries.
+func (o *Outbox) Flush(servers *Servers) error {
+
+	urls := servers.fromCurrent()
+
+	for {
+
+		item, replayed, ok := o.Peek()
+		if !ok {
+			return nil
+		}
+
+		url := urls[0]
+
+		err := Publish(item, replayed, url, servers.Client())
+		if err != nil {
+			if err := o.setReplayed(); err != nil {
+				return err
+			}
+		}
+
+		switch {
+		case errors.Is(err, ErrConflict):
+			slog.Warn("remote clipboard is newer: dropping queued change", "item", item.ID, "copied", item.Time)
+		case errors.Is(err, ErrExpired):
+			slog.Info("queued change expired: dropping it", "item", item.ID)
+		case errors.Is(err, ErrTooLarge):
+			slog.Warn("queued change is too large for the server: dropping it", "item", item.ID, "size", len(item.Data))
+		case isUnreachable(err):
+			if len(urls) == 1 {
+				return err
+			}
+			urls = urls[1:]
+			slog.Warn("server is unreachable: trying next one", "url", url, "next", urls[0], "error", err)
+			continue
+		case err != nil:
+			return err
+		}
+
+		if err := o.Pop(); err != nil {
+			return err
+		}
+	}
+}
+
+// setReplayed marks all the entries as replayed,
+// so they are all queued and persisted.
+func (o *Outbox) setReplayed() error {
+
+	o.Lock()
+	defer o.Unlock()
+
+	if o.live {
+		o.live = false
+		return o.save()
+	}
+
+	return nil
+}
+
+// save persists the queued entries, leaving out the live
+// one, which is about to be published, and the expiring ones.
+func (o *Outbox) save() error {
+
+	if o.path == "" {
+		return nil
+	}
+
+	queued := []*protocol.Item{}
+	for i, item := range o.entries {
+		if (i == 0 && o.live) || item.Expires != nil {
+			continue
+		}
+		queued = append(queued, item)
+	}
+
+	data, err := json.Marshal(queued)
+	if err != nil {
+		return fmt.Errorf("unable to encode outbox: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(o.path), 0700); err != nil {
+		return fmt.Errorf("unable to create outbox directory: %w", err)
+	}
+
+	tmp := o.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("unable to write outbox: %w", err)
+	}
+
+	if err := os.Rename(tmp, o.path); err != nil {
+		return fmt.Errorf("unable to write outbox: %w", err)
+	}
+
+	return nil
+}
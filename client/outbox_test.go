@@ -0,0 +1,262 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/primalmotion/netboard/protocol"
+)
+
+// publishRecorder records the time header of the publications,
+// answering them with the given status codes in turn.
+type publishRecorder struct {
+	statuses []int
+	times    []string
+
+	sync.Mutex
+}
+
+func (p *publishRecorder) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+
+	p.Lock()
+	defer p.Unlock()
+
+	p.times = append(p.times, r.Header.Get(protocol.TimeHeader))
+
+	status := http.StatusNoContent
+	if len(p.statuses) > 0 {
+		status, p.statuses = p.statuses[0], p.statuses[1:]
+	}
+
+	w.WriteHeader(status)
+}
+
+func TestOutboxFlush(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		queued   int
+		statuses []int
+		reload   bool
+		times    []bool
+		left     int
+	}{
+		{
+			name:   "live item",
+			queued: 1,
+			times:  []bool{false},
+		},
+		{
+			name:   "queued behind another",
+			queued: 2,
+			times:  []bool{false, true},
+		},
+		{
+			name:     "retried after a failure",
+			queued:   1,
+			statuses: []int{http.StatusInternalServerError, http.StatusNoContent},
+			times:    []bool{false, true},
+		},
+		{
+			// The first item is about to be published,
+			// so only the one queued behind it is saved.
+			name:   "loaded from disk",
+			queued: 2,
+			reload: true,
+			times:  []bool{true},
+		},
+		{
+			name:     "dropped on conflict",
+			queued:   2,
+			statuses: []int{http.StatusNoContent, http.StatusConflict},
+			times:    []bool{false, true},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			rec := &publishRecorder{statuses: tt.statuses}
+			srv := httptest.NewTLSServer(rec)
+			defer srv.Close()
+
+			tlsConfig := srv.Client().Transport.(*http.Transport).TLSClientConfig
+			path := filepath.Join(t.TempDir(), "outbox.json")
+
+			o, err := NewOutbox(path, 10)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for i := 0; i < tt.queued; i++ {
+				if err := o.Push(protocol.NewItem([]byte{byte(i)}, time.Now())); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if tt.reload {
+				if o, err = NewOutbox(path, 10); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			servers := NewServers(tlsConfig, srv.URL)
+
+			for i := 0; i < 3 && o.Len() > 0; i++ {
+				_ = o.Flush(servers)
+			}
+
+			if o.Len() != tt.left {
+				t.Fatalf("%d items left, want %d", o.Len(), tt.left)
+			}
+
+			if len(rec.times) != len(tt.times) {
+				t.Fatalf("%d publications, want %d", len(rec.times), len(tt.times))
+			}
+			for i, want := range tt.times {
+				if (rec.times[i] != "") != want {
+					t.Fatalf("publication %d: time header %q, want set: %t", i, rec.times[i], want)
+				}
+			}
+		})
+	}
+}
+
+func TestOutboxFlushFailover(t *testing.T) {
+
+	rec := &publishRecorder{}
+	srv := httptest.NewTLSServer(rec)
+	defer srv.Close()
+
+	tlsConfig := srv.Client().Transport.(*http.Transport).TLSClientConfig
+
+	// Nothing listens on the primary.
+	primary := "https://127.0.0.1:1"
+	servers := NewServers(tlsConfig, primary, srv.URL)
+
+	o, err := NewOutbox("", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := o.Push(protocol.NewItem([]byte("item"), time.Now())); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := o.Flush(servers); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if o.Len() != 0 || len(rec.times) != 1 {
+		t.Fatalf("item not published to the next server")
+	}
+
+	// The subscriber keeps using the server it is connected to.
+	if servers.Current() != primary {
+		t.Fatalf("server in use changed to %s", servers.Current())
+	}
+
+	// When no server is reachable, the item is kept.
+	servers = NewServers(tlsConfig, primary, "https://127.0.0.1:2")
+	if err := o.Push(protocol.NewItem([]byte("other"), time.Now())); err != nil {
+		t.Fatal(err)
+	}
+	if err := o.Flush(servers); err == nil || o.Len() != 1 {
+		t.Fatalf("got error %v with %d items left, want an error and 1 item", err, o.Len())
+	}
+}
+
+func TestOutboxPersistence(t *testing.T) {
+
+	expires := time.Now().Add(time.Minute)
+	secret := protocol.NewItem([]byte("secret"), time.Now())
+	secret.Expires = &expires
+
+	tests := []struct {
+		name   string
+		items  []*protocol.Item
+		failed bool
+		want   []string
+	}{
+		{"live item", []*protocol.Item{protocol.NewItem([]byte("a"), time.Now())}, false, nil},
+		{"failed item", []*protocol.Item{protocol.NewItem([]byte("a"), time.Now())}, true, []string{"a"}},
+		{"queued behind the live one", []*protocol.Item{protocol.NewItem([]byte("a"), time.Now()), protocol.NewItem([]byte("b"), time.Now())}, false, []string{"b"}},
+		{"failed expiring item", []*protocol.Item{secret}, true, nil},
+		{"expiring item queued", []*protocol.Item{protocol.NewItem([]byte("a"), time.Now()), secret}, true, []string{"a"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			path := filepath.Join(t.TempDir(), "outbox.json")
+
+			o, err := NewOutbox(path, 10)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for _, item := range tt.items {
+				if err := o.Push(item); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			// Nothing listens there.
+			servers := NewServers(nil, "https://127.0.0.1:1")
+			if tt.failed {
+				if err := o.Flush(servers); err == nil {
+					t.Fatalf("flush succeeded")
+				}
+			}
+
+			if got := readOutbox(t, path); strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Fatalf("got %v persisted, want %v", got, tt.want)
+			}
+
+			// Once flushed, nothing is left on the disk.
+			srv := httptest.NewTLSServer(&publishRecorder{})
+			defer srv.Close()
+
+			if err := o.Flush(NewServers(srv.Client().Transport.(*http.Transport).TLSClientConfig, srv.URL)); err != nil {
+				t.Fatalf("unable to flush: %s", err)
+			}
+			if got := readOutbox(t, path); len(got) != 0 {
+				t.Fatalf("got %v persisted after flush", got)
+			}
+		})
+	}
+}
+
+// readOutbox returns the data of the items
+// persisted in the outbox at the given path.
+func readOutbox(t *testing.T, path string) []string {
+
+	t.Helper()
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		t.Fatalf("unable to read outbox: %s", err)
+	}
+
+	var items []*protocol.Item
+	if err := json.Unmarshal(data, &items); err != nil {
+		t.Fatalf("unable to decode outbox: %s", err)
+	}
+
+	var out []string
+	for _, item := range items {
+		out = append(out, string(item.Data))
+	}
+
+	return out
+}
@@ -1,38 +1,97 @@
 package client
 
 import (
-	"crypto/tls"
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
-	"io"
-	"log"
+	"log/slog"
 	"net/http"
+	"time"
+
+	"github.com/primalmotion/netboard/protocol"
 )
 
-// Publish sends the content of the given reader to the given url
-// using the given tls config.
-func Publish(data io.Reader, url string, tlsConfig *tls.Config) error {
+// ErrConflict is returned by Publish when the server
+// already holds a value newer than the published one.
+var ErrConflict = errors.New("server holds a newer value")
+
+// ErrTooLarge is returned by Publish when the server refuses
+// the item because it is too large, and by FetchBlob when the
+// blob exceeds the maximum size.
+var ErrTooLarge = errors.New("item is too large")
+
+// ErrExpired is returned by Publish when the
+// item expired before it could be sent.
+var ErrExpired = errors.New("item expired")
 
-	client := &http.Client{
-		Transport: &http.Transport{
-			TLSClientConfig: tlsConfig,
-		},
+// Publish sends the given item to the given url using the given
+// HTTP client. If replayed is true, the item was not just copied,
+// and the time of the item is sent so the server can discard it if
+// it holds a newer value. Otherwise, the server uses the time it
+// receives the item at, so the clock of the device does not matter.
+// If the server rate limits the device, a *RateLimitError holding
+// the delay to wait before trying again is returned. If the item is
+// a blob, its data is uploaded first, and only its reference is
+// published. If the data of the item is compressed, it is sent as
+// is with its encoding.
+func Publish(item *protocol.Item, replayed bool, url string, client *http.Client) error {
+
+	body := item.Data
+	if item.Blob {
+		if err := UploadBlob(item, url, client); err != nil {
+			return err
+		}
+		body = nil
 	}
 
-	r, err := http.NewRequest(http.MethodPost, url+"/publish", data)
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+
+	r, err := http.NewRequestWithContext(ctx, http.MethodPost, url+"/publish", bytes.NewReader(body))
 	if err != nil {
 		return fmt.Errorf("unable to build request: %w", err)
 	}
+	if replayed {
+		r.Header.Set(protocol.TimeHeader, item.Time.UTC().Format(time.RFC3339Nano))
+	}
+	if item.MIME != "" {
+		r.Header.Set("Content-Type", item.MIME)
+	}
+	if item.Blob {
+		r.Header.Set(protocol.BlobHeader, item.ID)
+	}
+	if item.Encoding != "" {
+		r.Header.Set("Content-Encoding", item.Encoding)
+	}
+	if item.Expires != nil {
+		ttl := time.Until(*item.Expires)
+		if ttl <= 0 {
+			return ErrExpired
+		}
+		r.Header.Set(protocol.TTLHeader, ttl.Round(time.Millisecond).String())
+	}
 
 	resp, err := client.Do(r)
 	if err != nil {
 		return fmt.Errorf("unable to send request: %w", err)
 	}
+	defer resp.Body.Close() // nolint
+
+	switch resp.StatusCode {
+	case http.StatusConflict:
+		return ErrConflict
+	case http.StatusRequestEntityTooLarge:
+		return ErrTooLarge
+	case http.StatusTooManyRequests:
+		return newRateLimitError(resp)
+	}
 
 	if resp.StatusCode != http.StatusNoContent {
 		return fmt.Errorf("server rejected the request: %s", resp.Status)
 	}
 
-	log.Println("data dispatched")
+	slog.Debug("item published", "item", item.ID, "size", len(item.Data), "mime", item.MIME, "blob", item.Blob)
 
 	return nil
 }
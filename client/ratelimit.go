@@ -0,0 +1,39 @@
+package client
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// A RateLimitError is returned when the server refused
+// a request because the device sent too many of them.
+type RateLimitError struct {
+
+	// RetryAfter is the delay to wait before
+	// trying again, as requested by the server.
+	RetryAfter time.Duration
+}
+
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("rate limited by the server: retry after %s", e.RetryAfter)
+}
+
+// newRateLimitError returns a RateLimitError from the
+// Retry-After header of the given response. The header can
+// either be a number of seconds or an HTTP date.
+func newRateLimitError(resp *http.Response) *RateLimitError {
+
+	delay := time.Second
+
+	if h := resp.Header.Get("Retry-After"); h != "" {
+		if secs, err := strconv.Atoi(h); err == nil && secs >= 0 {
+			delay = time.Duration(secs) * time.Second
+		} else if t, err := http.ParseTime(h); err == nil {
+			delay = time.Until(t)
+		}
+	}
+
+	return &RateLimitError{RetryAfter: delay}
+}
@@ -0,0 +1,106 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/primalmotion/netboard/protocol"
+)
+
+func TestNewRateLimitError(t *testing.T) {
+
+	tests := []struct {
+		name       string
+		retryAfter string
+		wantMin    time.Duration
+		wantMax    time.Duration
+	}{
+		{"seconds", "3", 3 * time.Second, 3 * time.Second},
+		{"date", time.Now().Add(time.Minute).UTC().Format(http.TimeFormat), 58 * time.Second, time.Minute},
+		{"missing", "", time.Second, time.Second},
+		{"invalid", "soon", time.Second, time.Second},
+		{"negative", "-3", time.Second, time.Second},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			resp := &http.Response{Header: http.Header{}}
+			if tt.retryAfter != "" {
+				resp.Header.Set("Retry-After", tt.retryAfter)
+			}
+
+			got := newRateLimitError(resp).RetryAfter
+			if got < tt.wantMin || got > tt.wantMax {
+				t.Fatalf("got %s, want between %s and %s", got, tt.wantMin, tt.wantMax)
+			}
+		})
+	}
+}
+
+func TestPublishRateLimited(t *testing.T) {
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "5")
+		http.Error(w, "too many requests", http.StatusTooManyRequests)
+	}))
+	defer srv.Close()
+
+	err := Publish(protocol.NewItem([]byte("hello"), time.Now()), false, srv.URL, srv.Client())
+
+	var rlErr *RateLimitError
+	if !errors.As(err, &rlErr) {
+		t.Fatalf("got error %v, want a RateLimitError", err)
+	}
+	if rlErr.RetryAfter != 5*time.Second {
+		t.Fatalf("got delay %s, want 5s", rlErr.RetryAfter)
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+
+	backoff := Backoff{Initial: 2 * time.Second, Factor: 2}
+
+	tests := []struct {
+		name      string
+		attempt   int
+		err       error
+		wantDelay time.Duration
+	}{
+		{"backoff", 1, errors.New("connection refused"), 2 * time.Second},
+		{"longer rate limit", 1, &RateLimitError{RetryAfter: 10 * time.Second}, 10 * time.Second},
+		{"shorter rate limit", 3, &RateLimitError{RetryAfter: time.Second}, 8 * time.Second},
+		{"wrapped rate limit", 1, fmt.Errorf("unable to subscribe: %w", &RateLimitError{RetryAfter: 10 * time.Second}), 10 * time.Second},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			var events []StateEvent
+			c := SubscribeConfig{
+				Backoff:   backoff,
+				StateFunc: func(evt StateEvent) { events = append(events, evt) },
+			}
+
+			// The context is canceled so retry does not wait.
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+
+			if c.retry(ctx, tt.attempt, tt.err) {
+				t.Fatalf("retry did not stop on canceled context")
+			}
+
+			if len(events) != 1 || events[0].State != StateBackingOff {
+				t.Fatalf("unexpected events: %+v", events)
+			}
+			if events[0].Delay != tt.wantDelay {
+				t.Fatalf("got delay %s, want %s", events[0].Delay, tt.wantDelay)
+			}
+		})
+	}
+}
@@ -0,0 +1,99 @@
+package client
+
+import (
+	"sync"
+	"time"
+)
+
+// Origin tells where a clipboard item comes from.
+type Origin int
+
+// Various values of Origin.
+const (
+	OriginLocal Origin = iota
+	OriginRemote
+)
+
+type recentItem struct {
+	ids    []string
+	origin Origin
+	time   time.Time
+}
+
+// RecentItems keeps track of the items recently seen by
+// the sync loop in order to detect echoes: a device must
+// never publish an item it just received, nor write back
+// an item it just published.
+type RecentItems struct {
+	size   int
+	window time.Duration
+	items  []recentItem
+
+	sync.Mutex
+}
+
+// NewRecentItems returns a new RecentItems remembering at most
+// size items. Items from the other origin are considered echoes
+// if they were seen during the given window.
+func NewRecentItems(size int, window time.Duration) *RecentItems {
+	return &RecentItems{
+		size:   size,
+		window: window,
+	}
+}
+
+// Add records an item from the given origin. An item can be
+// known under several ids (for instance its exact and loose IDs).
+func (r *RecentItems) Add(origin Origin, ids ...string) {
+
+	r.Lock()
+	defer r.Unlock()
+
+	r.items = append(r.items, recentItem{ids: ids, origin: origin, time: time.Now()})
+	if len(r.items) > r.size {
+		r.items = r.items[len(r.items)-r.size:]
+	}
+}
+
+// IsEcho returns true if an item known under the given ids coming
+// from the given origin must be ignored. This is the case if it
+// matches the latest item seen, meaning both sides are already in
+// sync, or if it matches an item recently seen from the other
+// origin, meaning it is bouncing back.
+func (r *RecentItems) IsEcho(origin Origin, ids ...string) bool {
+
+	r.Lock()
+	defer r.Unlock()
+
+	if len(r.items) == 0 {
+		return false
+	}
+
+	if r.items[len(r.items)-1].matches(ids) {
+		return true
+	}
+
+	limit := time.Now().Add(-r.window)
+	for i := len(r.items) - 1; i >= 0; i-- {
+		item := r.items[i]
+		if item.time.Before(limit) {
+			break
+		}
+		if item.origin != origin && item.matches(ids) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (i recentItem) matches(ids []string) bool {
+	for _, a := range i.ids {
+		for _, b := range ids {
+			if a == b {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,62 @@
+package client
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRecentItemsIsEcho(t *testing.T) {
+
+	type seen struct {
+		origin Origin
+		ids    []string
+	}
+
+	tests := []struct {
+		name   string
+		seen   []seen
+		window time.Duration
+		origin Origin
+		ids    []string
+		want   bool
+	}{
+		{"nothing seen", nil, time.Minute, OriginLocal, []string{"a"}, false},
+		{"new item", []seen{{OriginRemote, []string{"a"}}}, time.Minute, OriginLocal, []string{"b"}, false},
+		{"remote item written back", []seen{{OriginRemote, []string{"a"}}}, time.Minute, OriginLocal, []string{"a"}, true},
+		{"local item received back", []seen{{OriginLocal, []string{"a"}}}, time.Minute, OriginRemote, []string{"a"}, true},
+		{"same as latest", []seen{{OriginLocal, []string{"a"}}}, time.Minute, OriginLocal, []string{"a"}, true},
+		{"matching loose id", []seen{{OriginRemote, []string{"a", "loose"}}}, time.Minute, OriginLocal, []string{"b", "loose"}, true},
+		{"older remote item copied again", []seen{{OriginRemote, []string{"a"}}, {OriginRemote, []string{"b"}}}, time.Minute, OriginLocal, []string{"a"}, true},
+		{"older local item copied again", []seen{{OriginLocal, []string{"a"}}, {OriginLocal, []string{"b"}}}, time.Minute, OriginLocal, []string{"a"}, false},
+		{"outside of the window", []seen{{OriginRemote, []string{"a"}}, {OriginRemote, []string{"b"}}}, 0, OriginLocal, []string{"a"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			r := NewRecentItems(10, tt.window)
+			for _, s := range tt.seen {
+				r.Add(s.origin, s.ids...)
+			}
+
+			if got := r.IsEcho(tt.origin, tt.ids...); got != tt.want {
+				t.Fatalf("got %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRecentItemsSize(t *testing.T) {
+
+	r := NewRecentItems(2, time.Minute)
+	r.Add(OriginRemote, "a")
+	r.Add(OriginRemote, "b")
+	r.Add(OriginRemote, "c")
+
+	if r.IsEcho(OriginLocal, "a") {
+		t.Fatalf("forgotten item reported as echo")
+	}
+	if !r.IsEcho(OriginLocal, "b") {
+		t.Fatalf("remembered item not reported as echo")
+	}
+}
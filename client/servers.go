@@ -0,0 +1,142 @@
+package client
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// requestTimeout is the maximum time of the
+// requests to the servers, except for blobs.
+const requestTimeout = 30 * time.Second
+
+// PrimaryCheckInterval is the interval at which the primary
+// server is checked while using another one, in order to
+// use it again as soon as it is back.
+var PrimaryCheckInterval = 30 * time.Second
+
+// Servers is an ordered list of server URLs. The first one
+// is the primary server, and the others are used in turn
+// when the one in use is unreachable.
+type Servers struct {
+	urls    []string
+	current int
+	client  *http.Client
+
+	sync.RWMutex
+}
+
+// NewServers returns a new Servers with the given URLs. The
+// requests to the servers share an HTTP client, using the
+// given tls config.
+func NewServers(tlsConfig *tls.Config, urls ...string) *Servers {
+	return &Servers{
+		urls:   urls,
+		client: NewHTTPClient(tlsConfig),
+	}
+}
+
+// NewHTTPClient returns an HTTP client using the given tls
+// config, meant to be reused for all the requests to a server
+// so its connections are kept alive.
+func NewHTTPClient(tlsConfig *tls.Config) *http.Client {
+	return &http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig: tlsConfig,
+			IdleConnTimeout: 90 * time.Second,
+		},
+	}
+}
+
+// Client returns the HTTP client shared by
+// the requests to the servers.
+func (s *Servers) Client() *http.Client {
+	return s.client
+}
+
+// Current returns the URL of the server in use.
+func (s *Servers) Current() string {
+
+	s.RLock()
+	defer s.RUnlock()
+
+	return s.urls[s.current]
+}
+
+// Primary returns the URL of the primary server.
+func (s *Servers) Primary() string {
+	return s.urls[0]
+}
+
+// fromCurrent returns the URLs of the servers, starting
+// with the one in use and followed by the next ones.
+func (s *Servers) fromCurrent() []string {
+
+	s.RLock()
+	defer s.RUnlock()
+
+	return append(append([]string{}, s.urls[s.current:]...), s.urls[:s.current]...)
+}
+
+// Failed reports that the server at the given URL is unreachable.
+// If it is the one in use, the next one is used instead. It returns
+// true if all the servers have been tried in turn and failed, in
+// which case the caller should wait before trying again.
+func (s *Servers) Failed(u string) bool {
+
+	s.Lock()
+	defer s.Unlock()
+
+	if s.urls[s.current] != u {
+		return false
+	}
+
+	s.current = (s.current + 1) % len(s.urls)
+
+	return s.current == 0
+}
+
+// Reset makes the primary server the one in use.
+func (s *Servers) Reset() {
+
+	s.Lock()
+	defer s.Unlock()
+
+	s.current = 0
+}
+
+// watchPrimary checks the primary server periodically until the
+// context is canceled. When it answers, it is used again and
+// back is called.
+func (s *Servers) watchPrimary(ctx context.Context, back func()) {
+
+	ticker := time.NewTicker(PrimaryCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+
+		case <-ticker.C:
+			if _, err := FetchLimits(s.Primary(), s.client); err != nil {
+				continue
+			}
+			s.Reset()
+			back()
+			return
+
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// isUnreachable returns true if the given error
+// means the server could not be reached at all.
+func isUnreachable(err error) bool {
+	var uErr *url.Error
+	return errors.As(err, &uErr)
+}
@@ -0,0 +1,111 @@
+package client
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/primalmotion/netboard/protocol"
+)
+
+// State represents the connection state of a subscriber.
+type State int
+
+// Various values of State.
+const (
+	StateConnecting State = iota
+	StateConnected
+	StateBackingOff
+	StateGivingUp
+)
+
+func (s State) String() string {
+	switch s {
+	case StateConnecting:
+		return "connecting"
+	case StateConnected:
+		return "connected"
+	case StateBackingOff:
+		return "backing off"
+	case StateGivingUp:
+		return "giving up"
+	default:
+		return "unknown"
+	}
+}
+
+// A StateEvent is sent to the StateFunc of a subscriber
+// every time its connection state changes.
+type StateEvent struct {
+	State   State
+	Attempt int
+	Delay   time.Duration
+	Err     error
+
+	// URL is the server being connected to. It is
+	// only set for StateConnecting and StateConnected.
+	URL string
+}
+
+// SubscribeConfig holds the configuration of a subscriber.
+type SubscribeConfig struct {
+	TLSConfig *tls.Config
+	Backoff   Backoff
+
+	// StateFunc is called from the subscriber
+	// goroutine on every state change. It must not block.
+	StateFunc func(StateEvent)
+
+	// Encodings are the compressions accepted for the data
+	// of the received items, by order of preference. Empty
+	// means the data is never compressed.
+	Encodings []string
+
+	// MaxSize is the maximum size of the decompressed data of
+	// the received items, above which they are dropped. 0 means
+	// protocol.MaxDecompressedSize.
+	MaxSize int64
+}
+
+func (c SubscribeConfig) headers() http.Header {
+
+	h := http.Header{}
+	if len(c.Encodings) > 0 {
+		h.Set(protocol.AcceptEncodingHeader, strings.Join(c.Encodings, ", "))
+	}
+
+	return h
+}
+
+func (c SubscribeConfig) notify(evt StateEvent) {
+	if c.StateFunc != nil {
+		c.StateFunc(evt)
+	}
+}
+
+// retry reports the failed attempt and waits for the
+// backoff delay, or the delay requested by the server if
+// it rate limited us and that delay is longer. It returns
+// false if the subscriber must stop, either because it
+// gave up or because the context was canceled.
+func (c SubscribeConfig) retry(ctx context.Context, attempt int, err error) bool {
+
+	if c.Backoff.exhausted(attempt) {
+		c.notify(StateEvent{State: StateGivingUp, Attempt: attempt, Err: err})
+		return false
+	}
+
+	d := c.Backoff.Delay(attempt)
+
+	var rlErr *RateLimitError
+	if errors.As(err, &rlErr) && rlErr.RetryAfter > d {
+		d = rlErr.RetryAfter
+	}
+
+	c.notify(StateEvent{State: StateBackingOff, Attempt: attempt, Delay: d, Err: err})
+
+	return wait(ctx, d)
+}
@@ -0,0 +1,74 @@
+package client
+
+import (
+	"context"
+	"sync/atomic"
+
+	"github.com/primalmotion/netboard/protocol"
+)
+
+// A streamFunc streams the items sent by the server at the
+// given url to the given channel until an error occurs or
+// the context is canceled. It calls connected once connected.
+type streamFunc func(ctx context.Context, url string, ch chan *protocol.Item, connected func()) error
+
+// subscribe runs the given stream until the context is canceled,
+// reconnecting according to the configuration. When a server is
+// unreachable, the next one is tried right away, and the subscriber
+// only backs off once all of them failed. While connected to
+// another server than the primary one, it switches back to the
+// primary as soon as it answers again.
+func subscribe(ctx context.Context, servers *Servers, cfg SubscribeConfig, stream streamFunc) (chan *protocol.Item, chan struct{}) {
+
+	ch := make(chan *protocol.Item, 512)
+	done := make(chan struct{})
+
+	go func() {
+
+		defer close(done)
+
+		var attempt int
+
+		for {
+
+			url := servers.Current()
+
+			cfg.notify(StateEvent{State: StateConnecting, Attempt: attempt, URL: url})
+
+			streamCtx, cancel := context.WithCancel(ctx)
+
+			var preempted atomic.Bool
+			if url != servers.Primary() {
+				go servers.watchPrimary(streamCtx, func() {
+					preempted.Store(true)
+					cancel()
+				})
+			}
+
+			err := stream(streamCtx, url, ch, func() {
+				attempt = 0
+				cfg.notify(StateEvent{State: StateConnected, URL: url})
+			})
+			cancel()
+
+			if ctx.Err() != nil {
+				return
+			}
+
+			if preempted.Load() {
+				continue
+			}
+
+			if !servers.Failed(url) {
+				continue
+			}
+
+			attempt++
+			if !cfg.retry(ctx, attempt, err) {
+				return
+			}
+		}
+	}()
+
+	return ch, done
+}
@@ -1,98 +1,82 @@
 package client
 
 import (
+	"bufio"
+	"bytes"
 	"context"
-	"crypto/tls"
-	"encoding/base64"
-	"log"
+	"fmt"
+	"log/slog"
 	"net/http"
-	"time"
+
+	"github.com/primalmotion/netboard/protocol"
 )
 
 // SubscribeChunked connects to the remote server and will get clipbiard updates using
 // HTTP chunked encoding.
-func SubscribeChunked(ctx context.Context, url string, tlsConfig *tls.Config) (chan []byte, chan struct{}) {
+func SubscribeChunked(ctx context.Context, servers *Servers, cfg SubscribeConfig) (chan *protocol.Item, chan struct{}) {
+
+	client := &http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig: cfg.TLSConfig,
+		},
+	}
+
+	return subscribe(ctx, servers, cfg, func(ctx context.Context, url string, ch chan *protocol.Item, connected func()) error {
+		return streamChunked(ctx, client, url, cfg.headers(), cfg.MaxSize, ch, connected)
+	})
+}
+
+func streamChunked(ctx context.Context, client *http.Client, url string, header http.Header, maxSize int64, ch chan *protocol.Item, connected func()) error {
+
+	r, err := http.NewRequestWithContext(ctx, http.MethodGet, url+"/subscribe/chunked", nil)
+	if err != nil {
+		return fmt.Errorf("unable to build request: %w", err)
+	}
+	r.Header = header
+
+	resp, err := client.Do(r)
+	if err != nil {
+		return fmt.Errorf("unable to send request: %w", err)
+	}
+	defer resp.Body.Close() // nolint
 
-	ch := make(chan []byte, 512)
-	done := make(chan struct{})
+	if resp.StatusCode == http.StatusTooManyRequests {
+		return newRateLimitError(resp)
+	}
 
-	go func() {
-		client := &http.Client{
-			Transport: &http.Transport{
-				TLSClientConfig: tlsConfig,
-			},
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("server rejected the request: %s", resp.Status)
+	}
+
+	connected()
+	slog.Debug("connected and waiting for data", "transport", "chunked", "url", url)
+
+	reader := bufio.NewReader(resp.Body)
+
+	for {
+
+		chunk, err := reader.ReadBytes(protocol.MessageSeparator)
+		if err != nil {
+			return fmt.Errorf("unable to read body: %w", err)
 		}
 
-		isReconnect := false
-
-	MAIN:
-		for {
-
-			if isReconnect {
-				time.Sleep(5 * time.Second)
-			}
-			isReconnect = true
-
-			r, err := http.NewRequestWithContext(ctx, http.MethodGet, url+"/subscribe/chunked", nil)
-			if err != nil {
-				log.Printf("unable to build request: %s", err)
-				continue
-			}
-
-			log.Println("connected and waiting for data")
-
-			resp, err := client.Do(r)
-			if err != nil {
-				log.Printf("unable to send request: %s", err)
-				continue
-			}
-
-			if resp.StatusCode != http.StatusOK {
-				log.Printf("server rejected the request: %s", err)
-				continue
-			}
-			defer resp.Body.Close() // nolint
-
-			for {
-				var chunk []byte
-				for {
-					buf := make([]byte, 1024)
-					n, err := resp.Body.Read(buf)
-					if err != nil {
-						log.Printf("error: unable to read body: %s", err)
-						continue MAIN
-					}
-
-					if n == 0 {
-						break
-					}
-
-					if buf[n-1] == ',' {
-						chunk = append(chunk, buf[:n-1]...)
-						break
-					}
-					chunk = append(chunk, buf[:n]...)
-				}
-
-				decoded := make([]byte, len(chunk))
-				n, err := base64.RawURLEncoding.Decode(decoded, chunk)
-				if err != nil {
-					log.Printf("error: unable to decode body: %s", err)
-					continue
-				}
-
-				select {
-				case ch <- decoded[:n]:
-					log.Println("data received: sent to channel")
-				case <-ctx.Done():
-					close(done)
-					return
-				default:
-					log.Println("data received: channel full")
-				}
-			}
+		if len(bytes.TrimSuffix(chunk, []byte{protocol.MessageSeparator})) == 0 {
+			continue
 		}
-	}()
 
-	return ch, done
+		item, err := protocol.Decode(chunk, maxSize)
+		if err != nil {
+			slog.Error("unable to decode message", "transport", "chunked", "error", err)
+			continue
+		}
+
+		select {
+		case ch <- item:
+			slog.Debug("item received", "transport", "chunked", "item", item.ID, "size", len(item.Data))
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+			slog.Warn("item received but channel is full: dropping it", "transport", "chunked", "item", item.ID)
+		}
+	}
 }
@@ -1,109 +1,83 @@
 package client
 
 import (
-	"bytes"
 	"context"
-	"crypto/tls"
-	"encoding/base64"
-	"log"
+	"fmt"
+	"log/slog"
 	"net/http"
 	"strings"
 	"time"
 
 	"github.com/gorilla/websocket"
+	"github.com/primalmotion/netboard/protocol"
 	"go.aporeto.io/wsc"
 )
 
 // SubscribeWS connects to the remote server and will get clipbiard updates using
 // websockets.
-func SubscribeWS(ctx context.Context, url string, tlsConfig *tls.Config) (chan []byte, chan struct{}) {
+func SubscribeWS(ctx context.Context, servers *Servers, cfg SubscribeConfig) (chan *protocol.Item, chan struct{}) {
 
-	ch := make(chan []byte, 512)
-	done := make(chan struct{})
+	return subscribe(ctx, servers, cfg, func(ctx context.Context, url string, ch chan *protocol.Item, connected func()) error {
+		return streamWS(ctx, url, cfg, ch, connected)
+	})
+}
 
-	go func() {
+func streamWS(ctx context.Context, url string, cfg SubscribeConfig, ch chan *protocol.Item, connected func()) error {
+
+	wsctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	conn, resp, err := wsc.Connect(
+		wsctx,
+		strings.Replace(url+"/subscribe/ws", "https", "wss", 1),
+		wsc.Config{
+			TLSConfig:          cfg.TLSConfig,
+			Headers:            cfg.headers(),
+			NetDialContextFunc: netDialContextFunc, // this function is platform dependent.
+			PingPeriod:         15 * time.Minute,
+			PongWait:           20 * time.Minute,
+		},
+	)
+	if err != nil {
+		if resp != nil && resp.StatusCode == http.StatusTooManyRequests {
+			return newRateLimitError(resp)
+		}
+		return fmt.Errorf("unable to connect to ws: %w", err)
+	}
 
-		isReconnect := false
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		return fmt.Errorf("server rejected ws connection: %s", resp.Status)
+	}
 
-	MAINWS:
-		for {
+	connected()
+	slog.Debug("connected and waiting for data", "transport", "websocket", "url", url)
 
-			select {
-			case <-ctx.Done():
-				close(done)
-				return
-			default:
-			}
+	for {
+		select {
 
-			if isReconnect {
-				time.Sleep(time.Second)
+		case msg := <-conn.Done():
+			if websocket.IsCloseError(msg, websocket.CloseGoingAway) {
+				return fmt.Errorf("ws server gone")
 			}
-			isReconnect = true
+			return fmt.Errorf("ws connection closed: %w", msg)
 
-			wsctx, cancel := context.WithCancel(ctx)
-			defer cancel()
+		case data := <-conn.Read():
 
-			conn, resp, err := wsc.Connect(
-				wsctx,
-				strings.Replace(url+"/subscribe/ws", "https", "wss", 1),
-				wsc.Config{
-					TLSConfig:          tlsConfig,
-					NetDialContextFunc: netDialContextFunc, // this function is platform dependent.
-					PingPeriod:         15 * time.Minute,
-					PongWait:           20 * time.Minute,
-				},
-			)
+			item, err := protocol.Decode(data, cfg.MaxSize)
 			if err != nil {
-				log.Printf("unable to connect to ws (retrying): %s", err)
-				cancel()
+				slog.Error("unable to decode message", "transport", "websocket", "error", err)
 				continue
 			}
 
-			if resp.StatusCode != http.StatusSwitchingProtocols {
-				log.Printf("server rejected ws connection (retrying): %s", err)
-				cancel()
-				continue
+			select {
+			case ch <- item:
+			default:
 			}
 
-			log.Printf("connected to ws server")
-
-			for {
-				select {
-
-				case msg := <-conn.Done():
-					if !websocket.IsCloseError(msg, websocket.CloseGoingAway) {
-						log.Printf("ws msg: %s", msg)
-					} else {
-						log.Println("ws server gone. reconnecting...")
-					}
-					cancel()
-					continue MAINWS
-
-				case data := <-conn.Read():
-
-					data = bytes.TrimSuffix(data, []byte{','})
-					decoded := make([]byte, len(data))
-
-					n, err := base64.RawURLEncoding.Decode(decoded, data)
-					if err != nil {
-						log.Printf("error: unable to decode body: %s", err)
-						continue
-					}
-
-					select {
-					case ch <- decoded[:n]:
-					default:
-					}
-
-				case <-ctx.Done():
-					conn.Close(websocket.CloseGoingAway)
-					<-conn.Done()
-					close(done)
-					return
-				}
-			}
+		case <-ctx.Done():
+			conn.Close(websocket.CloseGoingAway)
+			<-conn.Done()
+			return ctx.Err()
 		}
-	}()
-
-	return ch, done
+	}
 }
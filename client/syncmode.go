@@ -0,0 +1,36 @@
+package client
+
+import (
+	"fmt"
+)
+
+// SyncMode tells in which directions the clipboard is synchronized.
+type SyncMode string
+
+// Various values of SyncMode.
+const (
+	SyncBoth        SyncMode = "both"
+	SyncSendOnly    SyncMode = "send-only"
+	SyncReceiveOnly SyncMode = "receive-only"
+)
+
+// ParseSyncMode returns the SyncMode with the given name.
+func ParseSyncMode(name string) (SyncMode, error) {
+
+	switch m := SyncMode(name); m {
+	case SyncBoth, SyncSendOnly, SyncReceiveOnly:
+		return m, nil
+	default:
+		return "", fmt.Errorf("unknown sync direction '%s': must be both, send-only or receive-only", name)
+	}
+}
+
+// Sends returns true if the local changes are published.
+func (m SyncMode) Sends() bool {
+	return m != SyncReceiveOnly
+}
+
+// Receives returns true if the remote items are written to the clipboard.
+func (m SyncMode) Receives() bool {
+	return m != SyncSendOnly
+}
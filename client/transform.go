@@ -0,0 +1,297 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/primalmotion/netboard/protocol"
+)
+
+// Direction tells in which direction an item is flowing.
+type Direction string
+
+// Various values of Direction.
+const (
+	DirectionPublish Direction = "publish"
+	DirectionReceive Direction = "receive"
+	DirectionBoth    Direction = "both"
+)
+
+// TransformConfig holds the configuration of a transformation
+// step. A step is either a builtin transform, selected by Name,
+// or an external command.
+type TransformConfig struct {
+
+	// Name is the name of the builtin transform. One of
+	// normalize-crlf, trim-trailing-whitespace,
+	// strip-tracking-params or redact.
+	Name string `mapstructure:"name"`
+
+	// Pattern is the regular expression to redact
+	// when Name is redact.
+	Pattern string `mapstructure:"pattern"`
+
+	// Rule is the name of a builtin deny rule to
+	// redact when Name is redact. See BuiltinDenyRules.
+	Rule string `mapstructure:"rule"`
+
+	// Replacement replaces the redacted content.
+	// Defaults to [REDACTED].
+	Replacement string `mapstructure:"replacement"`
+
+	// Command is an external command receiving the data on
+	// stdin and writing the transformed data to stdout.
+	Command []string `mapstructure:"command"`
+
+	// Timeout is the maximum execution time of Command.
+	// Defaults to 5s.
+	Timeout time.Duration `mapstructure:"timeout"`
+
+	// Direction tells when the step applies.
+	// Defaults to both.
+	Direction Direction `mapstructure:"direction"`
+}
+
+type transformFunc func(ctx context.Context, dir Direction, item *protocol.Item) ([]byte, error)
+
+type transformStep struct {
+	name      string
+	direction Direction
+	textOnly  bool
+	fn        transformFunc
+}
+
+// A Transformer rewrites clipboard items in flight, before
+// publishing them and before writing them to the clipboard.
+type Transformer struct {
+	steps []transformStep
+}
+
+// NewTransformer returns a new Transformer running the given steps in order.
+func NewTransformer(cfgs []TransformConfig) (*Transformer, error) {
+
+	t := &Transformer{}
+
+	for i, cfg := range cfgs {
+
+		step := transformStep{
+			name:      cfg.Name,
+			direction: cfg.Direction,
+			textOnly:  true,
+		}
+
+		switch step.direction {
+		case "":
+			step.direction = DirectionBoth
+		case DirectionPublish, DirectionReceive, DirectionBoth:
+		default:
+			return nil, fmt.Errorf("transform %d: unknown direction '%s'", i, cfg.Direction)
+		}
+
+		switch {
+
+		case len(cfg.Command) > 0:
+			if cfg.Name != "" {
+				return nil, fmt.Errorf("transform %d: name and command are mutually exclusive", i)
+			}
+			step.name = cfg.Command[0]
+			step.textOnly = false
+			step.fn = makeCommandTransform(cfg.Command, cfg.Timeout)
+
+		case cfg.Name == "normalize-crlf":
+			step.fn = func(_ context.Context, _ Direction, item *protocol.Item) ([]byte, error) {
+				return bytes.ReplaceAll(item.Data, []byte("\r\n"), []byte("\n")), nil
+			}
+
+		case cfg.Name == "trim-trailing-whitespace":
+			step.fn = trimTrailingWhitespace
+
+		case cfg.Name == "strip-tracking-params":
+			step.fn = stripTrackingParams
+
+		case cfg.Name == "redact":
+			fn, err := makeRedactTransform(cfg)
+			if err != nil {
+				return nil, fmt.Errorf("transform %d: %w", i, err)
+			}
+			step.fn = fn
+
+		default:
+			return nil, fmt.Errorf("transform %d: unknown transform '%s'", i, cfg.Name)
+		}
+
+		t.steps = append(t.steps, step)
+	}
+
+	return t, nil
+}
+
+// Apply runs the steps matching the given direction on the given item.
+// It returns the transformed item, or nil if a command dropped it.
+// The returned item has its ID updated, but keeps the other fields of
+// the original one.
+func (t *Transformer) Apply(ctx context.Context, dir Direction, item *protocol.Item) (*protocol.Item, error) {
+
+	data := item.Data
+
+	for _, step := range t.steps {
+
+		if step.direction != DirectionBoth && step.direction != dir {
+			continue
+		}
+
+		if step.textOnly && !strings.HasPrefix(item.MIME, "text/") {
+			continue
+		}
+
+		current := *item
+		current.Data = data
+
+		out, err := step.fn(ctx, dir, &current)
+		if err != nil {
+			return nil, fmt.Errorf("transform '%s' failed: %w", step.name, err)
+		}
+
+		if len(out) == 0 {
+			return nil, nil
+		}
+
+		data = out
+	}
+
+	if bytes.Equal(data, item.Data) {
+		return item, nil
+	}
+
+	out := *item
+	out.ID = protocol.ComputeID(data)
+	out.Data = data
+
+	return &out, nil
+}
+
+func trimTrailingWhitespace(_ context.Context, _ Direction, item *protocol.Item) ([]byte, error) {
+
+	lines := bytes.Split(item.Data, []byte("\n"))
+	for i, line := range lines {
+		lines[i] = bytes.TrimRight(line, " \t")
+	}
+
+	return bytes.TrimRight(bytes.Join(lines, []byte("\n")), " \t\r\n"), nil
+}
+
+var (
+	urlRegexp = regexp.MustCompile(`https?://[^\s<>"']+`)
+
+	trackingParams = map[string]struct{}{
+		"fbclid":  {},
+		"gclid":   {},
+		"dclid":   {},
+		"msclkid": {},
+		"mc_cid":  {},
+		"mc_eid":  {},
+		"igshid":  {},
+		"yclid":   {},
+		"_hsenc":  {},
+		"_hsmi":   {},
+		"ref_src": {},
+	}
+)
+
+func stripTrackingParams(_ context.Context, _ Direction, item *protocol.Item) ([]byte, error) {
+
+	return urlRegexp.ReplaceAllFunc(item.Data, func(raw []byte) []byte {
+
+		u, err := url.Parse(string(raw))
+		if err != nil || u.RawQuery == "" {
+			return raw
+		}
+
+		params := strings.Split(u.RawQuery, "&")
+		kept := make([]string, 0, len(params))
+
+		for _, p := range params {
+			key, _, _ := strings.Cut(p, "=")
+			if _, ok := trackingParams[key]; ok || strings.HasPrefix(key, "utm_") {
+				continue
+			}
+			kept = append(kept, p)
+		}
+
+		if len(kept) == len(params) {
+			return raw
+		}
+
+		u.RawQuery = strings.Join(kept, "&")
+		return []byte(u.String())
+	}), nil
+}
+
+func makeRedactTransform(cfg TransformConfig) (transformFunc, error) {
+
+	expr := cfg.Pattern
+	if cfg.Rule != "" {
+		var ok bool
+		if expr, ok = BuiltinDenyRules[cfg.Rule]; !ok {
+			return nil, fmt.Errorf("unknown builtin deny rule '%s'", cfg.Rule)
+		}
+	}
+
+	if expr == "" {
+		return nil, fmt.Errorf("redact needs either a pattern or a rule")
+	}
+
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid redact pattern: %w", err)
+	}
+
+	replacement := cfg.Replacement
+	if replacement == "" {
+		replacement = "[REDACTED]"
+	}
+
+	return func(_ context.Context, _ Direction, item *protocol.Item) ([]byte, error) {
+		return re.ReplaceAllLiteral(item.Data, []byte(replacement)), nil
+	}, nil
+}
+
+func makeCommandTransform(command []string, timeout time.Duration) transformFunc {
+
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+
+	return func(ctx context.Context, dir Direction, item *protocol.Item) ([]byte, error) {
+
+		ctx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+
+		cmd := exec.CommandContext(ctx, command[0], command[1:]...) // #nosec
+		cmd.Stdin = bytes.NewReader(item.Data)
+		cmd.Env = append(
+			os.Environ(),
+			"NETBOARD_DIRECTION="+string(dir),
+			"NETBOARD_ITEM_ID="+item.ID,
+			"NETBOARD_MIME="+item.MIME,
+		)
+
+		stdout := bytes.NewBuffer(nil)
+		cmd.Stdout = stdout
+		stderr := bytes.NewBuffer(nil)
+		cmd.Stderr = stderr
+
+		if err := cmd.Run(); err != nil {
+			return nil, fmt.Errorf("%w: %s", err, strings.TrimSpace(stderr.String()))
+		}
+
+		return stdout.Bytes(), nil
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/primalmotion/netboard/protocol"
+)
+
+func TestCompressItem(t *testing.T) {
+
+	data := bytes.Repeat([]byte("compressible "), 100)
+
+	blob := protocol.NewItem(data, time.Now())
+	blob.Blob = true
+
+	tests := []struct {
+		name         string
+		item         *protocol.Item
+		limits       *protocol.Limits
+		encoding     string
+		wantOK       bool
+		wantEncoding string
+	}{
+		{"compressed", protocol.NewItem(data, time.Now()), &protocol.Limits{Encodings: protocol.Encodings}, protocol.EncodingZstd, true, protocol.EncodingZstd},
+		{"unknown limits", protocol.NewItem(data, time.Now()), nil, protocol.EncodingZstd, true, ""},
+		{"encoding not accepted", protocol.NewItem(data, time.Now()), &protocol.Limits{Encodings: []string{protocol.EncodingGzip}}, protocol.EncodingZstd, true, ""},
+		{"blob", blob, &protocol.Limits{Encodings: protocol.Encodings}, protocol.EncodingZstd, true, ""},
+		{"compression failed", protocol.NewItem(data, time.Now()), &protocol.Limits{Encodings: []string{"br"}}, "br", false, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			item, ok := compressItem(tt.item, tt.limits, tt.encoding, 0)
+			if ok != tt.wantOK {
+				t.Fatalf("got %t, want %t", ok, tt.wantOK)
+			}
+			if !ok {
+				if item != nil {
+					t.Fatalf("got item %s for a skipped item", item.ID)
+				}
+				return
+			}
+
+			if item.Encoding != tt.wantEncoding {
+				t.Fatalf("got encoding %q, want %q", item.Encoding, tt.wantEncoding)
+			}
+			// The original item is left untouched.
+			if tt.item.Encoding != "" || !bytes.Equal(tt.item.Data, data) {
+				t.Fatalf("original item modified")
+			}
+		})
+	}
+}
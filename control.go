@@ -0,0 +1,404 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/primalmotion/netboard/protocol"
+	"github.com/spf13/viper"
+)
+
+// controlTimeout is the maximum time to wait
+// for the listen loop to handle a command.
+const controlTimeout = 10 * time.Second
+
+// A controlRequest is a command received on the control
+// socket. It is handled by the listen loop, which sends
+// its reply on the reply channel.
+type controlRequest struct {
+	Command string   `json:"command"`
+	Args    []string `json:"args,omitempty"`
+
+	reply chan controlReply
+}
+
+// A controlReply is the answer to a controlRequest.
+type controlReply struct {
+	Message string `json:"message,omitempty"`
+	Data    any    `json:"data,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+func newControlRequest(command string, args ...string) controlRequest {
+	return controlRequest{
+		Command: command,
+		Args:    args,
+		reply:   make(chan controlReply, 1),
+	}
+}
+
+// controlItem describes an item in the replies, without its content.
+type controlItem struct {
+	Source     string    `json:"source,omitempty"`
+	ID         string    `json:"id"`
+	Device     string    `json:"device,omitempty"`
+	DeviceName string    `json:"deviceName,omitempty"`
+	Time       time.Time `json:"time"`
+	MIME       string    `json:"mime,omitempty"`
+	Size       int       `json:"size"`
+}
+
+func newControlItem(item *protocol.Item) controlItem {
+
+	ci := controlItem{
+		ID:         item.ID,
+		Device:     item.Origin,
+		DeviceName: item.OriginName,
+		Time:       item.Time,
+		MIME:       item.MIME,
+		Size:       len(item.Data),
+	}
+
+	// The blobs not downloaded yet only have their size.
+	if item.Blob && len(item.Data) == 0 {
+		ci.Size = int(item.Size)
+	}
+
+	return ci
+}
+
+// controlStatus is the reply of the status command.
+type controlStatus struct {
+	State        string       `json:"state"`
+	URL          string       `json:"url"`
+	Transport    string       `json:"transport"`
+	Paused       bool         `json:"paused"`
+	Direction    string       `json:"direction"`
+	DoNotDisturb bool         `json:"doNotDisturb"`
+	Queued       int          `json:"queued"`
+	Staged       *controlItem `json:"staged,omitempty"`
+	Started      time.Time    `json:"started"`
+}
+
+// historyMaxDataSize is the size in bytes above which the
+// content of the items is not kept in the history.
+const historyMaxDataSize = 1 << 20
+
+// controlHistory holds the latest items sent
+// or received, the most recent first.
+type controlHistory struct {
+	items []controlItem
+	data  map[string]*protocol.Item
+	size  int
+}
+
+func newControlHistory(size int) *controlHistory {
+	return &controlHistory{
+		data: map[string]*protocol.Item{},
+		size: size,
+	}
+}
+
+// add records the given item, coming from the given source.
+// Its content is kept, if small enough, so it can be copied again.
+func (h *controlHistory) add(source string, item *protocol.Item) {
+
+	ci := newControlItem(item)
+	ci.Source = source
+
+	h.items = append([]controlItem{ci}, h.items...)
+	if len(h.items) > h.size {
+		evicted := h.items[h.size:]
+		h.items = h.items[:h.size]
+		// The same item may have been sent or received again.
+		for _, old := range evicted {
+			if !slices.ContainsFunc(h.items, func(i controlItem) bool { return i.ID == old.ID }) {
+				delete(h.data, old.ID)
+			}
+		}
+	}
+
+	if len(item.Data) <= historyMaxDataSize {
+		h.data[item.ID] = item
+	}
+}
+
+func (h *controlHistory) list() []controlItem {
+	return append([]controlItem{}, h.items...)
+}
+
+// get returns the item with the given ID, or the only one
+// whose ID starts with it. It returns an error if the item
+// is unknown, or if its content was not kept.
+func (h *controlHistory) get(id string) (*protocol.Item, error) {
+
+	var found *controlItem
+	for i, item := range h.items {
+		if item.ID == id {
+			found = &h.items[i]
+			break
+		}
+		if id != "" && strings.HasPrefix(item.ID, id) {
+			if found != nil && found.ID != item.ID {
+				return nil, fmt.Errorf("several items match %s", id)
+			}
+			found = &h.items[i]
+		}
+	}
+
+	if found == nil {
+		return nil, fmt.Errorf("no recent item matches %s", id)
+	}
+
+	item, ok := h.data[found.ID]
+	if !ok {
+		return nil, fmt.Errorf("item %s is too large to be copied again", found.ID)
+	}
+
+	if item.Expired() {
+		return nil, fmt.Errorf("item %s expired", found.ID)
+	}
+
+	return item, nil
+}
+
+// String returns a line describing the item.
+func (i controlItem) String() string {
+
+	device := i.DeviceName
+	if device == "" && len(i.Device) > 12 {
+		device = i.Device[:12]
+	}
+	if device == "" {
+		device = "this device"
+	}
+
+	id := i.ID
+	if len(id) > 12 {
+		id = id[:12]
+	}
+
+	mime := i.MIME
+	if mime == "" {
+		mime = "unknown type"
+	}
+
+	return fmt.Sprintf("%s from %s: %s, %d bytes, copied at %s", id, device, mime, i.Size, i.Time.Local().Format("15:04:05"))
+}
+
+// controlSocketFor returns the control socket given to the
+// command with the given name, or the one configured for listen.
+func controlSocketFor(name string) (string, error) {
+
+	if s := viper.GetString(name + ".control-socket"); s != "" {
+		return expandRuntimePath(s)
+	}
+
+	return expandRuntimePath(viper.GetString("listen.control-socket"))
+}
+
+// expandRuntimePath expands the environment variables of the given
+// path, like os.ExpandEnv. When XDG_RUNTIME_DIR is not set, it is
+// replaced by a directory of the user in the temporary directory,
+// which is refused if another user could use it.
+func expandRuntimePath(path string) (string, error) {
+
+	var fallback string
+
+	expanded := os.Expand(path, func(name string) string {
+
+		if v := os.Getenv(name); v != "" || name != "XDG_RUNTIME_DIR" {
+			return v
+		}
+
+		fallback = filepath.Join(os.TempDir(), fmt.Sprintf("netboard-%d", os.Getuid()))
+		return fallback
+	})
+
+	if fallback != "" {
+		if err := checkRuntimeDir(fallback); err != nil {
+			return "", err
+		}
+	}
+
+	return expanded, nil
+}
+
+// checkRuntimeDir creates the given directory if needed, and makes
+// sure only the current user can use it. The temporary directory is
+// shared, so another user could have created it first.
+func checkRuntimeDir(dir string) error {
+
+	if err := os.Mkdir(dir, 0700); err != nil && !errors.Is(err, os.ErrExist) {
+		return fmt.Errorf("unable to create runtime directory: %w", err)
+	}
+
+	info, err := os.Lstat(dir)
+	if err != nil {
+		return fmt.Errorf("unable to check runtime directory: %w", err)
+	}
+
+	stat, ok := info.Sys().(*syscall.Stat_t)
+
+	switch {
+	case !info.IsDir():
+		return fmt.Errorf("refusing to use runtime directory %s: not a directory", dir)
+	case !ok || int(stat.Uid) != os.Getuid():
+		return fmt.Errorf("refusing to use runtime directory %s: not owned by the current user", dir)
+	case info.Mode().Perm() != 0700:
+		return fmt.Errorf("refusing to use runtime directory %s: mode is %o instead of 700", dir, info.Mode().Perm())
+	}
+
+	return nil
+}
+
+// errControlSocketInUse is returned by serveControl when
+// another process, like another listen, serves the socket.
+var errControlSocketInUse = errors.New("control socket is in use")
+
+// serveControl serves the control API on a unix socket at the given
+// path until the context is canceled. The commands are sent to
+// the given channel. The API is a POST on /<command>, with the
+// arguments as a JSON array in the body, answered with a controlReply.
+func serveControl(ctx context.Context, path string, ch chan<- controlRequest) error {
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("unable to create control socket directory: %w", err)
+	}
+
+	// A socket left by a process that died is removed,
+	// but not the one of a process still running.
+	if conn, err := net.Dial("unix", path); err == nil {
+		conn.Close() // nolint
+		return errControlSocketInUse
+	}
+	_ = os.Remove(path)
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		if errors.Is(err, syscall.EADDRINUSE) {
+			return errControlSocketInUse
+		}
+		return fmt.Errorf("unable to listen on control socket: %w", err)
+	}
+
+	if err := os.Chmod(path, 0600); err != nil {
+		listener.Close() // nolint
+		return fmt.Errorf("unable to set mode of control socket: %w", err)
+	}
+
+	server := &http.Server{
+		Handler:           makeControlHandler(ctx, ch),
+		ReadHeaderTimeout: controlTimeout,
+	}
+
+	go func() {
+		<-ctx.Done()
+		server.Close() // nolint
+	}()
+
+	go server.Serve(listener) // nolint
+
+	return nil
+}
+
+func makeControlHandler(ctx context.Context, ch chan<- controlRequest) http.HandlerFunc {
+
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		req := newControlRequest(strings.TrimPrefix(r.URL.Path, "/"))
+
+		if r.ContentLength != 0 {
+			if err := json.NewDecoder(r.Body).Decode(&req.Args); err != nil && !errors.Is(err, io.EOF) {
+				http.Error(w, fmt.Sprintf("unable to decode arguments: %s", err), http.StatusBadRequest)
+				return
+			}
+		}
+
+		var reply controlReply
+
+		select {
+		case ch <- req:
+			select {
+			case reply = <-req.reply:
+			case <-time.After(controlTimeout):
+				reply = controlReply{Error: "timed out"}
+			}
+		case <-ctx.Done():
+			reply = controlReply{Error: "listen is stopping"}
+		case <-time.After(controlTimeout):
+			reply = controlReply{Error: "timed out"}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if reply.Error != "" {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+		_ = json.NewEncoder(w).Encode(reply)
+	}
+}
+
+// callControl sends the given command to the listen process
+// using the socket at the given path. It returns the message of
+// the reply, and decodes its data in out, if not nil.
+func callControl(path string, out any, command string, args ...string) (string, error) {
+
+	client := &http.Client{
+		Timeout: 2 * controlTimeout,
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, "unix", path)
+			},
+		},
+	}
+
+	body, err := json.Marshal(args)
+	if err != nil {
+		return "", fmt.Errorf("unable to encode arguments: %w", err)
+	}
+
+	resp, err := client.Post("http://netboard/"+command, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return "", fmt.Errorf("unable to reach listen on %s: %w", path, err)
+	}
+	defer resp.Body.Close() // nolint
+
+	reply := struct {
+		Message string          `json:"message"`
+		Data    json.RawMessage `json:"data"`
+		Error   string          `json:"error"`
+	}{}
+	if err := json.NewDecoder(resp.Body).Decode(&reply); err != nil {
+		return "", fmt.Errorf("unable to decode reply: %s", resp.Status)
+	}
+
+	if reply.Error != "" {
+		return "", errors.New(reply.Error)
+	}
+
+	if out != nil && len(reply.Data) > 0 {
+		if err := json.Unmarshal(reply.Data, out); err != nil {
+			return "", fmt.Errorf("unable to decode reply data: %w", err)
+		}
+	}
+
+	return reply.Message, nil
+}
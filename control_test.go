@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/primalmotion/netboard/protocol"
+)
+
+func TestControlHistory(t *testing.T) {
+
+	now := time.Now()
+	expired := now.Add(-time.Second)
+
+	a := protocol.NewItem([]byte("a"), now)
+	b := protocol.NewItem([]byte("b"), now)
+	c := protocol.NewItem([]byte("c"), now)
+	large := protocol.NewItem(bytes.Repeat([]byte("l"), historyMaxDataSize+1), now)
+	secret := protocol.NewItem([]byte("secret"), now)
+	secret.Expires = &expired
+
+	tests := []struct {
+		name    string
+		size    int
+		added   []*protocol.Item
+		get     string
+		want    *protocol.Item
+		wantErr string
+	}{
+		{"latest item", 2, []*protocol.Item{a, b}, b.ID, b, ""},
+		{"item by prefix", 2, []*protocol.Item{a, b}, a.ID[:8], a, ""},
+		{"evicted item", 2, []*protocol.Item{a, b, c}, a.ID, nil, "no recent item matches"},
+		{"item added again before eviction", 2, []*protocol.Item{a, b, a, c}, a.ID, a, ""},
+		{"item added again and evicted", 1, []*protocol.Item{a, a}, a.ID, a, ""},
+		{"large item", 2, []*protocol.Item{large}, large.ID, nil, "too large to be copied again"},
+		{"expired item", 2, []*protocol.Item{secret}, secret.ID, nil, "expired"},
+		{"unknown item", 2, []*protocol.Item{a}, "ffff", nil, "no recent item matches"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			h := newControlHistory(tt.size)
+			for _, item := range tt.added {
+				h.add("local", item)
+			}
+
+			if n := len(h.list()); n > tt.size {
+				t.Fatalf("history holds %d items, want at most %d", n, tt.size)
+			}
+
+			got, err := h.get(tt.get)
+
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if got.ID != tt.want.ID {
+				t.Fatalf("got item %s, want %s", got.ID, tt.want.ID)
+			}
+		})
+	}
+}
+
+func TestCheckRuntimeDir(t *testing.T) {
+
+	tests := []struct {
+		name    string
+		prepare func(dir string) error
+		wantErr string
+	}{
+		{"missing", func(dir string) error { return nil }, ""},
+		{"private", func(dir string) error { return os.Mkdir(dir, 0700) }, ""},
+		{"readable by others", func(dir string) error {
+			if err := os.Mkdir(dir, 0700); err != nil {
+				return err
+			}
+			return os.Chmod(dir, 0755)
+		}, "mode is 755"},
+		{"file", func(dir string) error { return os.WriteFile(dir, nil, 0600) }, "not a directory"},
+		{"symlink", func(dir string) error {
+			target := dir + "-target"
+			if err := os.Mkdir(target, 0700); err != nil {
+				return err
+			}
+			return os.Symlink(target, dir)
+		}, "not a directory"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			dir := filepath.Join(t.TempDir(), "netboard")
+			if err := tt.prepare(dir); err != nil {
+				t.Fatal(err)
+			}
+
+			err := checkRuntimeDir(dir)
+
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if info, err := os.Stat(dir); err != nil || info.Mode().Perm() != 0700 {
+				t.Fatalf("runtime directory not created private: %v", err)
+			}
+		})
+	}
+}
+
+func TestServeControlInUse(t *testing.T) {
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	path := filepath.Join(t.TempDir(), "control.sock")
+
+	if err := serveControl(ctx, path, make(chan controlRequest)); err != nil {
+		t.Fatalf("unable to serve control socket: %s", err)
+	}
+
+	if err := serveControl(ctx, path, make(chan controlRequest)); !errors.Is(err, errControlSocketInUse) {
+		t.Fatalf("got error %v, want %v", err, errControlSocketInUse)
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var ctlCmd = &cobra.Command{
+	Use:   "ctl <command> [args...]",
+	Short: "Control a running listen using its control socket",
+	Long: `Control a running listen using its control socket.
+
+Commands:
+  status      Show the connection state and the sync settings
+  pause       Stop sending and receiving items
+  resume      Start sending and receiving items again
+  direction   Show the sync direction, or set it to both, send-only or receive-only
+  push        Send the content of the clipboard now
+  recent      Show the latest items sent and received
+  copy <id>   Write a recent item to the clipboard again
+  reconnect   Drop the connection to the server and connect again
+  staged      Show the item waiting to be accepted
+  accept      Accept the waiting item
+  reject      Reject the waiting item`,
+	Args:          cobra.MinimumNArgs(1),
+	SilenceUsage:  true,
+	SilenceErrors: true,
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		if err := viper.BindPFlags(cmd.PersistentFlags()); err != nil {
+			return err
+		}
+		return viper.BindPFlags(cmd.Flags())
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+
+		socket, err := controlSocketFor("ctl")
+		if err != nil {
+			return err
+		}
+
+		var data json.RawMessage
+		msg, err := callControl(socket, &data, args[0], args[1:]...)
+		if err != nil {
+			return err
+		}
+
+		if msg != "" {
+			fmt.Println(msg)
+		}
+
+		if len(data) == 0 {
+			return nil
+		}
+
+		switch args[0] {
+
+		case "status":
+			status := controlStatus{}
+			if err := json.Unmarshal(data, &status); err != nil {
+				return fmt.Errorf("unable to decode status: %w", err)
+			}
+			printStatus(status)
+
+		case "recent":
+			items := []controlItem{}
+			if err := json.Unmarshal(data, &items); err != nil {
+				return fmt.Errorf("unable to decode items: %w", err)
+			}
+			if len(items) == 0 {
+				fmt.Println("no item sent or received yet")
+			}
+			for _, item := range items {
+				fmt.Printf("%-6s %s\n", item.Source, item)
+			}
+
+		case "staged", "accept", "reject", "copy":
+			item := controlItem{}
+			if err := json.Unmarshal(data, &item); err != nil {
+				return fmt.Errorf("unable to decode item: %w", err)
+			}
+			fmt.Println(item)
+
+		default:
+			out, err := json.MarshalIndent(data, "", "  ")
+			if err != nil {
+				return fmt.Errorf("unable to encode reply: %w", err)
+			}
+			fmt.Println(string(out))
+		}
+
+		return nil
+	},
+}
+
+func printStatus(status controlStatus) {
+
+	fmt.Printf("state:          %s\n", status.State)
+	fmt.Printf("server:         %s\n", status.URL)
+	fmt.Printf("transport:      %s\n", status.Transport)
+	fmt.Printf("paused:         %t\n", status.Paused)
+	fmt.Printf("direction:      %s\n", status.Direction)
+	fmt.Printf("do not disturb: %t\n", status.DoNotDisturb)
+	fmt.Printf("queued:         %d\n", status.Queued)
+	if status.Staged != nil {
+		fmt.Printf("waiting:        %s\n", status.Staged)
+	}
+	fmt.Printf("uptime:         %s\n", time.Since(status.Started).Round(time.Second))
+}
+
+func init() {
+	ctlCmd.Flags().String("control-socket", "", "Path to the control socket of listen. Defaults to the one configured for listen")
+	_ = viper.BindPFlag("ctl.control-socket", ctlCmd.Flags().Lookup("control-socket"))
+}
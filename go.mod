@@ -1,9 +1,11 @@
 module github.com/primalmotion/netboard
 
-go 1.20
+go 1.22
 
 require (
+	github.com/godbus/dbus/v5 v5.1.0
 	github.com/gorilla/websocket v1.5.0
+	github.com/klauspost/compress v1.18.0
 	github.com/mitchellh/go-homedir v1.1.0
 	github.com/spf13/cobra v1.7.0
 	github.com/spf13/viper v1.15.0
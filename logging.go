@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"log/slog"
+	"os"
+
+	"github.com/spf13/viper"
+)
+
+// initLogging configures the default logger according to
+// the log level and format. It must run after the config
+// is read, so both can be set there.
+func initLogging() {
+
+	logger, err := newLogger(viper.GetString("log.level"), viper.GetString("log.format"))
+	if err != nil {
+		log.Fatalln("unable to configure logs:", err)
+	}
+
+	slog.SetDefault(logger)
+}
+
+// newLogger returns a logger writing to stderr with the given
+// level and format. The clipboard contents must never be logged:
+// only their identifiers, sizes and types.
+func newLogger(level string, format string) (*slog.Logger, error) {
+
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		return nil, err
+	}
+
+	opts := &slog.HandlerOptions{Level: lvl}
+
+	switch format {
+	case "text":
+		return slog.New(slog.NewTextHandler(os.Stderr, opts)), nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(os.Stderr, opts)), nil
+	default:
+		return nil, fmt.Errorf("unsupported log format %s", format)
+	}
+}
@@ -28,7 +28,7 @@ var (
 
 func main() {
 
-	cobra.OnInitialize(initCobra)
+	cobra.OnInitialize(initCobra, initLogging)
 
 	rootCmd := &cobra.Command{
 		Use:              "netboard",
@@ -51,9 +51,18 @@ func main() {
 	}
 	rootCmd.Flags().Bool("version", false, "Show version")
 
+	rootCmd.PersistentFlags().String("log-level", "info", "Level of the logs. debug, info, warn or error")
+	_ = viper.BindPFlag("log.level", rootCmd.PersistentFlags().Lookup("log-level"))
+
+	rootCmd.PersistentFlags().String("log-format", "text", "Format of the logs. text or json")
+	_ = viper.BindPFlag("log.format", rootCmd.PersistentFlags().Lookup("log-format"))
+
 	rootCmd.AddCommand(
 		serverCmd,
 		listenCmd,
+		acceptCmd,
+		ctlCmd,
+		pickCmd,
 	)
 
 	mainCtx, cancelFunc := context.WithCancel(context.Background())
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/primalmotion/netboard/client"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// A pickEntry is an item offered by pick.
+type pickEntry struct {
+	controlItem
+
+	// server is the url of the server holding the
+	// item, or empty if only listen knows about it.
+	server string
+}
+
+func (e pickEntry) String() string {
+
+	var where []string
+	switch e.Source {
+	case "local":
+		where = append(where, "sent")
+	case "remote":
+		where = append(where, "received")
+	}
+	if e.server != "" {
+		where = append(where, "server")
+	}
+
+	return fmt.Sprintf("%s [%s]", e.controlItem, strings.Join(where, ", "))
+}
+
+var pickCmd = &cobra.Command{
+	Use:           "pick",
+	Short:         "Pick a recent item with a menu program and write it to the clipboard",
+	Args:          cobra.MaximumNArgs(0),
+	SilenceUsage:  true,
+	SilenceErrors: true,
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		if err := viper.BindPFlags(cmd.PersistentFlags()); err != nil {
+			return err
+		}
+		return viper.BindPFlags(cmd.Flags())
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+
+		picker := viper.GetString("pick.picker")
+		limit := viper.GetInt("pick.limit")
+		socket, socketErr := controlSocketFor("pick")
+
+		urls := viper.GetStringSlice("listen.url")
+		certPath := os.ExpandEnv(viper.GetString("listen.cert"))
+		certKeyPath := os.ExpandEnv(viper.GetString("listen.cert-key"))
+		certKeyPass := viper.GetString("listen.cert-key-pass")
+		serverCAPath := os.ExpandEnv(viper.GetString("listen.server-ca"))
+		skipVerify := viper.GetBool("listen.insecure-skip-verify")
+		mode := viper.GetString("listen.mode")
+		blobCache := os.ExpandEnv(viper.GetString("listen.blob-cache"))
+		downloadDir := os.ExpandEnv(viper.GetString("listen.download-dir"))
+
+		if picker == "" {
+			return fmt.Errorf("no picker given")
+		}
+
+		var entries []pickEntry
+
+		// The items listen sent and received.
+		local := []controlItem{}
+		if socketErr != nil {
+			slog.Debug("unable to retrieve recent items from listen", "error", socketErr)
+		} else if _, err := callControl(socket, &local, "recent"); err != nil {
+			slog.Debug("unable to retrieve recent items from listen", "error", err)
+		}
+		for _, item := range local {
+			entries = append(entries, pickEntry{controlItem: item})
+		}
+
+		// The history of the first server answering.
+		tlsConf, _, err := newClientTLSConfig(certPath, certKeyPath, certKeyPass, serverCAPath, skipVerify)
+		if err != nil {
+			slog.Debug("unable to prepare server connection", "error", err)
+		}
+		servers := client.NewServers(tlsConf, urls...)
+		for _, u := range urls {
+
+			if tlsConf == nil {
+				break
+			}
+
+			items, err := client.FetchHistory(u, servers.Client(), limit)
+			if err != nil {
+				slog.Warn("unable to retrieve server history", "url", u, "error", err)
+				continue
+			}
+
+			for _, item := range items {
+
+				if i := slices.IndexFunc(entries, func(e pickEntry) bool { return e.ID == item.ID }); i >= 0 {
+					entries[i].server = u
+					continue
+				}
+
+				ci := newControlItem(item)
+				ci.Size = int(item.Size)
+				entries = append(entries, pickEntry{controlItem: ci, server: u})
+			}
+
+			break
+		}
+
+		if len(entries) == 0 {
+			return fmt.Errorf("no item to pick from: is listen running, or the server reachable?")
+		}
+
+		slices.SortStableFunc(entries, func(a, b pickEntry) int { return b.Time.Compare(a.Time) })
+		if limit > 0 && len(entries) > limit {
+			entries = entries[:limit]
+		}
+
+		input := &bytes.Buffer{}
+		for i, e := range entries {
+			fmt.Fprintf(input, "%d %s\n", i+1, e)
+		}
+
+		pick := exec.CommandContext(cmd.Context(), "sh", "-c", picker) // #nosec
+		pick.Stdin = input
+		pick.Stderr = os.Stderr
+
+		out, err := pick.Output()
+		if err != nil {
+			// Menu programs exit with 1, or 130 when interrupted,
+			// when nothing was picked.
+			var exitErr *exec.ExitError
+			if errors.As(err, &exitErr) && (exitErr.ExitCode() == 1 || exitErr.ExitCode() == 130) {
+				return nil
+			}
+			return fmt.Errorf("unable to run picker: %w", err)
+		}
+
+		fields := strings.Fields(string(out))
+		if len(fields) == 0 {
+			return nil
+		}
+
+		n, err := strconv.Atoi(fields[0])
+		if err != nil || n < 1 || n > len(entries) {
+			return fmt.Errorf("unable to find picked item: %s", strings.TrimSpace(string(out)))
+		}
+		entry := entries[n-1]
+
+		// Only listen holds the content of the
+		// items the server does not keep.
+		if entry.server == "" {
+			if _, err := callControl(socket, nil, "copy", entry.ID); err != nil {
+				return err
+			}
+			return nil
+		}
+
+		item, err := client.FetchHistoryItem(entry.server, servers.Client(), entry.ID)
+		if err != nil {
+			return fmt.Errorf("unable to retrieve item: %w", err)
+		}
+
+		if item.Blob {
+			var maxSize int64
+			if limits, err := client.FetchLimits(entry.server, servers.Client()); err == nil {
+				maxSize = limits.For(item.MIME)
+			}
+			if item.Data, err = client.FetchBlob(cmd.Context(), entry.server, servers.Client(), item.ID, maxSize, blobCache); err != nil {
+				return fmt.Errorf("unable to retrieve item content: %w", err)
+			}
+			// The content of an expiring item is not
+			// kept in the cache, as nothing would evict
+			// it when the item expires.
+			if item.Expires != nil {
+				if err := client.RemoveCachedBlob(blobCache, item.ID); err != nil {
+					slog.Warn("unable to remove expiring blob", "item", item.ID, "error", err)
+				}
+			}
+		}
+
+		cb, err := newClipboardManager(mode)
+		if err != nil {
+			return err
+		}
+
+		if err := writeClipboard(cb, item, downloadDir); err != nil {
+			return fmt.Errorf("unable to write to local clipboard: %w", err)
+		}
+
+		return nil
+	},
+}
+
+func init() {
+	pickCmd.Flags().String("picker", "fzf", "Shell command of the menu reading the items on stdin and printing the picked one, like 'rofi -dmenu' or 'dmenu -l 20'")
+	_ = viper.BindPFlag("pick.picker", pickCmd.Flags().Lookup("picker"))
+
+	pickCmd.Flags().Int("limit", 50, "Maximum number of items to pick from. 0 means all of them")
+	_ = viper.BindPFlag("pick.limit", pickCmd.Flags().Lookup("limit"))
+
+	pickCmd.Flags().String("control-socket", "", "Path to the control socket of listen. Defaults to the one configured for listen")
+	_ = viper.BindPFlag("pick.control-socket", pickCmd.Flags().Lookup("control-socket"))
+}
@@ -0,0 +1,142 @@
+package protocol
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Supported encodings of the item data.
+const (
+	// EncodingGzip compresses the data with gzip.
+	EncodingGzip = "gzip"
+
+	// EncodingZstd compresses the data with zstandard.
+	EncodingZstd = "zstd"
+)
+
+// Encodings lists the encodings supported
+// by this version of the protocol.
+var Encodings = []string{EncodingZstd, EncodingGzip}
+
+// ErrDecompressedTooLarge is returned when decompressed
+// data exceeds the allowed maximum size.
+var ErrDecompressedTooLarge = errors.New("decompressed data is too large")
+
+// MaxDecompressedSize is the size the compressed data of
+// the received items can expand to when no lower limit
+// is known, so small messages cannot exhaust the memory.
+const MaxDecompressedSize = 256 << 20
+
+// IsSupportedEncoding returns true if the given
+// encoding is supported.
+func IsSupportedEncoding(encoding string) bool {
+
+	for _, e := range Encodings {
+		if e == encoding {
+			return true
+		}
+	}
+
+	return false
+}
+
+// NegotiateEncoding returns the first supported encoding of the
+// given comma separated list of accepted encodings, or an empty
+// string if none is supported.
+func NegotiateEncoding(accepted string) string {
+
+	for _, e := range strings.Split(accepted, ",") {
+		if e = strings.TrimSpace(e); IsSupportedEncoding(e) {
+			return e
+		}
+	}
+
+	return ""
+}
+
+// Compress compresses the given data with the given encoding.
+func Compress(encoding string, data []byte) ([]byte, error) {
+
+	buf := bytes.NewBuffer(nil)
+
+	var w io.WriteCloser
+	switch encoding {
+	case EncodingGzip:
+		w = gzip.NewWriter(buf)
+	case EncodingZstd:
+		zw, err := zstd.NewWriter(buf)
+		if err != nil {
+			return nil, fmt.Errorf("unable to prepare zstd encoder: %w", err)
+		}
+		w = zw
+	default:
+		return nil, fmt.Errorf("unsupported encoding: %s", encoding)
+	}
+
+	if _, err := w.Write(data); err != nil {
+		w.Close() // nolint
+		return nil, fmt.Errorf("unable to compress data: %w", err)
+	}
+
+	if err := w.Close(); err != nil {
+		return nil, fmt.Errorf("unable to compress data: %w", err)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// NewDecompressReader returns a reader decompressing the
+// content of the given reader with the given encoding.
+func NewDecompressReader(encoding string, r io.Reader) (io.ReadCloser, error) {
+
+	switch encoding {
+	case EncodingGzip:
+		gr, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, fmt.Errorf("unable to prepare gzip decoder: %w", err)
+		}
+		return gr, nil
+	case EncodingZstd:
+		zr, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, fmt.Errorf("unable to prepare zstd decoder: %w", err)
+		}
+		return zr.IOReadCloser(), nil
+	default:
+		return nil, fmt.Errorf("unsupported encoding: %s", encoding)
+	}
+}
+
+// Decompress decompresses the given data with the given encoding.
+// If max is greater than 0, it returns ErrDecompressedTooLarge
+// if the decompressed data is bigger than max bytes.
+func Decompress(encoding string, data []byte, max int64) ([]byte, error) {
+
+	r, err := NewDecompressReader(encoding, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close() // nolint
+
+	var src io.Reader = r
+	if max > 0 {
+		src = io.LimitReader(r, max+1)
+	}
+
+	out, err := io.ReadAll(src)
+	if err != nil {
+		return nil, fmt.Errorf("unable to decompress data: %w", err)
+	}
+
+	if max > 0 && int64(len(out)) > max {
+		return nil, ErrDecompressedTooLarge
+	}
+
+	return out, nil
+}
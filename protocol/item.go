@@ -0,0 +1,223 @@
+package protocol
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"time"
+)
+
+// Item represents a clipboard item exchanged
+// between the server and the clients.
+type Item struct {
+
+	// Kind is the kind of message. Empty means KindItem.
+	Kind string `json:"kind,omitempty"`
+
+	// ID is the content address of the item.
+	// See ComputeID.
+	ID string `json:"id"`
+
+	// Origin is the fingerprint of the device
+	// that published the item.
+	Origin string `json:"origin,omitempty"`
+
+	// OriginName is the common name of the certificate
+	// of the device that published the item.
+	OriginName string `json:"originName,omitempty"`
+
+	// Groups are the organizations and organizational
+	// units of the certificate of the device that
+	// published the item.
+	Groups []string `json:"groups,omitempty"`
+
+	// Time is the time the item was copied at.
+	Time time.Time `json:"time"`
+
+	// MIME is the media type of the data.
+	MIME string `json:"mime,omitempty"`
+
+	// Via lists the IDs of the federated servers the item
+	// went through, so they never republish it twice.
+	Via []string `json:"via,omitempty"`
+
+	// Expires is the time after which the item must
+	// be removed from the clipboards. Nil means never.
+	Expires *time.Time `json:"expires,omitempty"`
+
+	// Blob tells the content of the item is not in Data,
+	// but must be fetched from the server blob storage,
+	// using the ID of the item.
+	Blob bool `json:"blob,omitempty"`
+
+	// Size is the size of the content of the item.
+	// It is only set for blob items.
+	Size int64 `json:"size,omitempty"`
+
+	// Encoding is the compression applied to Data,
+	// if any. See Encodings.
+	Encoding string `json:"encoding,omitempty"`
+
+	// Data is the content of the item.
+	Data []byte `json:"data,omitempty"`
+}
+
+// NewItem returns a new Item holding the given data
+// copied at the given time.
+func NewItem(data []byte, t time.Time) *Item {
+	return &Item{
+		ID:   ComputeID(data),
+		Time: t,
+		MIME: DetectMIME(data),
+		Data: data,
+	}
+}
+
+// NewClearItem returns a new Item of kind KindClear,
+// asking subscribers to remove the item with the given
+// ID from their clipboard.
+func NewClearItem(id string) *Item {
+	return &Item{
+		Kind: KindClear,
+		ID:   id,
+		Time: time.Now(),
+	}
+}
+
+// IsClear returns true if the item is of kind KindClear.
+func (i *Item) IsClear() bool {
+	return i.Kind == KindClear
+}
+
+// Expired returns true if the item has an
+// expiration time in the past.
+func (i *Item) Expired() bool {
+	return i.Expires != nil && !time.Now().Before(*i.Expires)
+}
+
+// ComputeID returns the content address of the given data.
+func ComputeID(data []byte) string {
+	h := sha256.Sum256(data)
+	return hex.EncodeToString(h[:])
+}
+
+// IsValidID returns true if the given
+// string is a valid content address.
+func IsValidID(id string) bool {
+
+	if len(id) != sha256.Size*2 {
+		return false
+	}
+
+	_, err := hex.DecodeString(id)
+	return err == nil
+}
+
+// ComputeLooseID returns the content address of the given
+// data, ignoring trailing new lines. It allows to match
+// items altered by clipboard backends that trim them.
+func ComputeLooseID(data []byte) string {
+	return ComputeID(bytes.TrimRight(data, "\r\n"))
+}
+
+// DetectMIME returns the media type of the given data,
+// without parameters.
+func DetectMIME(data []byte) string {
+
+	t, _, err := mime.ParseMediaType(http.DetectContentType(data))
+	if err != nil {
+		return "application/octet-stream"
+	}
+
+	return t
+}
+
+// Fingerprint returns the fingerprint of the given
+// raw certificate, used to identify devices.
+func Fingerprint(raw []byte) string {
+	return fmt.Sprintf("%02X", sha256.Sum256(raw)) // #nosec
+}
+
+// Compressed returns a copy of the item with its data compressed
+// with the given encoding. If the data is smaller than threshold
+// bytes, or if compressing it does not save anything, the item
+// is returned unchanged.
+func (i *Item) Compressed(encoding string, threshold int) (*Item, error) {
+
+	if encoding == "" || i.Encoding != "" || len(i.Data) == 0 || len(i.Data) < threshold {
+		return i, nil
+	}
+
+	data, err := Compress(encoding, i.Data)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) >= len(i.Data) {
+		return i, nil
+	}
+
+	c := *i
+	c.Encoding = encoding
+	c.Data = data
+
+	return &c, nil
+}
+
+// Encode encodes the item as a stream message. A message is
+// the base64 (raw url encoding) of the JSON representation
+// of the item, terminated by MessageSeparator.
+func (i *Item) Encode() ([]byte, error) {
+
+	data, err := json.Marshal(i)
+	if err != nil {
+		return nil, fmt.Errorf("unable to encode item: %w", err)
+	}
+
+	out := make([]byte, base64.RawURLEncoding.EncodedLen(len(data)), base64.RawURLEncoding.EncodedLen(len(data))+1)
+	base64.RawURLEncoding.Encode(out, data)
+
+	return append(out, MessageSeparator), nil
+}
+
+// Decode decodes an item from the given stream message.
+// The trailing MessageSeparator is optional. Compressed
+// data is decompressed, and ErrDecompressedTooLarge is
+// returned if it exceeds max bytes. If max is 0,
+// MaxDecompressedSize is used.
+func Decode(msg []byte, max int64) (*Item, error) {
+
+	msg = bytes.TrimSuffix(msg, []byte{MessageSeparator})
+
+	data := make([]byte, base64.RawURLEncoding.DecodedLen(len(msg)))
+	n, err := base64.RawURLEncoding.Decode(data, msg)
+	if err != nil {
+		return nil, fmt.Errorf("unable to decode message: %w", err)
+	}
+
+	item := &Item{}
+	if err := json.Unmarshal(data[:n], item); err != nil {
+		return nil, fmt.Errorf("unable to decode item: %w", err)
+	}
+
+	if item.Encoding != "" {
+		if max <= 0 {
+			max = MaxDecompressedSize
+		}
+		if item.Data, err = Decompress(item.Encoding, item.Data, max); err != nil {
+			return nil, err
+		}
+		item.Encoding = ""
+	}
+
+	if !item.IsClear() && !item.Blob && item.ID != ComputeID(item.Data) {
+		return nil, fmt.Errorf("item id does not match its content")
+	}
+
+	return item, nil
+}
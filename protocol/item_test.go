@@ -0,0 +1,130 @@
+package protocol
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDecode(t *testing.T) {
+
+	small := bytes.Repeat([]byte("a"), 1024)
+	large := bytes.Repeat([]byte("a"), 4096)
+
+	tests := []struct {
+		name     string
+		data     []byte
+		encoding string
+		max      int64
+		wantErr  error
+	}{
+		{"uncompressed", small, "", 0, nil},
+		{"uncompressed above max", large, "", 1024, nil},
+		{"zstd within max", small, EncodingZstd, 1024, nil},
+		{"zstd above max", large, EncodingZstd, 1024, ErrDecompressedTooLarge},
+		{"gzip within max", small, EncodingGzip, 1024, nil},
+		{"gzip above max", large, EncodingGzip, 1024, ErrDecompressedTooLarge},
+		{"zstd with default max", large, EncodingZstd, 0, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			item := NewItem(tt.data, time.Now())
+
+			if tt.encoding != "" {
+				var err error
+				if item, err = item.Compressed(tt.encoding, 0); err != nil {
+					t.Fatalf("unable to compress item: %s", err)
+				}
+				if item.Encoding != tt.encoding {
+					t.Fatalf("item was not compressed")
+				}
+			}
+
+			msg, err := item.Encode()
+			if err != nil {
+				t.Fatalf("unable to encode item: %s", err)
+			}
+
+			decoded, err := Decode(msg, tt.max)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("got error %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if !bytes.Equal(decoded.Data, tt.data) || decoded.Encoding != "" {
+				t.Fatalf("decoded item does not match")
+			}
+		})
+	}
+}
+
+func TestComputeID(t *testing.T) {
+
+	tests := []struct {
+		name      string
+		a         []byte
+		b         []byte
+		wantSame  bool
+		wantLoose bool
+	}{
+		{"same data", []byte("hello"), []byte("hello"), true, true},
+		{"other data", []byte("hello"), []byte("world"), false, false},
+		{"trailing new line", []byte("hello\n"), []byte("hello"), false, true},
+		{"trailing carriage return", []byte("hello\r\n"), []byte("hello"), false, true},
+		{"leading new line", []byte("\nhello"), []byte("hello"), false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			id := ComputeID(tt.a)
+			if !IsValidID(id) {
+				t.Fatalf("computed invalid id %s", id)
+			}
+
+			if got := id == ComputeID(tt.b); got != tt.wantSame {
+				t.Fatalf("same id: got %t, want %t", got, tt.wantSame)
+			}
+			if got := ComputeLooseID(tt.a) == ComputeLooseID(tt.b); got != tt.wantLoose {
+				t.Fatalf("same loose id: got %t, want %t", got, tt.wantLoose)
+			}
+		})
+	}
+
+	// The well known SHA-256 of "abc".
+	if got, want := ComputeID([]byte("abc")), "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad"; got != want {
+		t.Fatalf("got id %s, want %s", got, want)
+	}
+}
+
+func TestIsValidID(t *testing.T) {
+
+	tests := []struct {
+		name string
+		id   string
+		want bool
+	}{
+		{"valid", ComputeID([]byte("hello")), true},
+		{"too short", ComputeID([]byte("hello"))[:10], false},
+		{"not hexadecimal", strings.Repeat("z", 64), false},
+		{"path traversal", "../" + ComputeID([]byte("hello"))[3:], false},
+		{"empty", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsValidID(tt.id); got != tt.want {
+				t.Fatalf("got %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,81 @@
+package protocol
+
+import (
+	"path"
+)
+
+// A MIMELimit is the maximum size of the items
+// whose MIME type matches Type, like image/*.
+type MIMELimit struct {
+	Type    string `json:"type" mapstructure:"type"`
+	MaxSize int64  `json:"maxSize" mapstructure:"max-size"`
+}
+
+// Limits are the limits enforced by the server
+// on published items.
+type Limits struct {
+
+	// MaxSize is the maximum size of an item in
+	// bytes. 0 means no limit.
+	MaxSize int64 `json:"maxSize"`
+
+	// MIME are the limits applying to specific MIME types.
+	// The first matching one takes precedence over MaxSize.
+	MIME []MIMELimit `json:"mime,omitempty"`
+
+	// BlobThreshold is the size in bytes above which items
+	// should be uploaded as blobs rather than published
+	// inline. 0 means the server does not support blobs.
+	BlobThreshold int64 `json:"blobThreshold,omitempty"`
+
+	// Encodings are the encodings the server accepts
+	// for the body of published items.
+	Encodings []string `json:"encodings,omitempty"`
+}
+
+// Accepts returns true if the server accepts
+// published items compressed with the given encoding.
+func (l *Limits) Accepts(encoding string) bool {
+
+	for _, e := range l.Encodings {
+		if e == encoding {
+			return true
+		}
+	}
+
+	return false
+}
+
+// For returns the maximum size of an item of the
+// given MIME type. 0 means no limit.
+func (l *Limits) For(mime string) int64 {
+
+	for _, m := range l.MIME {
+		if ok, _ := path.Match(m.Type, mime); ok {
+			return m.MaxSize
+		}
+	}
+
+	return l.MaxSize
+}
+
+// Max returns the maximum size of an item,
+// whatever its type. 0 means no limit.
+func (l *Limits) Max() int64 {
+
+	if l.MaxSize == 0 {
+		return 0
+	}
+
+	max := l.MaxSize
+	for _, m := range l.MIME {
+		if m.MaxSize == 0 {
+			return 0
+		}
+		if m.MaxSize > max {
+			max = m.MaxSize
+		}
+	}
+
+	return max
+}
@@ -0,0 +1,84 @@
+package protocol
+
+import (
+	"testing"
+)
+
+func TestLimits(t *testing.T) {
+
+	limits := Limits{
+		MaxSize: 1024,
+		MIME: []MIMELimit{
+			{Type: "image/png", MaxSize: 4096},
+			{Type: "image/*", MaxSize: 2048},
+		},
+	}
+
+	tests := []struct {
+		name   string
+		limits Limits
+		mime   string
+		want   int64
+	}{
+		{"no limit", Limits{}, "text/plain", 0},
+		{"default limit", limits, "text/plain", 1024},
+		{"mime limit", limits, "image/jpeg", 2048},
+		{"first matching mime limit", limits, "image/png", 4096},
+		{"empty mime", limits, "", 1024},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.limits.For(tt.mime); got != tt.want {
+				t.Fatalf("got %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLimitsMax(t *testing.T) {
+
+	tests := []struct {
+		name   string
+		limits Limits
+		want   int64
+	}{
+		{"no limit", Limits{}, 0},
+		{"default limit", Limits{MaxSize: 1024}, 1024},
+		{"bigger mime limit", Limits{MaxSize: 1024, MIME: []MIMELimit{{Type: "image/*", MaxSize: 2048}}}, 2048},
+		{"smaller mime limit", Limits{MaxSize: 1024, MIME: []MIMELimit{{Type: "image/*", MaxSize: 512}}}, 1024},
+		{"unlimited mime", Limits{MaxSize: 1024, MIME: []MIMELimit{{Type: "image/*"}}}, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.limits.Max(); got != tt.want {
+				t.Fatalf("got %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLimitsAccepts(t *testing.T) {
+
+	tests := []struct {
+		name      string
+		encodings []string
+		encoding  string
+		want      bool
+	}{
+		{"accepted", Encodings, EncodingZstd, true},
+		{"other accepted", Encodings, EncodingGzip, true},
+		{"unknown", Encodings, "br", false},
+		{"old server", nil, EncodingZstd, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := Limits{Encodings: tt.encodings}
+			if got := l.Accepts(tt.encoding); got != tt.want {
+				t.Fatalf("got %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
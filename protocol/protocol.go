@@ -0,0 +1,44 @@
+package protocol
+
+// MessageSeparator terminates every message
+// sent in a subscription stream.
+const MessageSeparator = ','
+
+// Various kinds of messages.
+const (
+	// KindItem is a message holding a clipboard item.
+	KindItem = "item"
+
+	// KindClear is a message asking to remove an
+	// expired item from the clipboard.
+	KindClear = "clear"
+)
+
+// FilesMIME is the media type of the items holding copied
+// files. Their data is a tar archive of the files.
+const FilesMIME = "application/x-netboard-files"
+
+// Various headers used by the protocol.
+const (
+	// TimeHeader carries the time the published data was
+	// copied at. It is only sent when publishing data copied
+	// a while ago, like when the server was unreachable.
+	TimeHeader = "X-Netboard-Time"
+
+	// IDHeader carries the ID of the item
+	// created by a publish request.
+	IDHeader = "X-Netboard-Item-ID"
+
+	// TTLHeader carries the time to live of the
+	// published data, as a duration (like 30s).
+	TTLHeader = "X-Netboard-TTL"
+
+	// BlobHeader carries the ID of a blob previously
+	// uploaded to the server, when publishing a blob item.
+	BlobHeader = "X-Netboard-Blob"
+
+	// AcceptEncodingHeader carries the comma separated list
+	// of encodings a subscriber accepts for the item data,
+	// by order of preference.
+	AcceptEncodingHeader = "X-Netboard-Accept-Encoding"
+)
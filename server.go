@@ -3,10 +3,14 @@ package main
 import (
 	"crypto/tls"
 	"crypto/x509"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"os"
+	"os/signal"
+	"syscall"
 
+	"github.com/primalmotion/netboard/protocol"
 	"github.com/primalmotion/netboard/server"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
@@ -31,8 +35,58 @@ var serverCmd = &cobra.Command{
 		certKeyPath := os.ExpandEnv(viper.GetString("server.cert-key"))
 		certKeyPass := viper.GetString("server.cert-key-pass")
 		clientCAPath := os.ExpandEnv(viper.GetString("server.client-ca"))
+		storageKind := viper.GetString("server.storage")
+		storagePath := os.ExpandEnv(viper.GetString("server.storage-path"))
+		storageKeyPath := os.ExpandEnv(viper.GetString("server.storage-key"))
+		historySize := viper.GetInt("server.history-size")
+		historyMaxAge := viper.GetDuration("server.history-max-age")
+		blobDir := os.ExpandEnv(viper.GetString("server.blob-dir"))
+		blobThreshold := viper.GetInt64("server.blob-threshold")
+		sharedBlobDir := viper.GetBool("server.shared-blob-dir")
+		compressionThreshold := viper.GetInt("server.compression-threshold")
+		backplaneURL := viper.GetString("server.backplane")
+		backplaneChannel := viper.GetString("server.backplane-channel")
+		healthListenAddr := viper.GetString("server.health-listen")
+		auditLogPath := os.ExpandEnv(viper.GetString("server.audit-log"))
+		auditLogMaxSize := viper.GetInt64("server.audit-log-max-size")
+		auditLogMaxFiles := viper.GetInt("server.audit-log-max-files")
+		webhookDeadLetter := os.ExpandEnv(viper.GetString("server.webhook-dead-letter"))
 
-		log.Println("Server is listening on:", listenAddr)
+		rateLimits := struct {
+			Publish    server.RateLimit `mapstructure:"publish"`
+			Connection server.RateLimit `mapstructure:"connection"`
+		}{
+			Publish:    server.RateLimit{Rate: 2, Burst: 20},
+			Connection: server.RateLimit{Rate: 0.5, Burst: 10},
+		}
+		if err := viper.UnmarshalKey("server.rate-limits", &rateLimits); err != nil {
+			return fmt.Errorf("unable to read rate limits: %w", err)
+		}
+
+		settings, err := readServerSettings()
+		if err != nil {
+			return err
+		}
+
+		var webhooks []server.Webhook
+		if err := viper.UnmarshalKey("server.webhooks", &webhooks); err != nil {
+			return fmt.Errorf("unable to read webhooks configuration: %w", err)
+		}
+
+		federation := struct {
+			ID          string   `mapstructure:"id"`
+			Cert        string   `mapstructure:"cert"`
+			CertKey     string   `mapstructure:"cert-key"`
+			CertKeyPass string   `mapstructure:"cert-key-pass"`
+			ServerCA    string   `mapstructure:"server-ca"`
+			Peers       []string `mapstructure:"peers"`
+			Groups      []string `mapstructure:"groups"`
+		}{}
+		if err := viper.UnmarshalKey("server.federation", &federation); err != nil {
+			return fmt.Errorf("unable to read federation configuration: %w", err)
+		}
+
+		slog.Info("server is listening", "address", listenAddr)
 
 		x509Cert, x509Key, err := tglib.ReadCertificatePEM(certPath, certKeyPath, certKeyPass)
 		if err != nil {
@@ -58,10 +112,165 @@ var serverCmd = &cobra.Command{
 			ClientCAs:    clientCAPool,
 		}
 
-		return server.Serve(cmd.Context(), listenAddr, tlsConf)
+		retention := server.Retention{
+			MaxItems: historySize,
+			MaxAge:   historyMaxAge,
+		}
+
+		var store server.Storage
+		switch storageKind {
+		case "memory":
+			store = server.NewMemoryStorage(retention)
+		case "file":
+			var key []byte
+			if storageKeyPath != "" {
+				if key, err = os.ReadFile(storageKeyPath); err != nil {
+					return fmt.Errorf("unable to read storage key: %w", err)
+				}
+			}
+			if store, err = server.NewFileStorage(storagePath, retention, key); err != nil {
+				return fmt.Errorf("unable to open storage: %w", err)
+			}
+			slog.Info("storing history", "path", storagePath)
+		default:
+			return fmt.Errorf("unknown storage %s", storageKind)
+		}
+		defer store.Close() // nolint
+
+		options := []server.Option{
+			server.OptStorage(store),
+			server.OptLimits(settings.Limits),
+			server.OptRevoked(settings.Revoked...),
+			server.OptPublishRateLimit(rateLimits.Publish),
+			server.OptConnectionRateLimit(rateLimits.Connection),
+			server.OptBlobs(blobDir, blobThreshold),
+			server.OptCompressionThreshold(compressionThreshold),
+			server.OptHealthListen(healthListenAddr),
+			server.OptVersion(version, commit),
+			server.OptWebhooks(webhookDeadLetter, webhooks...),
+		}
+
+		if backplaneURL != "" {
+			backplane, err := server.NewRedisBackplane(backplaneURL, backplaneChannel)
+			if err != nil {
+				return fmt.Errorf("unable to prepare backplane: %w", err)
+			}
+			defer backplane.Close() // nolint
+			options = append(options, server.OptBackplane(backplane))
+			if sharedBlobDir {
+				options = append(options, server.OptSharedBlobs())
+			}
+			slog.Info("sharing items with replicas", "channel", backplaneChannel)
+		}
+
+		if auditLogPath != "" {
+			sink, err := server.NewFileAuditSink(auditLogPath, auditLogMaxSize, auditLogMaxFiles)
+			if err != nil {
+				return fmt.Errorf("unable to prepare audit log: %w", err)
+			}
+			defer sink.Close() // nolint
+			options = append(options, server.OptAudit(sink))
+			slog.Info("recording audit log", "path", auditLogPath)
+		}
+
+		var peers []server.Peer
+		if len(federation.Peers) > 0 {
+
+			fedX509Cert, fedX509Key, err := tglib.ReadCertificatePEM(
+				os.ExpandEnv(federation.Cert),
+				os.ExpandEnv(federation.CertKey),
+				federation.CertKeyPass,
+			)
+			if err != nil {
+				return fmt.Errorf("unable to read federation certificate: %w", err)
+			}
+
+			fedTLSCert, err := tglib.ToTLSCertificate(fedX509Cert, fedX509Key)
+			if err != nil {
+				return fmt.Errorf("unable to convert federation certificate to tls certificate: %w", err)
+			}
+
+			peerCAPool, err := x509.SystemCertPool()
+			if err != nil {
+				return fmt.Errorf("unable to prepare cert pool from system: %w", err)
+			}
+			if federation.ServerCA != "" {
+				peerCAData, err := os.ReadFile(os.ExpandEnv(federation.ServerCA))
+				if err != nil {
+					return fmt.Errorf("unable to read federation server CA: %w", err)
+				}
+				peerCAPool = x509.NewCertPool()
+				peerCAPool.AppendCertsFromPEM(peerCAData)
+			}
+
+			fedTLSConf := &tls.Config{
+				Certificates: []tls.Certificate{fedTLSCert},
+				RootCAs:      peerCAPool,
+			}
+
+			for _, url := range federation.Peers {
+				peers = append(peers, server.Peer{URL: url, TLSConfig: fedTLSConf, Groups: federation.Groups})
+				slog.Info("federating", "peer", url, "groups", federation.Groups)
+			}
+		}
+
+		options = append(options, server.OptFederation(federation.ID, peers...))
+
+		// The limits and the revoked certificates are
+		// read again from the config file on SIGHUP.
+		hupCh := make(chan os.Signal, 1)
+		signal.Notify(hupCh, syscall.SIGHUP)
+		defer signal.Stop(hupCh)
+
+		reloadCh := make(chan server.Settings)
+		go func() {
+			for {
+				select {
+				case <-hupCh:
+					if err := viper.ReadInConfig(); err != nil && !errors.As(err, &viper.ConfigFileNotFoundError{}) {
+						slog.Error("unable to read config", "error", err)
+						continue
+					}
+					settings, err := readServerSettings()
+					if err != nil {
+						slog.Error("unable to reload settings", "error", err)
+						continue
+					}
+					select {
+					case reloadCh <- settings:
+					case <-cmd.Context().Done():
+						return
+					}
+				case <-cmd.Context().Done():
+					return
+				}
+			}
+		}()
+
+		options = append(options, server.OptReload(reloadCh))
+
+		return server.Serve(cmd.Context(), listenAddr, tlsConf, options...)
 	},
 }
 
+// readServerSettings reads the settings of
+// the server that can be reloaded while it runs.
+func readServerSettings() (server.Settings, error) {
+
+	var mimeLimits []protocol.MIMELimit
+	if err := viper.UnmarshalKey("server.mime-limits", &mimeLimits); err != nil {
+		return server.Settings{}, fmt.Errorf("unable to read mime limits: %w", err)
+	}
+
+	return server.Settings{
+		Limits: protocol.Limits{
+			MaxSize: viper.GetInt64("server.max-publish-size"),
+			MIME:    mimeLimits,
+		},
+		Revoked: viper.GetStringSlice("server.revoked"),
+	}, nil
+}
+
 func init() {
 	serverCmd.Flags().StringP("listen", "l", ":8989", "The listen address of the server")
 	_ = viper.BindPFlag("server.listen", serverCmd.Flags().Lookup("listen"))
@@ -77,4 +286,58 @@ func init() {
 
 	serverCmd.Flags().StringP("client-ca", "C", "", "path to the client certificate CA")
 	_ = viper.BindPFlag("server.client-ca", serverCmd.Flags().Lookup("client-ca"))
+
+	serverCmd.Flags().StringSlice("revoked", nil, "fingerprints or common names of the revoked client certificates")
+	_ = viper.BindPFlag("server.revoked", serverCmd.Flags().Lookup("revoked"))
+
+	serverCmd.Flags().String("storage", "memory", "storage of the history. memory or file")
+	_ = viper.BindPFlag("server.storage", serverCmd.Flags().Lookup("storage"))
+
+	serverCmd.Flags().String("storage-path", "/var/lib/netboard/history.log", "path to the history file when using file storage")
+	_ = viper.BindPFlag("server.storage-path", serverCmd.Flags().Lookup("storage-path"))
+
+	serverCmd.Flags().String("storage-key", "", "optional path to a key used to encrypt the history file")
+	_ = viper.BindPFlag("server.storage-key", serverCmd.Flags().Lookup("storage-key"))
+
+	serverCmd.Flags().Int("history-size", 50, "maximum number of items kept in the history. 0 means no limit")
+	_ = viper.BindPFlag("server.history-size", serverCmd.Flags().Lookup("history-size"))
+
+	serverCmd.Flags().Duration("history-max-age", 0, "maximum age of the items kept in the history. 0 means no limit")
+	_ = viper.BindPFlag("server.history-max-age", serverCmd.Flags().Lookup("history-max-age"))
+
+	serverCmd.Flags().Int64("max-publish-size", 10<<20, "maximum size of a published item in bytes. 0 means no limit")
+	_ = viper.BindPFlag("server.max-publish-size", serverCmd.Flags().Lookup("max-publish-size"))
+
+	serverCmd.Flags().String("blob-dir", "", "optional path to a directory storing large items as blobs. empty disables blobs")
+	_ = viper.BindPFlag("server.blob-dir", serverCmd.Flags().Lookup("blob-dir"))
+
+	serverCmd.Flags().Int64("blob-threshold", 1<<20, "size in bytes above which clients upload items as blobs")
+	_ = viper.BindPFlag("server.blob-threshold", serverCmd.Flags().Lookup("blob-threshold"))
+
+	serverCmd.Flags().Bool("shared-blob-dir", false, "tells the blob directory is shared by all the replicas, which is required to use blobs with a backplane")
+	_ = viper.BindPFlag("server.shared-blob-dir", serverCmd.Flags().Lookup("shared-blob-dir"))
+
+	serverCmd.Flags().Int("compression-threshold", 1024, "size in bytes below which the items sent to subscribers are not compressed")
+	_ = viper.BindPFlag("server.compression-threshold", serverCmd.Flags().Lookup("compression-threshold"))
+
+	serverCmd.Flags().String("backplane", "", "optional url of a redis server used to share items between replicas, like redis://host:6379")
+	_ = viper.BindPFlag("server.backplane", serverCmd.Flags().Lookup("backplane"))
+
+	serverCmd.Flags().String("backplane-channel", "netboard", "redis channel shared by the replicas")
+	_ = viper.BindPFlag("server.backplane-channel", serverCmd.Flags().Lookup("backplane-channel"))
+
+	serverCmd.Flags().String("health-listen", "", "optional address serving the health and version endpoints without client certificate, like :8990")
+	_ = viper.BindPFlag("server.health-listen", serverCmd.Flags().Lookup("health-listen"))
+
+	serverCmd.Flags().String("audit-log", "", "optional path to a file recording the activity of the clients as json lines")
+	_ = viper.BindPFlag("server.audit-log", serverCmd.Flags().Lookup("audit-log"))
+
+	serverCmd.Flags().Int64("audit-log-max-size", 100<<20, "size in bytes above which the audit log is rotated. 0 means never")
+	_ = viper.BindPFlag("server.audit-log-max-size", serverCmd.Flags().Lookup("audit-log-max-size"))
+
+	serverCmd.Flags().Int("audit-log-max-files", 10, "number of rotated audit logs kept")
+	_ = viper.BindPFlag("server.audit-log-max-files", serverCmd.Flags().Lookup("audit-log-max-files"))
+
+	serverCmd.Flags().String("webhook-dead-letter", "/var/lib/netboard/webhooks-dead-letter.log", "path to the file recording the webhook deliveries that failed. empty only logs them")
+	_ = viper.BindPFlag("server.webhook-dead-letter", serverCmd.Flags().Lookup("webhook-dead-letter"))
 }
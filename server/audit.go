@@ -0,0 +1,278 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/primalmotion/netboard/protocol"
+)
+
+// Kinds of audit events.
+const (
+	// AuditPublish is recorded when a device publishes an item.
+	AuditPublish = "publish"
+
+	// AuditSubscribe is recorded when a device subscribes.
+	AuditSubscribe = "subscribe"
+
+	// AuditDisconnect is recorded when a subscriber disconnects.
+	AuditDisconnect = "disconnect"
+
+	// AuditReject is recorded when a client certificate is rejected.
+	AuditReject = "reject"
+
+	// AuditAdmin is recorded for the administrative actions,
+	// like starting and stopping the server.
+	AuditAdmin = "admin"
+)
+
+// An AuditEvent records an activity of the server. It never
+// holds the content of the items, only their hash, size and type.
+type AuditEvent struct {
+	Time      time.Time `json:"time"`
+	Event     string    `json:"event"`
+	Device    string    `json:"device,omitempty"`
+	CN        string    `json:"cn,omitempty"`
+	Remote    string    `json:"remote,omitempty"`
+	Transport string    `json:"transport,omitempty"`
+	Hash      string    `json:"hash,omitempty"`
+	Size      int64     `json:"size,omitempty"`
+	MIME      string    `json:"mime,omitempty"`
+	Action    string    `json:"action,omitempty"`
+	Reason    string    `json:"reason,omitempty"`
+}
+
+// An AuditSink records the audit events.
+type AuditSink interface {
+
+	// Record records the given event.
+	Record(AuditEvent) error
+
+	// Close releases the resources of the sink.
+	Close() error
+}
+
+type fileAuditSink struct {
+	path     string
+	maxSize  int64
+	maxFiles int
+	file     *os.File
+	size     int64
+
+	sync.Mutex
+}
+
+// NewFileAuditSink returns an AuditSink writing the events as JSON
+// Lines to the file at the given path. When the file grows bigger
+// than maxSize bytes, it is rotated to path.1, path.1 to path.2 and
+// so on, and only maxFiles rotated files are kept. If maxSize is 0,
+// the file is never rotated.
+func NewFileAuditSink(path string, maxSize int64, maxFiles int) (AuditSink, error) {
+
+	s := &fileAuditSink{
+		path:     path,
+		maxSize:  maxSize,
+		maxFiles: maxFiles,
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, fmt.Errorf("unable to create audit log directory: %w", err)
+	}
+
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+func (s *fileAuditSink) Record(evt AuditEvent) error {
+
+	data, err := json.Marshal(evt)
+	if err != nil {
+		return fmt.Errorf("unable to encode audit event: %w", err)
+	}
+	data = append(data, '\n')
+
+	s.Lock()
+	defer s.Unlock()
+
+	if s.maxSize > 0 && s.size > 0 && s.size+int64(len(data)) > s.maxSize {
+		if err := s.rotate(); err != nil {
+			return err
+		}
+	}
+
+	n, err := s.file.Write(data)
+	s.size += int64(n)
+	if err != nil {
+		return fmt.Errorf("unable to write audit event: %w", err)
+	}
+
+	return nil
+}
+
+func (s *fileAuditSink) Close() error {
+
+	s.Lock()
+	defer s.Unlock()
+
+	return s.file.Close()
+}
+
+func (s *fileAuditSink) open() error {
+
+	f, err := os.OpenFile(s.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("unable to open audit log: %w", err)
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close() // nolint
+		return fmt.Errorf("unable to stat audit log: %w", err)
+	}
+
+	s.file = f
+	s.size = info.Size()
+
+	return nil
+}
+
+// rotate shifts the rotated files, drops the
+// oldest one and starts a new file.
+func (s *fileAuditSink) rotate() error {
+
+	if err := s.file.Close(); err != nil {
+		return fmt.Errorf("unable to close audit log: %w", err)
+	}
+
+	if s.maxFiles > 0 {
+		for i := s.maxFiles - 1; i > 0; i-- {
+			_ = os.Rename(fmt.Sprintf("%s.%d", s.path, i), fmt.Sprintf("%s.%d", s.path, i+1))
+		}
+		if err := os.Rename(s.path, s.path+".1"); err != nil {
+			return fmt.Errorf("unable to rotate audit log: %w", err)
+		}
+	} else if err := os.Remove(s.path); err != nil {
+		return fmt.Errorf("unable to rotate audit log: %w", err)
+	}
+
+	return s.open()
+}
+
+// audit records the given event in the sink, if any, stamping
+// it with the current time. Failures are only logged, as they
+// must not prevent the clients from being served.
+func audit(sink AuditSink, evt AuditEvent) {
+
+	if sink == nil {
+		return
+	}
+
+	evt.Time = time.Now()
+
+	if err := sink.Record(evt); err != nil {
+		slog.Error("unable to record audit event", "event", evt.Event, "error", err)
+	}
+}
+
+// newAuditEvent returns an event of the given
+// kind for the client of the given request.
+func newAuditEvent(kind string, r *http.Request) AuditEvent {
+
+	cert := r.TLS.PeerCertificates[0]
+
+	return AuditEvent{
+		Event:  kind,
+		Device: protocol.Fingerprint(cert.Raw),
+		CN:     cert.Subject.CommonName,
+		Remote: r.RemoteAddr,
+	}
+}
+
+// auditedTLSConfig returns a copy of the given config verifying the
+// client certificates itself, so the rejected ones are recorded in
+// the sink. The standard verification aborts the handshake before
+// giving any chance to know about them. The certificates are only
+// requested, and the accepted CAs are not advertised, so the clients
+// without any certificate or with one from another CA are recorded
+// too, rather than rejected before the verification.
+func auditedTLSConfig(tlsConf *tls.Config, sink AuditSink) *tls.Config {
+
+	if tlsConf.ClientAuth != tls.RequireAndVerifyClientCert {
+		return tlsConf
+	}
+
+	conf := tlsConf.Clone()
+	conf.ClientAuth = tls.RequestClientCert
+	conf.ClientCAs = nil
+
+	conf.GetConfigForClient = func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+
+		remote := hello.Conn.RemoteAddr().String()
+
+		c := conf.Clone()
+		c.GetConfigForClient = nil
+		c.VerifyConnection = func(cs tls.ConnectionState) error {
+
+			err := verifyClientCertificate(cs.PeerCertificates, tlsConf.ClientCAs)
+			if err == nil && tlsConf.VerifyConnection != nil {
+				err = tlsConf.VerifyConnection(cs)
+			}
+			if err == nil {
+				return nil
+			}
+
+			evt := AuditEvent{
+				Event:  AuditReject,
+				Remote: remote,
+				Reason: err.Error(),
+			}
+			if len(cs.PeerCertificates) > 0 {
+				evt.Device = protocol.Fingerprint(cs.PeerCertificates[0].Raw)
+				evt.CN = cs.PeerCertificates[0].Subject.CommonName
+			}
+			audit(sink, evt)
+
+			return err
+		}
+
+		return c, nil
+	}
+
+	return conf
+}
+
+// verifyClientCertificate verifies the given client certificate
+// chain like tls.RequireAndVerifyClientCert does.
+func verifyClientCertificate(certs []*x509.Certificate, roots *x509.CertPool) error {
+
+	if len(certs) == 0 {
+		return fmt.Errorf("no client certificate")
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+
+	_, err := certs[0].Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+	if err != nil {
+		return fmt.Errorf("invalid client certificate: %w", err)
+	}
+
+	return nil
+}
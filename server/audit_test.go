@@ -0,0 +1,315 @@
+package server
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/primalmotion/netboard/protocol"
+)
+
+// memoryAuditSink is an AuditSink keeping the events in memory.
+type memoryAuditSink struct {
+	events []AuditEvent
+
+	sync.Mutex
+}
+
+func (s *memoryAuditSink) Record(evt AuditEvent) error {
+	s.Lock()
+	defer s.Unlock()
+
+	s.events = append(s.events, evt)
+	return nil
+}
+
+func (s *memoryAuditSink) Close() error {
+	return nil
+}
+
+func (s *memoryAuditSink) Events() []AuditEvent {
+	s.Lock()
+	defer s.Unlock()
+
+	return append([]AuditEvent{}, s.events...)
+}
+
+// testCA is a certificate authority issuing test certificates.
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func newTestCA(t *testing.T, cn string) testCA {
+
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("unable to generate key: %s", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: cn},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("unable to create certificate: %s", err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("unable to parse certificate: %s", err)
+	}
+
+	return testCA{cert: cert, key: key}
+}
+
+// issue returns a certificate with the given common name and
+// usage, valid until notAfter, signed by the CA.
+func (ca testCA) issue(t *testing.T, cn string, notAfter time.Time, usage x509.ExtKeyUsage) tls.Certificate {
+
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("unable to generate key: %s", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    notAfter.Add(-2 * time.Hour),
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatalf("unable to create certificate: %s", err)
+	}
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+// handshake connects to a TLS server using the given config
+// with the given client certificates, and returns the error
+// of the handshake on the server side.
+func handshake(t *testing.T, serverConf *tls.Config, certs []tls.Certificate) error {
+
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unable to listen: %s", err)
+	}
+	defer l.Close() // nolint
+
+	errCh := make(chan error, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			errCh <- err
+			return
+		}
+		defer conn.Close() // nolint
+		errCh <- tls.Server(conn, serverConf).Handshake()
+	}()
+
+	conn, err := tls.Dial("tcp", l.Addr().String(), &tls.Config{
+		Certificates:       certs,
+		InsecureSkipVerify: true, // nolint
+	})
+	if err == nil {
+		// The server verifies the client certificate
+		// after the client is done with the handshake.
+		_, _ = conn.Read(make([]byte, 1))
+		conn.Close() // nolint
+	}
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-time.After(5 * time.Second):
+		t.Fatalf("handshake did not complete")
+		return nil
+	}
+}
+
+func TestAuditedTLSConfig(t *testing.T) {
+
+	ca := newTestCA(t, "netboard ca")
+	rogueCA := newTestCA(t, "rogue ca")
+
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(ca.cert)
+
+	later := time.Now().Add(time.Hour)
+
+	revoked := ca.issue(t, "revoked", later, x509.ExtKeyUsageClientAuth)
+	revokedFingerprint := protocol.Fingerprint(revoked.Certificate[0])
+
+	tests := []struct {
+		name       string
+		certs      []tls.Certificate
+		wantReason string
+		wantCN     string
+	}{
+		{"trusted", []tls.Certificate{ca.issue(t, "laptop", later, x509.ExtKeyUsageClientAuth)}, "", ""},
+		{"untrusted", []tls.Certificate{rogueCA.issue(t, "rogue", later, x509.ExtKeyUsageClientAuth)}, "invalid client certificate", "rogue"},
+		{"expired", []tls.Certificate{ca.issue(t, "old", time.Now().Add(-time.Hour), x509.ExtKeyUsageClientAuth)}, "invalid client certificate", "old"},
+		{"not for clients", []tls.Certificate{ca.issue(t, "server", later, x509.ExtKeyUsageServerAuth)}, "invalid client certificate", "server"},
+		{"missing", nil, "no client certificate", ""},
+		{"revoked by common name", []tls.Certificate{ca.issue(t, "stolen", later, x509.ExtKeyUsageClientAuth)}, "revoked client certificate", "stolen"},
+		{"revoked by fingerprint", []tls.Certificate{revoked}, "revoked client certificate", "revoked"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			sink := &memoryAuditSink{}
+			live := newSettings(protocol.Limits{}, []string{"stolen", revokedFingerprint})
+
+			conf := auditedTLSConfig(revocableTLSConfig(&tls.Config{
+				Certificates: []tls.Certificate{ca.issue(t, "127.0.0.1", later, x509.ExtKeyUsageServerAuth)},
+				ClientAuth:   tls.RequireAndVerifyClientCert,
+				ClientCAs:    clientCAs,
+			}, live), sink)
+
+			err := handshake(t, conf, tt.certs)
+			events := sink.Events()
+
+			if tt.wantReason == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %s", err)
+				}
+				if len(events) != 0 {
+					t.Fatalf("got %d events, want none", len(events))
+				}
+				return
+			}
+
+			if err == nil || !strings.Contains(err.Error(), tt.wantReason) {
+				t.Fatalf("got error %v, want %q", err, tt.wantReason)
+			}
+
+			if len(events) != 1 {
+				t.Fatalf("got %d events, want 1", len(events))
+			}
+
+			evt := events[0]
+			if evt.Event != AuditReject || !strings.Contains(evt.Reason, tt.wantReason) || evt.CN != tt.wantCN || evt.Remote == "" {
+				t.Fatalf("unexpected event: %+v", evt)
+			}
+			if len(tt.certs) > 0 && evt.Device != protocol.Fingerprint(tt.certs[0].Certificate[0]) {
+				t.Fatalf("event has device %s", evt.Device)
+			}
+		})
+	}
+}
+
+func TestFileAuditSinkRotation(t *testing.T) {
+
+	tests := []struct {
+		name      string
+		maxFiles  int
+		wantFiles []string
+	}{
+		{"keeps rotated files", 2, []string{"audit.log", "audit.log.1", "audit.log.2"}},
+		{"keeps no rotated file", 0, []string{"audit.log"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			dir := t.TempDir()
+			path := filepath.Join(dir, "audit.log")
+
+			// Each event is about 60 bytes, so
+			// every file holds a single one.
+			sink, err := NewFileAuditSink(path, 80, tt.maxFiles)
+			if err != nil {
+				t.Fatalf("unable to create sink: %s", err)
+			}
+
+			for i := 0; i < 5; i++ {
+				if err := sink.Record(AuditEvent{Event: AuditPublish, Device: fmt.Sprintf("device-%d", i)}); err != nil {
+					t.Fatalf("unable to record event: %s", err)
+				}
+			}
+
+			if err := sink.Close(); err != nil {
+				t.Fatalf("unable to close sink: %s", err)
+			}
+
+			entries, err := os.ReadDir(dir)
+			if err != nil {
+				t.Fatalf("unable to read dir: %s", err)
+			}
+
+			var files []string
+			for _, e := range entries {
+				files = append(files, e.Name())
+			}
+			if strings.Join(files, ",") != strings.Join(tt.wantFiles, ",") {
+				t.Fatalf("got files %v, want %v", files, tt.wantFiles)
+			}
+
+			// The current file holds the latest
+			// event, and the rotated ones the
+			// events before it.
+			for i, name := range tt.wantFiles {
+				if got, want := readAuditDevices(t, filepath.Join(dir, name)), fmt.Sprintf("device-%d", 4-i); len(got) != 1 || got[0] != want {
+					t.Fatalf("%s holds %v, want %s", name, got, want)
+				}
+			}
+		})
+	}
+}
+
+func readAuditDevices(t *testing.T, path string) []string {
+
+	t.Helper()
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("unable to open audit log: %s", err)
+	}
+	defer f.Close() // nolint
+
+	var devices []string
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		evt := AuditEvent{}
+		if err := json.Unmarshal(scanner.Bytes(), &evt); err != nil {
+			t.Fatalf("unable to decode audit event: %s", err)
+		}
+		devices = append(devices, evt.Device)
+	}
+
+	return devices
+}
@@ -0,0 +1,191 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/primalmotion/netboard/protocol"
+)
+
+const (
+	// backplaneTimeout is the maximum time
+	// to publish a message on the backplane.
+	backplaneTimeout = 5 * time.Second
+
+	// backplaneQueueSize is the number of messages waiting
+	// to be published on the backplane, above which the new
+	// ones are dropped.
+	backplaneQueueSize = 64
+)
+
+// errBackplaneQueueFull is returned when a message cannot
+// be queued because the backplane is too slow.
+var errBackplaneQueueFull = errors.New("backplane queue is full")
+
+// A Backplane carries the published items between the replicas
+// of a server, so every replica can send them to its own clients.
+type Backplane interface {
+
+	// Publish sends the given message to all the
+	// replicas, including the one publishing it.
+	Publish(ctx context.Context, msg []byte) error
+
+	// Subscribe returns a channel receiving the messages
+	// published by all the replicas, until the context is
+	// canceled. Implementations must reconnect on their own.
+	Subscribe(ctx context.Context) (<-chan []byte, error)
+
+	// Close releases the resources used by the backplane.
+	Close() error
+}
+
+// backplaneMessage is the message sent through the backplane.
+type backplaneMessage struct {
+
+	// Replica is the ID of the replica that received the item.
+	Replica string `json:"replica"`
+
+	// Source is the ID of the device that published the item,
+	// which must not receive it back.
+	Source string `json:"source,omitempty"`
+
+	Item *protocol.Item `json:"item"`
+}
+
+func newReplicaID() (string, error) {
+
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("unable to generate replica id: %w", err)
+	}
+
+	return hex.EncodeToString(b), nil
+}
+
+// publish queues the given item to be sent through the backplane
+// by sendBackplane. It returns errBackplaneQueueFull if too many
+// items are already waiting.
+func (d *dispatcher) publish(srcID string, item *protocol.Item) error {
+
+	msg, err := json.Marshal(backplaneMessage{
+		Replica: d.replicaID,
+		Source:  srcID,
+		Item:    item,
+	})
+	if err != nil {
+		return fmt.Errorf("unable to encode backplane message: %w", err)
+	}
+
+	select {
+	case d.outgoing <- msg:
+		return nil
+	default:
+		return errBackplaneQueueFull
+	}
+}
+
+// sendBackplane publishes the messages queued by the dispatcher
+// on the backplane until the context is canceled. They are sent
+// apart from the publications, so a slow or unreachable backplane
+// does not delay them.
+func sendBackplane(ctx context.Context, dispatch *dispatcher) {
+
+	for {
+		select {
+
+		case msg := <-dispatch.outgoing:
+			pctx, cancel := context.WithTimeout(ctx, backplaneTimeout)
+			if err := dispatch.backplane.Publish(pctx, msg); err != nil {
+				slog.Error("unable to publish item to the backplane", "error", err)
+			}
+			cancel()
+
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// listenBackplane sends the items received by the other replicas
+// through the backplane to the local clients, until the context
+// is canceled. They are also stored locally, and their expiration
+// scheduled, so all the replicas share the same history. The
+// items published by this replica were already sent to its
+// clients, so their echo is ignored. The blobs of the items are
+// expected in the given blob store, shared by all the replicas.
+func listenBackplane(ctx context.Context, dispatch *dispatcher, blobs *blobStore) error {
+
+	ch, err := dispatch.backplane.Subscribe(ctx)
+	if err != nil {
+		return fmt.Errorf("unable to subscribe to backplane: %w", err)
+	}
+
+	go func() {
+		for data := range ch {
+
+			msg := backplaneMessage{}
+			if err := json.Unmarshal(data, &msg); err != nil || msg.Item == nil {
+				slog.Error("unable to decode backplane message", "error", err)
+				continue
+			}
+
+			if msg.Replica == dispatch.replicaID {
+				continue
+			}
+
+			item := msg.Item
+
+			// Several replicas may receive the same item,
+			// for instance when they federate the same peer.
+			last, err := latest(dispatch.store)
+			if err != nil {
+				slog.Error("unable to retrieve latest item", "error", err)
+				continue
+			}
+			if last != nil && last.ID == item.ID && last.Time.Equal(item.Time) {
+				continue
+			}
+
+			if item.Blob && !hasBlob(blobs, item.ID) {
+				slog.Error("blob of item from the backplane not found in the shared blob directory", "item", item.ID)
+				continue
+			}
+
+			ok, err := dispatch.Update(item, true)
+			if err != nil {
+				slog.Error("unable to store item from the backplane", "item", item.ID, "error", err)
+				continue
+			}
+			if !ok {
+				continue
+			}
+
+			if item.Expires != nil {
+				scheduleExpiration(dispatch, item)
+			}
+
+			dispatch.fanout(msg.Source, item)
+		}
+	}()
+
+	return nil
+}
+
+// hasBlob returns true if the given blob store holds
+// the blob with the given id.
+func hasBlob(blobs *blobStore, id string) bool {
+
+	if blobs == nil {
+		return false
+	}
+
+	_, ok := blobs.Stat(id)
+
+	return ok
+}
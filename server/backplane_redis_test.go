@@ -0,0 +1,377 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeRedis is a server speaking enough of the redis
+// protocol to carry the messages of the backplane.
+type fakeRedis struct {
+	listener net.Listener
+	username string
+	password string
+
+	commands    [][]string
+	subscribers map[string][]net.Conn
+
+	sync.Mutex
+}
+
+func newFakeRedis(t *testing.T, password string) *fakeRedis {
+
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unable to listen: %s", err)
+	}
+
+	r := &fakeRedis{
+		listener:    l,
+		username:    "default",
+		password:    password,
+		subscribers: map[string][]net.Conn{},
+	}
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go r.serve(conn)
+		}
+	}()
+
+	t.Cleanup(func() { l.Close() }) // nolint
+
+	return r
+}
+
+func (r *fakeRedis) url(password string, db int) string {
+	return fmt.Sprintf("redis://:%s@%s/%d", password, r.listener.Addr(), db)
+}
+
+func (r *fakeRedis) serve(conn net.Conn) {
+
+	defer conn.Close() // nolint
+
+	reader := bufio.NewReader(conn)
+	authenticated := r.password == ""
+
+	for {
+
+		// The commands are arrays of bulk strings.
+		reply, err := readRedisReply(reader)
+		if err != nil {
+			return
+		}
+
+		var cmd []string
+		for _, arg := range reply.([]any) {
+			cmd = append(cmd, string(arg.([]byte)))
+		}
+
+		r.Lock()
+		r.commands = append(r.commands, cmd)
+		r.Unlock()
+
+		var out string
+
+		switch name := strings.ToUpper(cmd[0]); {
+
+		case name == "AUTH":
+			// The user is "default" unless given.
+			user, pass := "default", cmd[len(cmd)-1]
+			if len(cmd) == 3 {
+				user = cmd[1]
+			}
+			if user != r.username || pass != r.password {
+				out = "-WRONGPASS invalid username-password pair\r\n"
+				break
+			}
+			authenticated = true
+			out = "+OK\r\n"
+
+		case !authenticated:
+			out = "-NOAUTH Authentication required\r\n"
+
+		case name == "SELECT":
+			out = "+OK\r\n"
+
+		case name == "SUBSCRIBE":
+			r.Lock()
+			r.subscribers[cmd[1]] = append(r.subscribers[cmd[1]], conn)
+			r.Unlock()
+			out = fmt.Sprintf("*3\r\n$9\r\nsubscribe\r\n$%d\r\n%s\r\n:1\r\n", len(cmd[1]), cmd[1])
+
+		case name == "PUBLISH":
+			r.Lock()
+			subscribers := r.subscribers[cmd[1]]
+			for _, s := range subscribers {
+				fmt.Fprintf(s, "*3\r\n$7\r\nmessage\r\n$%d\r\n%s\r\n$%d\r\n%s\r\n", len(cmd[1]), cmd[1], len(cmd[2]), cmd[2])
+			}
+			r.Unlock()
+			out = fmt.Sprintf(":%d\r\n", len(subscribers))
+
+		default:
+			out = fmt.Sprintf("-ERR unknown command '%s'\r\n", cmd[0])
+		}
+
+		if _, err := conn.Write([]byte(out)); err != nil {
+			return
+		}
+	}
+}
+
+// subscribed waits until the given channel has a subscriber.
+func (r *fakeRedis) subscribed(t *testing.T, channel string) {
+
+	t.Helper()
+
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		r.Lock()
+		n := len(r.subscribers[channel])
+		r.Unlock()
+		if n > 0 {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	t.Fatalf("nobody subscribed to %s", channel)
+}
+
+func TestReadRedisReply(t *testing.T) {
+
+	tests := []struct {
+		name    string
+		input   string
+		want    any
+		wantErr string
+	}{
+		{"simple string", "+OK\r\n", []byte("OK"), ""},
+		{"error", "-ERR wrong\r\n", nil, "ERR wrong"},
+		{"integer", ":42\r\n", int64(42), ""},
+		{"bulk string", "$5\r\nhello\r\n", []byte("hello"), ""},
+		{"bulk string with line break", "$7\r\nhel\r\nlo\r\n", []byte("hel\r\nlo"), ""},
+		{"empty bulk string", "$0\r\n\r\n", []byte{}, ""},
+		{"null bulk string", "$-1\r\n", nil, ""},
+		{"array", "*2\r\n$3\r\nfoo\r\n:1\r\n", []any{[]byte("foo"), int64(1)}, ""},
+		{"nested array", "*1\r\n*1\r\n+OK\r\n", []any{[]any{[]byte("OK")}}, ""},
+		{"null array", "*-1\r\n", nil, ""},
+		{"missing carriage return", "+OK\n", nil, "invalid backplane reply"},
+		{"truncated bulk string", "$5\r\nhel", nil, "unable to read backplane reply"},
+		{"invalid length", "$x\r\n", nil, "invalid backplane reply length"},
+		{"unsupported type", "%1\r\n", nil, "unsupported backplane reply type"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			got, err := readRedisReply(bufio.NewReader(strings.NewReader(tt.input)))
+
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("got %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewRedisBackplane(t *testing.T) {
+
+	r := newFakeRedis(t, "secret")
+
+	tests := []struct {
+		name    string
+		url     string
+		wantErr string
+	}{
+		{"authenticated", r.url("secret", 0), ""},
+		{"wrong password", r.url("wrong", 0), "unable to authenticate to backplane"},
+		{"unsupported scheme", "http://" + r.listener.Addr().String(), "unsupported backplane scheme"},
+		{"invalid database", "redis://" + r.listener.Addr().String() + "/db", "invalid backplane database"},
+		{"unreachable", "redis://127.0.0.1:1", "unable to connect to backplane"},
+		{"default user", "redis://default:secret@" + r.listener.Addr().String(), ""},
+		{"unknown user", "redis://netboard:secret@" + r.listener.Addr().String(), "unable to authenticate to backplane"},
+		{"user without password", "redis://netboard@" + r.listener.Addr().String(), "backplane user netboard has no password"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			b, err := NewRedisBackplane(tt.url, "netboard")
+
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			b.Close() // nolint
+		})
+	}
+}
+
+func TestRedisBackplane(t *testing.T) {
+
+	r := newFakeRedis(t, "secret")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	b, err := NewRedisBackplane(r.url("secret", 3), "netboard")
+	if err != nil {
+		t.Fatalf("unable to create backplane: %s", err)
+	}
+	defer b.Close() // nolint
+
+	ch, err := b.Subscribe(ctx)
+	if err != nil {
+		t.Fatalf("unable to subscribe: %s", err)
+	}
+	r.subscribed(t, "netboard")
+
+	messages := [][]byte{
+		[]byte(`{"replica":"a"}`),
+		[]byte("with\r\nline breaks"),
+		make([]byte, 64<<10),
+	}
+
+	for _, msg := range messages {
+		if err := b.Publish(ctx, msg); err != nil {
+			t.Fatalf("unable to publish: %s", err)
+		}
+	}
+
+	for i, want := range messages {
+		select {
+		case got := <-ch:
+			if string(got) != string(want) {
+				t.Fatalf("message %d is %q, want %q", i, got, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("message %d not received", i)
+		}
+	}
+
+	r.Lock()
+	var names []string
+	for _, cmd := range r.commands {
+		names = append(names, cmd[0])
+	}
+	r.Unlock()
+
+	// Both the publishing and the subscribing connections
+	// authenticate and select the database first.
+	want := []string{"AUTH", "SELECT", "AUTH", "SELECT", "SUBSCRIBE", "PUBLISH", "PUBLISH", "PUBLISH"}
+	if !reflect.DeepEqual(names, want) {
+		t.Fatalf("got commands %v, want %v", names, want)
+	}
+
+	cancel()
+
+	select {
+	case _, ok := <-ch:
+		if ok {
+			t.Fatalf("received a message after the context was canceled")
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("subscription not closed after the context was canceled")
+	}
+}
+
+func TestRedisBackplanePublishTimeout(t *testing.T) {
+
+	// The server accepts the connections, but never answers.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unable to listen: %s", err)
+	}
+	defer l.Close() // nolint
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close() // nolint
+		}
+	}()
+
+	// The backplane is disconnected, so publishing connects again.
+	b := &redisBackplane{addr: l.Addr().String(), password: "secret", channel: "netboard"}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- b.Publish(ctx, []byte("message"))
+	}()
+
+	// The lock is not held while connecting.
+	closed := make(chan struct{})
+	go func() {
+		b.Close() // nolint
+		close(closed)
+	}()
+
+	select {
+	case <-closed:
+	case <-time.After(50 * time.Millisecond):
+		t.Fatalf("close blocked by the connection")
+	}
+
+	select {
+	case err := <-errCh:
+		if err == nil || !strings.Contains(err.Error(), "unable to authenticate to backplane") {
+			t.Fatalf("got error %v, want authentication timeout", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("publish not bounded by the context")
+	}
+}
+
+func TestRedisBackplaneUser(t *testing.T) {
+
+	r := newFakeRedis(t, "secret")
+	r.username = "netboard"
+
+	b, err := NewRedisBackplane("redis://netboard:secret@"+r.listener.Addr().String(), "netboard")
+	if err != nil {
+		t.Fatalf("unable to create backplane: %s", err)
+	}
+	defer b.Close() // nolint
+
+	r.Lock()
+	defer r.Unlock()
+
+	if want := []string{"AUTH", "netboard", "secret"}; len(r.commands) != 1 || !reflect.DeepEqual(r.commands[0], want) {
+		t.Fatalf("got commands %v, want %v", r.commands, want)
+	}
+}
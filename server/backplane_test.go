@@ -0,0 +1,232 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/primalmotion/netboard/protocol"
+)
+
+// memoryBackplane is a Backplane sending the
+// published messages to its only subscriber.
+type memoryBackplane struct {
+	ch chan []byte
+}
+
+func (b *memoryBackplane) Publish(ctx context.Context, msg []byte) error {
+	b.ch <- msg
+	return nil
+}
+
+func (b *memoryBackplane) Subscribe(ctx context.Context) (<-chan []byte, error) {
+	return b.ch, nil
+}
+
+func (b *memoryBackplane) Close() error {
+	return nil
+}
+
+func TestDispatchBackplane(t *testing.T) {
+
+	tests := []struct {
+		name    string
+		replica string
+		source  string
+		want    []string
+	}{
+		{"published by this replica", "self", "", []string{"a", "b"}},
+		{"published by a client of this replica", "self", "a", []string{"b"}},
+		{"published by another replica", "other", "", []string{"a", "b"}},
+		{"published by a client of another replica", "other", "a", []string{"b"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			backplane := &memoryBackplane{ch: make(chan []byte, 8)}
+
+			d := newDispatcher(NewMemoryStorage(Retention{}), 0)
+			d.replicaID = "self"
+			d.backplane = backplane
+			d.outgoing = make(chan []byte, backplaneQueueSize)
+			go sendBackplane(ctx, d)
+
+			clients := map[string]chan []byte{}
+			for _, id := range []string{"a", "b"} {
+				clients[id] = make(chan []byte, 8)
+				d.clients[id] = &subscriber{ch: clients[id]}
+			}
+
+			if err := listenBackplane(ctx, d, nil); err != nil {
+				t.Fatalf("unable to listen to backplane: %s", err)
+			}
+
+			item := protocol.NewItem([]byte("item"), time.Now())
+
+			if tt.replica == d.replicaID {
+				d.Dispatch(tt.source, item)
+			} else {
+				msg, _ := json.Marshal(backplaneMessage{Replica: tt.replica, Source: tt.source, Item: item})
+				backplane.ch <- msg
+			}
+
+			// The marker is the last item from another replica,
+			// so the item is either received or dropped once it
+			// arrives.
+			marker := protocol.NewItem([]byte("marker"), time.Now().Add(time.Second))
+			msg, _ := json.Marshal(backplaneMessage{Replica: "other", Item: marker})
+			backplane.ch <- msg
+
+			for id, ch := range clients {
+
+				var got []string
+				for done := false; !done; {
+					select {
+					case data := <-ch:
+						received, err := protocol.Decode(data, 0)
+						if err != nil {
+							t.Fatalf("unable to decode item: %s", err)
+						}
+						if received.ID == marker.ID {
+							done = true
+							continue
+						}
+						got = append(got, received.ID)
+					case <-time.After(time.Second):
+						t.Fatalf("client %s did not receive the marker", id)
+					}
+				}
+
+				want := 0
+				for _, w := range tt.want {
+					if w == id {
+						want = 1
+					}
+				}
+				if len(got) != want {
+					t.Fatalf("client %s received the item %d times, want %d", id, len(got), want)
+				}
+			}
+		})
+	}
+}
+
+func TestBackplaneBlobs(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		stored   bool
+		noBlobs  bool
+		wantSent bool
+	}{
+		{"blob in the shared directory", true, false, true},
+		{"blob not in the shared directory", false, false, false},
+		{"blobs disabled", false, true, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			backplane := &memoryBackplane{ch: make(chan []byte, 8)}
+
+			d := newDispatcher(NewMemoryStorage(Retention{}), 0)
+			d.replicaID = "self"
+			d.backplane = backplane
+
+			ch := make(chan []byte, 8)
+			d.clients["a"] = &subscriber{ch: ch}
+
+			content := []byte("large content")
+
+			blobs, err := newBlobStore(t.TempDir())
+			if err != nil {
+				t.Fatalf("unable to create blob store: %s", err)
+			}
+			if tt.stored {
+				if _, err := blobs.Write(protocol.ComputeID(content), bytes.NewReader(content)); err != nil {
+					t.Fatalf("unable to write blob: %s", err)
+				}
+			}
+			if tt.noBlobs {
+				blobs = nil
+			}
+
+			if err := listenBackplane(ctx, d, blobs); err != nil {
+				t.Fatalf("unable to listen to backplane: %s", err)
+			}
+
+			item := protocol.NewItem(content, time.Now())
+			item.Blob = true
+			item.Size = int64(len(content))
+			item.Data = nil
+
+			marker := protocol.NewItem([]byte("marker"), time.Now().Add(time.Second))
+
+			for _, i := range []*protocol.Item{item, marker} {
+				msg, _ := json.Marshal(backplaneMessage{Replica: "other", Item: i})
+				backplane.ch <- msg
+			}
+
+			var sent bool
+			for done := false; !done; {
+				select {
+				case data := <-ch:
+					received, err := protocol.Decode(data, 0)
+					if err != nil {
+						t.Fatalf("unable to decode item: %s", err)
+					}
+					done = received.ID == marker.ID
+					sent = sent || received.ID == item.ID
+				case <-time.After(time.Second):
+					t.Fatalf("client did not receive the marker")
+				}
+			}
+
+			if sent != tt.wantSent {
+				t.Fatalf("item sent: %t, want %t", sent, tt.wantSent)
+			}
+		})
+	}
+}
+
+func TestBackplaneQueue(t *testing.T) {
+
+	// Nothing reads the backplane.
+	d := newDispatcher(NewMemoryStorage(Retention{}), 0)
+	d.replicaID = "self"
+	d.backplane = &memoryBackplane{}
+	d.outgoing = make(chan []byte, backplaneQueueSize)
+
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < backplaneQueueSize+1; i++ {
+			d.Dispatch("", protocol.NewItem([]byte(fmt.Sprintf("item %d", i)), time.Now()))
+		}
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("dispatch blocked by the backplane")
+	}
+
+	if len(d.outgoing) != backplaneQueueSize {
+		t.Fatalf("got %d queued messages, want %d", len(d.outgoing), backplaneQueueSize)
+	}
+
+	if err := d.publish("", protocol.NewItem([]byte("item"), time.Now())); !errors.Is(err, errBackplaneQueueFull) {
+		t.Fatalf("got error %v, want %s", err, errBackplaneQueueFull)
+	}
+}
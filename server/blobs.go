@@ -0,0 +1,184 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/primalmotion/netboard/protocol"
+)
+
+const (
+	// blobGCInterval is the interval between two
+	// collections of the unreferenced blobs.
+	blobGCInterval = 10 * time.Minute
+
+	// blobGCMinAge is the minimum age of an unreferenced blob
+	// before it is collected, so clients have time to publish
+	// the item referencing a blob they just uploaded.
+	blobGCMinAge = 10 * time.Minute
+)
+
+// errBlobMismatch is returned when the content
+// of an uploaded blob does not match its ID.
+var errBlobMismatch = errors.New("blob content does not match its id")
+
+// A blobStore stores large item contents as files
+// named after their content address.
+type blobStore struct {
+	dir string
+}
+
+func newBlobStore(dir string) (*blobStore, error) {
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("unable to create blob directory: %w", err)
+	}
+
+	return &blobStore{dir: dir}, nil
+}
+
+func (b *blobStore) path(id string) string {
+	return filepath.Join(b.dir, id)
+}
+
+// Stat returns the size of the blob with the given
+// id, and false if it does not exist.
+func (b *blobStore) Stat(id string) (int64, bool) {
+
+	if !protocol.IsValidID(id) {
+		return 0, false
+	}
+
+	info, err := os.Stat(b.path(id))
+	if err != nil {
+		return 0, false
+	}
+
+	return info.Size(), true
+}
+
+// Open opens the blob with the given id.
+func (b *blobStore) Open(id string) (*os.File, error) {
+
+	if !protocol.IsValidID(id) {
+		return nil, os.ErrNotExist
+	}
+
+	return os.Open(b.path(id))
+}
+
+// Write stores the content of the given reader as the blob with
+// the given id. It returns errBlobMismatch if the content does
+// not match the id. If the blob already exists, the content is
+// discarded.
+func (b *blobStore) Write(id string, r io.Reader) (int64, error) {
+
+	if !protocol.IsValidID(id) {
+		return 0, errBlobMismatch
+	}
+
+	if size, ok := b.Stat(id); ok {
+		_, _ = io.Copy(io.Discard, r)
+		return size, nil
+	}
+
+	f, err := os.CreateTemp(b.dir, ".upload-*")
+	if err != nil {
+		return 0, fmt.Errorf("unable to create blob: %w", err)
+	}
+	defer os.Remove(f.Name()) // nolint
+
+	h := sha256.New()
+	size, err := io.Copy(io.MultiWriter(f, h), r)
+	if err != nil {
+		f.Close() // nolint
+		return 0, err
+	}
+
+	if err := f.Close(); err != nil {
+		return 0, fmt.Errorf("unable to write blob: %w", err)
+	}
+
+	if hex.EncodeToString(h.Sum(nil)) != id {
+		return 0, errBlobMismatch
+	}
+
+	if err := os.Rename(f.Name(), b.path(id)); err != nil {
+		return 0, fmt.Errorf("unable to store blob: %w", err)
+	}
+
+	return size, nil
+}
+
+// GC removes the blobs that are not in the given set
+// of ids, and that are older than the given age.
+func (b *blobStore) GC(keep map[string]struct{}, minAge time.Duration) error {
+
+	entries, err := os.ReadDir(b.dir)
+	if err != nil {
+		return fmt.Errorf("unable to list blobs: %w", err)
+	}
+
+	limit := time.Now().Add(-minAge)
+
+	for _, e := range entries {
+
+		if _, ok := keep[e.Name()]; ok {
+			continue
+		}
+
+		info, err := e.Info()
+		if err != nil || info.ModTime().After(limit) {
+			continue
+		}
+
+		if err := os.Remove(b.path(e.Name())); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("unable to remove blob: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// collectBlobs periodically removes the blobs that
+// are not referenced by any item of the given store.
+func collectBlobs(ctx context.Context, blobs *blobStore, store Storage) {
+
+	ticker := time.NewTicker(blobGCInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+
+		case <-ticker.C:
+
+			items, err := store.List(0)
+			if err != nil {
+				slog.Error("unable to list items for blob collection", "error", err)
+				continue
+			}
+
+			keep := make(map[string]struct{}, len(items))
+			for _, item := range items {
+				if item.Blob {
+					keep[item.ID] = struct{}{}
+				}
+			}
+
+			if err := blobs.GC(keep, blobGCMinAge); err != nil {
+				slog.Error("unable to collect blobs", "error", err)
+			}
+
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -1,33 +1,83 @@
 package server
 
 import (
-	"crypto/sha256"
+	"crypto/x509"
 	"fmt"
+	"log/slog"
 	"net/http"
+	"slices"
 	"sync"
+
+	"github.com/primalmotion/netboard/protocol"
 )
 
 func computeID(r *http.Request) string {
-	cert := r.TLS.PeerCertificates[0]
-	return fmt.Sprintf("%02X", sha256.Sum256(cert.Raw)) // #nosec
+	return protocol.Fingerprint(r.TLS.PeerCertificates[0].Raw)
+}
+
+// certGroups returns the groups of the device with the given
+// certificate: its organizations and organizational units.
+func certGroups(cert *x509.Certificate) []string {
+
+	subject := cert.Subject
+
+	var groups []string
+	for _, g := range append(slices.Clone(subject.Organization), subject.OrganizationalUnit...) {
+		if g != "" && !slices.Contains(groups, g) {
+			groups = append(groups, g)
+		}
+	}
+
+	return groups
+}
+
+// inGroups returns true if one of the given groups
+// is part of the selected ones.
+func inGroups(groups []string, selected []string) bool {
+
+	for _, g := range groups {
+		if slices.Contains(selected, g) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// A subscriber is a registered client, receiving the
+// messages compressed with its preferred encoding.
+type subscriber struct {
+	ch       chan []byte
+	encoding string
 }
 
 type dispatcher struct {
 	sync.RWMutex
-	clients map[string]chan []byte
+	clients              map[string]*subscriber
+	store                Storage
+	compressionThreshold int
+
+	backplane Backplane
+	replicaID string
+	outgoing  chan []byte
 }
 
-func newDispatcher() *dispatcher {
+func newDispatcher(store Storage, compressionThreshold int) *dispatcher {
 	return &dispatcher{
-		clients: make(map[string]chan []byte),
+		clients:              make(map[string]*subscriber),
+		store:                store,
+		compressionThreshold: compressionThreshold,
 	}
 }
 
-func (d *dispatcher) Register(c string) {
+func (d *dispatcher) Register(c string, encoding string) {
 	d.Lock()
 	defer d.Unlock()
 
-	d.clients[c] = make(chan []byte)
+	d.clients[c] = &subscriber{
+		ch:       make(chan []byte),
+		encoding: encoding,
+	}
 }
 
 func (d *dispatcher) Unregister(c string) {
@@ -38,29 +88,110 @@ func (d *dispatcher) Unregister(c string) {
 		return
 	}
 
-	close(d.clients[c])
+	close(d.clients[c].ch)
 	delete(d.clients, c)
 }
 
-func (d *dispatcher) Dispatch(srcID string, data []byte) {
+// Dispatch sends the given item to all the clients but srcID.
+// If the server uses a backplane, the item is also queued to be
+// sent through it, so the clients of the other replicas receive
+// it. Clear
+// items are only sent to the local clients, as every replica
+// expires the items on its own.
+func (d *dispatcher) Dispatch(srcID string, item *protocol.Item) {
+
+	d.fanout(srcID, item)
+
+	if d.backplane == nil || item.IsClear() {
+		return
+	}
+
+	if err := d.publish(srcID, item); err != nil {
+		slog.Error("unable to publish item to the backplane", "item", item.ID, "error", err)
+	}
+}
+
+// fanout sends the given item to all the local clients but
+// srcID. The item is encoded once per requested encoding.
+func (d *dispatcher) fanout(srcID string, item *protocol.Item) {
 	d.RLock()
 	defer d.RUnlock()
 
+	messages := map[string][]byte{}
+
 	for id, c := range d.clients {
 		if srcID == id {
 			continue
 		}
+
+		data, ok := messages[c.encoding]
+		if !ok {
+			var err error
+			if data, err = d.encode(item, c.encoding); err != nil {
+				slog.Error("unable to encode item", "item", item.ID, "encoding", c.encoding, "error", err)
+				continue
+			}
+			messages[c.encoding] = data
+		}
+
 		select {
-		case c <- data:
+		case c.ch <- data:
 		default:
 		}
 	}
 }
 
+func (d *dispatcher) encode(item *protocol.Item, encoding string) ([]byte, error) {
+
+	compressed, err := item.Compressed(encoding, d.compressionThreshold)
+	if err != nil {
+		return nil, err
+	}
+
+	return compressed.Encode()
+}
+
+// Update stores the given item. If replayed is true, the time of
+// the item was not set by this server, and the item is discarded,
+// returning false, if a more recent value is already known.
+func (d *dispatcher) Update(item *protocol.Item, replayed bool) (bool, error) {
+	d.Lock()
+	defer d.Unlock()
+
+	if replayed {
+		last, err := latest(d.store)
+		if err != nil {
+			return false, fmt.Errorf("unable to retrieve latest item: %w", err)
+		}
+
+		if last != nil && item.Time.Before(last.Time) {
+			return false, nil
+		}
+	}
+
+	if err := d.store.Put(item); err != nil {
+		return false, fmt.Errorf("unable to store item: %w", err)
+	}
+
+	return true, nil
+}
+
+// Expire removes the item with the given id from the storage.
+func (d *dispatcher) Expire(id string) error {
+	d.Lock()
+	defer d.Unlock()
+
+	return d.store.Delete(id)
+}
+
 func (d *dispatcher) GetChannel(c string) chan []byte {
 
 	d.RLock()
 	defer d.RUnlock()
 
-	return d.clients[c]
+	if s, ok := d.clients[c]; ok {
+		return s.ch
+	}
+
+	return nil
 }
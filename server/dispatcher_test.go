@@ -0,0 +1,101 @@
+package server
+
+import (
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/primalmotion/netboard/protocol"
+)
+
+func TestDispatcherUpdate(t *testing.T) {
+
+	now := time.Now()
+
+	tests := []struct {
+		name     string
+		latest   time.Time
+		itemTime time.Time
+		replayed bool
+		want     bool
+	}{
+		{"live item newer than latest", now, now.Add(time.Second), false, true},
+		{"replayed item newer than latest", now, now.Add(time.Second), true, true},
+		{"replayed item as old as latest", now, now, true, true},
+		{"replayed item older than latest", now, now.Add(-time.Second), true, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			d := newDispatcher(NewMemoryStorage(Retention{}), 0)
+
+			if ok, err := d.Update(protocol.NewItem([]byte("latest"), tt.latest), false); err != nil || !ok {
+				t.Fatalf("unable to store latest item: %t, %v", ok, err)
+			}
+
+			item := protocol.NewItem([]byte("item"), tt.itemTime)
+
+			ok, err := d.Update(item, tt.replayed)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if ok != tt.want {
+				t.Fatalf("got %t, want %t", ok, tt.want)
+			}
+
+			last, err := latest(d.store)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if (last.ID == item.ID) != tt.want {
+				t.Fatalf("latest item is %s", last.ID)
+			}
+		})
+	}
+}
+
+func TestMemoryStorageOrder(t *testing.T) {
+
+	now := time.Now()
+
+	tests := []struct {
+		name      string
+		times     []time.Duration
+		retention Retention
+		want      []string
+	}{
+		{"in order", []time.Duration{0, time.Second, 2 * time.Second}, Retention{}, []string{"2", "1", "0"}},
+		{"older item inserted last", []time.Duration{0, 2 * time.Second, time.Second}, Retention{}, []string{"1", "2", "0"}},
+		{"oldest item pruned", []time.Duration{time.Second, 2 * time.Second, 0}, Retention{MaxItems: 2}, []string{"1", "0"}},
+		{"expired by age", []time.Duration{-2 * time.Hour, 0, -3 * time.Hour}, Retention{MaxAge: time.Hour}, []string{"1"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			s := NewMemoryStorage(tt.retention)
+
+			for i, d := range tt.times {
+				item := protocol.NewItem([]byte(strconv.Itoa(i)), now.Add(d))
+				if err := s.Put(item); err != nil {
+					t.Fatalf("unable to put item: %s", err)
+				}
+			}
+
+			items, err := s.List(0)
+			if err != nil {
+				t.Fatalf("unable to list items: %s", err)
+			}
+
+			var got []string
+			for _, item := range items {
+				got = append(got, string(item.Data))
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("got items %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,53 @@
+package server
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/primalmotion/netboard/protocol"
+)
+
+// scheduleExpiration forgets the given item once it expires, and
+// asks all the subscribers to remove it from their clipboard.
+func scheduleExpiration(dispatch *dispatcher, item *protocol.Item) {
+
+	time.AfterFunc(time.Until(*item.Expires), func() {
+
+		if err := dispatch.Expire(item.ID); err != nil {
+			slog.Error("unable to remove expired item", "item", item.ID, "error", err)
+		}
+
+		slog.Info("item expired", "item", item.ID)
+		dispatch.Dispatch("", protocol.NewClearItem(item.ID))
+	})
+}
+
+// restoreExpirations removes the stored items that expired while
+// the server was not running, and schedules the expiration of the
+// others.
+func restoreExpirations(dispatch *dispatcher) error {
+
+	items, err := dispatch.store.List(0)
+	if err != nil {
+		return fmt.Errorf("unable to list stored items: %w", err)
+	}
+
+	for _, item := range items {
+
+		if item.Expires == nil {
+			continue
+		}
+
+		if item.Expired() {
+			if err := dispatch.Expire(item.ID); err != nil {
+				return fmt.Errorf("unable to remove expired item %s: %w", item.ID, err)
+			}
+			continue
+		}
+
+		scheduleExpiration(dispatch, item)
+	}
+
+	return nil
+}
@@ -0,0 +1,70 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/primalmotion/netboard/protocol"
+)
+
+func TestRestoreExpirations(t *testing.T) {
+
+	tests := []struct {
+		name      string
+		ttl       time.Duration
+		wantKept  bool
+		wantClear bool
+	}{
+		{"expired while stopped", -time.Minute, false, false},
+		{"expiring later", 20 * time.Millisecond, false, true},
+		{"not expiring yet", time.Hour, true, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			d := newDispatcher(NewMemoryStorage(Retention{}), 0)
+
+			ch := make(chan []byte, 8)
+			d.clients["device"] = &subscriber{ch: ch}
+
+			expires := time.Now().Add(tt.ttl)
+			item := protocol.NewItem([]byte("secret"), time.Now())
+			item.Expires = &expires
+
+			if err := d.store.Put(item); err != nil {
+				t.Fatalf("unable to store item: %s", err)
+			}
+
+			if err := restoreExpirations(d); err != nil {
+				t.Fatalf("unable to restore expirations: %s", err)
+			}
+
+			select {
+			case data := <-ch:
+				if !tt.wantClear {
+					t.Fatalf("got unexpected message")
+				}
+				clear, err := protocol.Decode(data, 0)
+				if err != nil {
+					t.Fatalf("unable to decode item: %s", err)
+				}
+				if !clear.IsClear() || clear.ID != item.ID {
+					t.Fatalf("got %+v, want clearing %s", clear, item.ID)
+				}
+			case <-time.After(100 * time.Millisecond):
+				if tt.wantClear {
+					t.Fatalf("subscribers not asked to clear the item")
+				}
+			}
+
+			last, err := latest(d.store)
+			if err != nil {
+				t.Fatalf("unable to get latest item: %s", err)
+			}
+			if kept := last != nil; kept != tt.wantKept {
+				t.Fatalf("item kept: %t, want %t", kept, tt.wantKept)
+			}
+		})
+	}
+}
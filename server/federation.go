@@ -0,0 +1,162 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/primalmotion/netboard/client"
+	"github.com/primalmotion/netboard/protocol"
+)
+
+// federationBlobQueueSize is the number of blob items of a
+// peer waiting for their blob to be fetched, above which the
+// new ones are dropped.
+const federationBlobQueueSize = 8
+
+// A Peer is another netboard server whose
+// items are republished by this server.
+type Peer struct {
+
+	// URL is the address of the peer.
+	URL string
+
+	// TLSConfig is used to connect to the peer. It must
+	// hold a client certificate trusted by the peer.
+	TLSConfig *tls.Config
+
+	// Groups are the groups whose items are republished.
+	// An item belongs to the groups of the certificate of
+	// the device that published it. Empty means all items.
+	Groups []string
+}
+
+// federate subscribes to the given peer, and republishes
+// its items to the local subscribers until the context
+// is canceled. The items bigger than the given limits
+// allow are dropped.
+func federate(ctx context.Context, dispatch *dispatcher, blobs *blobStore, serverID string, limits protocol.Limits, peer Peer) {
+
+	servers := client.NewServers(peer.TLSConfig, peer.URL)
+
+	ch, done := client.SubscribeWS(ctx, servers, client.SubscribeConfig{
+		TLSConfig: peer.TLSConfig,
+		Backoff:   client.DefaultBackoff,
+		Encodings: protocol.Encodings,
+		MaxSize:   limits.Max(),
+		StateFunc: func(evt client.StateEvent) {
+			if evt.Err != nil {
+				slog.Warn("federation state changed", "peer", peer.URL, "state", evt.State, "error", evt.Err)
+				return
+			}
+			slog.Info("federation state changed", "peer", peer.URL, "state", evt.State)
+		},
+	})
+
+	republishItems(ch, done, peer.URL, func(item *protocol.Item) {
+		if err := republish(ctx, dispatch, blobs, serverID, limits, peer, servers.Client(), item); err != nil {
+			slog.Error("unable to republish item", "peer", peer.URL, "item", item.ID, "error", err)
+		}
+	})
+}
+
+// republishItems calls the given function for every item received
+// from the peer with the given url until done is closed. The blob
+// items are handled apart, so a slow download does not delay the
+// other items. A blob item ready after a newer item is discarded
+// as stale when dispatched.
+func republishItems(ch <-chan *protocol.Item, done <-chan struct{}, peerURL string, handle func(*protocol.Item)) {
+
+	blobItems := make(chan *protocol.Item, federationBlobQueueSize)
+	defer close(blobItems)
+
+	go func() {
+		for item := range blobItems {
+			handle(item)
+		}
+	}()
+
+	for {
+		select {
+
+		case item := <-ch:
+			if !item.Blob {
+				handle(item)
+				continue
+			}
+
+			select {
+			case blobItems <- item:
+			default:
+				slog.Warn("too many blobs to fetch: dropping item", "peer", peerURL, "item", item.ID)
+			}
+
+		case <-done:
+			return
+		}
+	}
+}
+
+// republish dispatches the given item received from the given
+// peer to the local subscribers. Its blob, if any, is fetched
+// with the given HTTP client, up to the size the given limits
+// allow.
+func republish(ctx context.Context, dispatch *dispatcher, blobs *blobStore, serverID string, limits protocol.Limits, peer Peer, httpClient *http.Client, item *protocol.Item) error {
+
+	// Every server schedules the expiration of
+	// the items itself, so clear items are ignored.
+	if item.IsClear() || item.Expired() {
+		return nil
+	}
+
+	for _, id := range item.Via {
+		if id == serverID {
+			return nil
+		}
+	}
+
+	if len(peer.Groups) > 0 && !inGroups(item.Groups, peer.Groups) {
+		slog.Debug("ignored item outside federated groups", "peer", peer.URL, "item", item.ID)
+		return nil
+	}
+
+	if item.Blob {
+
+		if blobs == nil {
+			return fmt.Errorf("blobs are not supported by this server")
+		}
+
+		// The blobs fetched by the client are cached under their
+		// ID, which is how the blob store names them, so fetching
+		// it in the blob directory is enough to store it.
+		if _, ok := blobs.Stat(item.ID); !ok {
+			if _, err := client.FetchBlob(ctx, peer.URL, httpClient, item.ID, limits.For(item.MIME), blobs.dir); err != nil {
+				return fmt.Errorf("unable to fetch blob: %w", err)
+			}
+		}
+	}
+
+	item.Via = append(item.Via, serverID)
+
+	ok, err := dispatch.Update(item, true)
+	if err != nil {
+		return err
+	}
+
+	if !ok {
+		slog.Info("discarded stale item", "peer", peer.URL, "item", item.ID)
+		return nil
+	}
+
+	if item.Expires != nil {
+		scheduleExpiration(dispatch, item)
+	}
+
+	slog.Info("dispatched item", "peer", peer.URL, "item", item.ID, "size", len(item.Data), "mime", item.MIME, "blob", item.Blob)
+
+	dispatch.Dispatch("", item)
+
+	return nil
+}
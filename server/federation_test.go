@@ -0,0 +1,123 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/primalmotion/netboard/protocol"
+)
+
+func TestRepublish(t *testing.T) {
+
+	tests := []struct {
+		name       string
+		peerGroups []string
+		itemGroups []string
+		via        []string
+		want       bool
+	}{
+		{"all groups federated", nil, []string{"support"}, nil, true},
+		{"item without group with all groups federated", nil, nil, nil, true},
+		{"item in a federated group", []string{"engineering", "support"}, []string{"support"}, nil, true},
+		{"item outside the federated groups", []string{"engineering"}, []string{"support"}, nil, false},
+		{"item without group", []string{"engineering"}, nil, nil, false},
+		{"item already republished", nil, nil, []string{"peer", "self"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			d := newDispatcher(NewMemoryStorage(Retention{}), 0)
+
+			item := protocol.NewItem([]byte("item"), time.Now())
+			item.Groups = tt.itemGroups
+			item.Via = tt.via
+
+			peer := Peer{URL: "https://peer.example.com", Groups: tt.peerGroups}
+			if err := republish(context.Background(), d, nil, "self", protocol.Limits{}, peer, nil, item); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			last, err := latest(d.store)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if (last != nil && last.ID == item.ID) != tt.want {
+				t.Fatalf("item republished: %t, want %t", !tt.want, tt.want)
+			}
+		})
+	}
+}
+
+func TestRepublishItems(t *testing.T) {
+
+	ch := make(chan *protocol.Item)
+	done := make(chan struct{})
+
+	// The blobs are never fetched until released.
+	fetching := make(chan struct{}, 2*federationBlobQueueSize)
+	release := make(chan struct{})
+	handled := make(chan string, 2*federationBlobQueueSize)
+
+	finished := make(chan struct{})
+	go func() {
+		republishItems(ch, done, "https://peer.example.com", func(item *protocol.Item) {
+			if item.Blob {
+				fetching <- struct{}{}
+				<-release
+			}
+			handled <- string(item.Data)
+		})
+		close(finished)
+	}()
+
+	now := time.Now()
+
+	// One blob is being fetched, and the
+	// queue is full of the ones waiting.
+	for i := 0; i < federationBlobQueueSize+2; i++ {
+		blob := protocol.NewItem([]byte("blob"), now)
+		blob.Blob = true
+		ch <- blob
+		if i == 0 {
+			<-fetching
+		}
+	}
+
+	ch <- protocol.NewItem([]byte("text"), now)
+
+	select {
+	case got := <-handled:
+		if got != "text" {
+			t.Fatalf("got item %s, want text", got)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("item delayed by the blobs")
+	}
+
+	close(release)
+
+	// The blob above the queue size was dropped.
+	for i := 0; i < federationBlobQueueSize+1; i++ {
+		select {
+		case <-handled:
+		case <-time.After(time.Second):
+			t.Fatalf("got %d blobs, want %d", i, federationBlobQueueSize+1)
+		}
+	}
+
+	close(done)
+
+	select {
+	case <-finished:
+	case <-time.After(time.Second):
+		t.Fatalf("republication not stopped")
+	}
+
+	select {
+	case got := <-handled:
+		t.Fatalf("got unexpected item %s", got)
+	default:
+	}
+}
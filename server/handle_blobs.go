@@ -0,0 +1,95 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"mime"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+func makeBlobsHandler(blobs *blobStore, live *settings, publishLimiter *rateLimiter) func(http.ResponseWriter, *http.Request) {
+
+	put := withRateLimit(publishLimiter, func(w http.ResponseWriter, r *http.Request) {
+
+		id := strings.TrimPrefix(r.URL.Path, "/blobs/")
+
+		// The blob is capped by the limit of its declared type,
+		// which is checked again when its item is published.
+		contentType := "application/octet-stream"
+		if ct := r.Header.Get("Content-Type"); ct != "" {
+			if mt, _, err := mime.ParseMediaType(ct); err == nil {
+				contentType = mt
+			}
+		}
+
+		limits := live.Limits()
+		if max := limits.For(contentType); max > 0 {
+			r.Body = http.MaxBytesReader(w, r.Body, max)
+		}
+
+		size, err := blobs.Write(id, r.Body)
+		if err != nil {
+			var mbErr *http.MaxBytesError
+			switch {
+			case errors.As(err, &mbErr):
+				http.Error(
+					w,
+					fmt.Sprintf("blob of type %s exceeds the maximum size of %d bytes", contentType, mbErr.Limit),
+					http.StatusRequestEntityTooLarge,
+				)
+			case errors.Is(err, errBlobMismatch):
+				http.Error(w, err.Error(), http.StatusBadRequest)
+			default:
+				http.Error(
+					w,
+					fmt.Sprintf("unable to store blob: %s", err),
+					http.StatusInternalServerError,
+				)
+			}
+			return
+		}
+
+		slog.Info("stored blob", "device", computeID(r), "remote", r.RemoteAddr, "blob", id, "size", size, "mime", contentType)
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		switch r.Method {
+
+		case http.MethodGet, http.MethodHead:
+
+			id := strings.TrimPrefix(r.URL.Path, "/blobs/")
+
+			f, err := blobs.Open(id)
+			if err != nil {
+				if errors.Is(err, os.ErrNotExist) {
+					http.Error(w, "blob not found", http.StatusNotFound)
+					return
+				}
+				http.Error(
+					w,
+					fmt.Sprintf("unable to open blob: %s", err),
+					http.StatusInternalServerError,
+				)
+				return
+			}
+			defer f.Close() // nolint
+
+			// Blobs are immutable, so their id is a perfect etag.
+			w.Header().Set("ETag", `"`+id+`"`)
+			w.Header().Set("Content-Type", "application/octet-stream")
+			http.ServeContent(w, r, "", time.Time{}, f)
+
+		case http.MethodPut:
+			put(w, r)
+
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package server
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/primalmotion/netboard/protocol"
+)
+
+func TestBlobsHandler(t *testing.T) {
+
+	content := bytes.Repeat([]byte("some blob content "), 100)
+	id := protocol.ComputeID(content)
+
+	limits := protocol.Limits{
+		MaxSize: 1024,
+		MIME:    []protocol.MIMELimit{{Type: "image/*", MaxSize: 4096}},
+	}
+
+	tests := []struct {
+		name        string
+		method      string
+		id          string
+		body        []byte
+		headers     map[string]string
+		wantStatus  int
+		wantContent []byte
+	}{
+		{"missing blob", http.MethodGet, id, nil, nil, http.StatusNotFound, nil},
+		{"above type limit", http.MethodPut, id, content, nil, http.StatusRequestEntityTooLarge, nil},
+		{"content mismatch", http.MethodPut, id, []byte("another content"), nil, http.StatusBadRequest, nil},
+		{"invalid id", http.MethodPut, "../history.log", content, nil, http.StatusBadRequest, nil},
+		{"uploaded", http.MethodPut, id, content, map[string]string{"Content-Type": "image/png"}, http.StatusNoContent, nil},
+		{"uploaded again", http.MethodPut, id, content, map[string]string{"Content-Type": "image/png"}, http.StatusNoContent, nil},
+		{"fetched", http.MethodGet, id, nil, nil, http.StatusOK, content},
+		{"fetched from an offset", http.MethodGet, id, nil, map[string]string{"Range": "bytes=1000-"}, http.StatusPartialContent, content[1000:]},
+		{"offset beyond the blob", http.MethodGet, id, nil, map[string]string{"Range": "bytes=5000-"}, http.StatusRequestedRangeNotSatisfiable, nil},
+		{"fetched with invalid id", http.MethodGet, "../history.log", nil, nil, http.StatusNotFound, nil},
+		{"unsupported method", http.MethodDelete, id, nil, nil, http.StatusMethodNotAllowed, nil},
+	}
+
+	blobs, err := newBlobStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("unable to create blob store: %s", err)
+	}
+
+	handler := makeBlobsHandler(blobs, newSettings(limits, nil), newRateLimiter(RateLimit{}))
+
+	// The cases run in order, as they share the blob store.
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			r := publishRequest(tt.body, tt.headers)
+			r.Method = tt.method
+			r.URL.Path = "/blobs/" + tt.id
+
+			w := httptest.NewRecorder()
+			handler(w, r)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("got status %d, want %d: %s", w.Code, tt.wantStatus, w.Body.String())
+			}
+
+			if tt.wantContent != nil {
+				body, _ := io.ReadAll(w.Body)
+				if !bytes.Equal(body, tt.wantContent) {
+					t.Fatalf("got %d bytes, want %d", len(body), len(tt.wantContent))
+				}
+			}
+		})
+	}
+}
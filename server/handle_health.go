@@ -0,0 +1,56 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+)
+
+func makeHealthzHandler() func(http.ResponseWriter, *http.Request) {
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		_, _ = w.Write([]byte("ok\n"))
+	}
+}
+
+// makeReadyzHandler returns a handler answering 200 when
+// the server is listening and its storage is working.
+func makeReadyzHandler(dispatch *dispatcher, ready *atomic.Bool) func(http.ResponseWriter, *http.Request) {
+
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		if !ready.Load() {
+			http.Error(w, "not ready", http.StatusServiceUnavailable)
+			return
+		}
+
+		if _, err := latest(dispatch.store); err != nil {
+			http.Error(
+				w,
+				fmt.Sprintf("storage is not available: %s", err),
+				http.StatusServiceUnavailable,
+			)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/plain")
+		_, _ = w.Write([]byte("ok\n"))
+	}
+}
+
+func makeVersionHandler(version string, commit string) func(http.ResponseWriter, *http.Request) {
+
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(struct {
+			Version string `json:"version"`
+			Commit  string `json:"commit"`
+		}{
+			Version: version,
+			Commit:  commit,
+		})
+	}
+}
@@ -0,0 +1,75 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/primalmotion/netboard/protocol"
+)
+
+// failingStorage is a Storage that cannot list its items.
+type failingStorage struct {
+	Storage
+}
+
+func (s failingStorage) List(int) ([]*protocol.Item, error) {
+	return nil, fmt.Errorf("disk is gone")
+}
+
+func TestReadyzHandler(t *testing.T) {
+
+	tests := []struct {
+		name       string
+		ready      bool
+		store      Storage
+		wantStatus int
+	}{
+		{"ready", true, NewMemoryStorage(Retention{}), http.StatusOK},
+		{"not listening yet", false, NewMemoryStorage(Retention{}), http.StatusServiceUnavailable},
+		{"storage failing", true, failingStorage{}, http.StatusServiceUnavailable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			var ready atomic.Bool
+			ready.Store(tt.ready)
+
+			w := httptest.NewRecorder()
+			makeReadyzHandler(newDispatcher(tt.store, 0), &ready)(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("got status %d, want %d", w.Code, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestHealthzHandler(t *testing.T) {
+
+	w := httptest.NewRecorder()
+	makeHealthzHandler()(w, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+
+	if w.Code != http.StatusOK || w.Body.String() != "ok\n" {
+		t.Fatalf("got status %d and body %q", w.Code, w.Body.String())
+	}
+}
+
+func TestVersionHandler(t *testing.T) {
+
+	w := httptest.NewRecorder()
+	makeVersionHandler("v1.2.0", "4a21117")(w, httptest.NewRequest(http.MethodGet, "/version", nil))
+
+	got := map[string]string{}
+	if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+		t.Fatalf("unable to decode version: %s", err)
+	}
+
+	if got["version"] != "v1.2.0" || got["commit"] != "4a21117" {
+		t.Fatalf("unexpected version: %v", got)
+	}
+}
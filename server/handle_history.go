@@ -0,0 +1,81 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/primalmotion/netboard/protocol"
+)
+
+// makeHistoryHandler returns a handler serving the history of the
+// published items. GET /history lists them, most recent first and
+// without their content. The limit query parameter caps their
+// number. GET /history/<id> returns an item with its content.
+func makeHistoryHandler(dispatch *dispatcher) func(http.ResponseWriter, *http.Request) {
+
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var limit int
+		if l := r.URL.Query().Get("limit"); l != "" {
+			var err error
+			if limit, err = strconv.Atoi(l); err != nil || limit < 0 {
+				http.Error(w, fmt.Sprintf("invalid limit: %s", l), http.StatusBadRequest)
+				return
+			}
+		}
+
+		id := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/history"), "/")
+
+		items, err := dispatch.store.List(0)
+		if err != nil {
+			http.Error(
+				w,
+				fmt.Sprintf("unable to list history: %s", err),
+				http.StatusInternalServerError,
+			)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+
+		if id != "" {
+			for _, item := range items {
+				if item.ID == id && !item.IsClear() && !item.Expired() {
+					_ = json.NewEncoder(w).Encode(item)
+					return
+				}
+			}
+			http.Error(w, "item not found", http.StatusNotFound)
+			return
+		}
+
+		out := []protocol.Item{}
+		for _, item := range items {
+
+			if item.IsClear() || item.Expired() {
+				continue
+			}
+
+			i := *item
+			if !i.Blob {
+				i.Size = int64(len(i.Data))
+			}
+			i.Data = nil
+
+			out = append(out, i)
+			if limit > 0 && len(out) >= limit {
+				break
+			}
+		}
+
+		_ = json.NewEncoder(w).Encode(out)
+	}
+}
@@ -1,34 +1,206 @@
 package server
 
 import (
-	"encoding/base64"
+	"errors"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
+	"mime"
 	"net/http"
+	"time"
+
+	"github.com/primalmotion/netboard/protocol"
 )
 
-func makePublishHandler(dispatch *dispatcher) func(http.ResponseWriter, *http.Request) {
+func makePublishHandler(dispatch *dispatcher, blobs *blobStore, hooks *webhooks, live *settings, serverID string, sink AuditSink) func(http.ResponseWriter, *http.Request) {
 
 	return func(w http.ResponseWriter, r *http.Request) {
 
-		data, err := io.ReadAll(r.Body)
+		var err error
+
+		limits := live.Limits()
+
+		// Live items are stamped with the time we receive them,
+		// so the clocks of the devices do not matter. Only the
+		// replayed ones come with the time they were copied at,
+		// which can never be in the future.
+		now := time.Now()
+		t := now
+		replayed := false
+		if h := r.Header.Get(protocol.TimeHeader); h != "" {
+			if t, err = time.Parse(time.RFC3339Nano, h); err != nil {
+				http.Error(
+					w,
+					fmt.Sprintf("unable to parse %s header: %s", protocol.TimeHeader, err),
+					http.StatusBadRequest,
+				)
+				return
+			}
+			if t.After(now) {
+				t = now
+			}
+			replayed = true
+		}
+
+		var ttl time.Duration
+		if h := r.Header.Get(protocol.TTLHeader); h != "" {
+			if ttl, err = time.ParseDuration(h); err != nil || ttl <= 0 {
+				http.Error(
+					w,
+					fmt.Sprintf("invalid %s header: must be a positive duration", protocol.TTLHeader),
+					http.StatusBadRequest,
+				)
+				return
+			}
+		}
+
+		var contentType string
+		if ct := r.Header.Get("Content-Type"); ct != "" {
+			if mt, _, err := mime.ParseMediaType(ct); err == nil {
+				contentType = mt
+			}
+		}
+
+		id := computeID(r)
+
+		var item *protocol.Item
+
+		if blobID := r.Header.Get(protocol.BlobHeader); blobID != "" {
+
+			if blobs == nil {
+				http.Error(w, "blobs are not supported by this server", http.StatusBadRequest)
+				return
+			}
+
+			size, ok := blobs.Stat(blobID)
+			if !ok {
+				http.Error(w, "unknown blob: upload it first", http.StatusBadRequest)
+				return
+			}
+
+			item = &protocol.Item{
+				ID:   blobID,
+				Time: t,
+				MIME: "application/octet-stream",
+				Blob: true,
+				Size: size,
+			}
+
+		} else {
+
+			if encoding := r.Header.Get("Content-Encoding"); encoding != "" && encoding != "identity" {
+				body, err := protocol.NewDecompressReader(encoding, r.Body)
+				if err != nil {
+					http.Error(
+						w,
+						fmt.Sprintf("unable to decompress body: %s", err),
+						http.StatusUnsupportedMediaType,
+					)
+					return
+				}
+				defer body.Close() // nolint
+				r.Body = body
+			}
+
+			maxSize := limits.Max()
+			if contentType != "" {
+				maxSize = limits.For(contentType)
+			}
+
+			// The limit applies to the decompressed
+			// body, to protect against compression bombs.
+			if maxSize > 0 {
+				r.Body = http.MaxBytesReader(w, r.Body, maxSize)
+			}
+
+			data, err := io.ReadAll(r.Body)
+			if err != nil {
+				var mbErr *http.MaxBytesError
+				if errors.As(err, &mbErr) {
+					slog.Warn("rejected item too large", "device", id, "remote", r.RemoteAddr, "limit", mbErr.Limit)
+					http.Error(
+						w,
+						fmt.Sprintf("item exceeds the maximum size of %d bytes", mbErr.Limit),
+						http.StatusRequestEntityTooLarge,
+					)
+					return
+				}
+				http.Error(
+					w,
+					fmt.Sprintf("unable to read body: %s", err),
+					http.StatusBadRequest,
+				)
+				return
+			}
+
+			item = protocol.NewItem(data, t)
+		}
+
+		item.Origin = id
+		item.OriginName = r.TLS.PeerCertificates[0].Subject.CommonName
+		item.Groups = certGroups(r.TLS.PeerCertificates[0])
+		if serverID != "" {
+			item.Via = []string{serverID}
+		}
+		if contentType != "" {
+			item.MIME = contentType
+		}
+
+		size := int64(len(item.Data))
+		if item.Blob {
+			size = item.Size
+		}
+
+		if max := limits.For(item.MIME); max > 0 && size > max {
+			slog.Warn("rejected item too large", "device", id, "remote", r.RemoteAddr, "item", item.ID, "size", size, "mime", item.MIME, "limit", max)
+			http.Error(
+				w,
+				fmt.Sprintf("item of type %s exceeds the maximum size of %d bytes", item.MIME, max),
+				http.StatusRequestEntityTooLarge,
+			)
+			return
+		}
+
+		if ttl > 0 {
+			expires := time.Now().Add(ttl)
+			item.Expires = &expires
+		}
+
+		ok, err := dispatch.Update(item, replayed)
 		if err != nil {
 			http.Error(
 				w,
-				fmt.Sprintf("unable to read body: %s", err),
-				http.StatusBadRequest,
+				fmt.Sprintf("unable to update state: %s", err),
+				http.StatusInternalServerError,
 			)
 			return
 		}
 
-		encoded := base64.RawURLEncoding.EncodeToString(data)
-		encoded = encoded + ","
+		if !ok {
+			slog.Info("discarded stale item", "device", id, "remote", r.RemoteAddr, "item", item.ID, "copied", item.Time)
+			http.Error(
+				w,
+				"a newer value has already been published",
+				http.StatusConflict,
+			)
+			return
+		}
 
-		id := computeID(r)
-		log.Printf("dispatched data from: %s", id)
+		if item.Expires != nil {
+			scheduleExpiration(dispatch, item)
+		}
+
+		slog.Info("dispatched item", "device", id, "remote", r.RemoteAddr, "item", item.ID, "size", size, "mime", item.MIME, "blob", item.Blob)
+
+		evt := newAuditEvent(AuditPublish, r)
+		evt.Hash = item.ID
+		evt.Size = size
+		evt.MIME = item.MIME
+		audit(sink, evt)
 
-		dispatch.Dispatch(id, []byte(encoded))
+		dispatch.Dispatch(id, item)
+		hooks.Trigger(item, r.TLS.PeerCertificates[0])
+		w.Header().Set(protocol.IDHeader, item.ID)
 		w.WriteHeader(http.StatusNoContent)
 	}
 }
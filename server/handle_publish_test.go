@@ -0,0 +1,87 @@
+package server
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/primalmotion/netboard/protocol"
+)
+
+// publishRequest returns a publication of the given
+// body, made by a device with a client certificate.
+func publishRequest(body []byte, headers map[string]string) *http.Request {
+
+	r := httptest.NewRequest(http.MethodPost, "/publish", bytes.NewReader(body))
+	r.TLS = &tls.ConnectionState{
+		PeerCertificates: []*x509.Certificate{{Raw: []byte("laptop certificate"), Subject: pkix.Name{CommonName: "laptop"}}},
+	}
+
+	for k, v := range headers {
+		r.Header.Set(k, v)
+	}
+
+	return r
+}
+
+func TestPublishHandlerLimits(t *testing.T) {
+
+	limits := protocol.Limits{
+		MaxSize: 16,
+		MIME:    []protocol.MIMELimit{{Type: "image/*", MaxSize: 64}},
+	}
+
+	small := bytes.Repeat([]byte("a"), 16)
+	large := bytes.Repeat([]byte("a"), 17)
+	bomb := bytes.Repeat([]byte("a"), 1<<20)
+
+	compress := func(data []byte) []byte {
+		out, err := protocol.Compress(protocol.EncodingZstd, data)
+		if err != nil {
+			t.Fatalf("unable to compress: %s", err)
+		}
+		return out
+	}
+
+	tests := []struct {
+		name       string
+		body       []byte
+		headers    map[string]string
+		wantStatus int
+	}{
+		{"within limit", small, nil, http.StatusNoContent},
+		{"above limit", large, nil, http.StatusRequestEntityTooLarge},
+		{"within type limit", large, map[string]string{"Content-Type": "image/png"}, http.StatusNoContent},
+		{"above type limit", bytes.Repeat([]byte("a"), 65), map[string]string{"Content-Type": "image/png"}, http.StatusRequestEntityTooLarge},
+		{"compressed within limit", compress(small), map[string]string{"Content-Encoding": protocol.EncodingZstd}, http.StatusNoContent},
+		{"compression bomb", compress(bomb), map[string]string{"Content-Encoding": protocol.EncodingZstd}, http.StatusRequestEntityTooLarge},
+		{"unknown encoding", small, map[string]string{"Content-Encoding": "br"}, http.StatusUnsupportedMediaType},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			dispatch := newDispatcher(NewMemoryStorage(Retention{}), 0)
+			handler := makePublishHandler(dispatch, nil, nil, newSettings(limits, nil), "", nil)
+
+			w := httptest.NewRecorder()
+			handler(w, publishRequest(tt.body, tt.headers))
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("got status %d, want %d: %s", w.Code, tt.wantStatus, w.Body.String())
+			}
+
+			last, err := latest(dispatch.store)
+			if err != nil {
+				t.Fatalf("unable to get latest item: %s", err)
+			}
+			if stored := last != nil; stored != (tt.wantStatus == http.StatusNoContent) {
+				t.Fatalf("item stored: %t", stored)
+			}
+		})
+	}
+}
@@ -1,11 +1,13 @@
 package server
 
 import (
-	"log"
+	"log/slog"
 	"net/http"
+
+	"github.com/primalmotion/netboard/protocol"
 )
 
-func makeSubscribeChunkedHandler(dispatch *dispatcher) func(http.ResponseWriter, *http.Request) {
+func makeSubscribeChunkedHandler(dispatch *dispatcher, live *settings, sink AuditSink) func(http.ResponseWriter, *http.Request) {
 
 	return func(w http.ResponseWriter, r *http.Request) {
 
@@ -16,10 +18,21 @@ func makeSubscribeChunkedHandler(dispatch *dispatcher) func(http.ResponseWriter,
 		}
 
 		id := computeID(r)
-		dispatch.Register(id)
+		dispatch.Register(id, protocol.NegotiateEncoding(r.Header.Get(protocol.AcceptEncodingHeader)))
 		defer dispatch.Unregister(id)
 		ch := dispatch.GetChannel(id)
 
+		slog.Info("subscriber connected", "device", id, "remote", r.RemoteAddr, "transport", "chunked")
+		defer slog.Info("subscriber disconnected", "device", id, "remote", r.RemoteAddr, "transport", "chunked")
+
+		evt := newAuditEvent(AuditSubscribe, r)
+		evt.Transport = "chunked"
+		audit(sink, evt)
+		defer func() {
+			evt.Event = AuditDisconnect
+			audit(sink, evt)
+		}()
+
 		for {
 			select {
 
@@ -27,9 +40,15 @@ func makeSubscribeChunkedHandler(dispatch *dispatcher) func(http.ResponseWriter,
 				flusher.Flush()
 				return
 
+			case <-live.Changed():
+				if live.Revoked(r.TLS.PeerCertificates[0]) {
+					slog.Info("disconnecting revoked subscriber", "device", id, "remote", r.RemoteAddr)
+					return
+				}
+
 			case c := <-ch:
 				if _, err := w.Write(c); err != nil {
-					log.Printf("unable to write chunk to client %s: %s", id, err)
+					slog.Error("unable to write chunk to subscriber", "device", id, "remote", r.RemoteAddr, "error", err)
 				}
 				flusher.Flush()
 			}
@@ -2,14 +2,16 @@ package server
 
 import (
 	"fmt"
+	"log/slog"
 	"net/http"
 	"time"
 
 	"github.com/gorilla/websocket"
+	"github.com/primalmotion/netboard/protocol"
 	"go.aporeto.io/wsc"
 )
 
-func makeSubscribeWSHandler(dispatch *dispatcher) func(http.ResponseWriter, *http.Request) {
+func makeSubscribeWSHandler(dispatch *dispatcher, live *settings, sink AuditSink) func(http.ResponseWriter, *http.Request) {
 
 	upgrader := websocket.Upgrader{
 		CheckOrigin: func(*http.Request) bool { return true },
@@ -41,10 +43,21 @@ func makeSubscribeWSHandler(dispatch *dispatcher) func(http.ResponseWriter, *htt
 		}
 
 		id := computeID(r)
-		dispatch.Register(id)
+		dispatch.Register(id, protocol.NegotiateEncoding(r.Header.Get(protocol.AcceptEncodingHeader)))
 		defer dispatch.Unregister(id)
 		ch := dispatch.GetChannel(id)
 
+		slog.Info("subscriber connected", "device", id, "remote", r.RemoteAddr, "transport", "websocket")
+		defer slog.Info("subscriber disconnected", "device", id, "remote", r.RemoteAddr, "transport", "websocket")
+
+		evt := newAuditEvent(AuditSubscribe, r)
+		evt.Transport = "websocket"
+		audit(sink, evt)
+		defer func() {
+			evt.Event = AuditDisconnect
+			audit(sink, evt)
+		}()
+
 		for {
 			select {
 
@@ -54,6 +67,12 @@ func makeSubscribeWSHandler(dispatch *dispatcher) func(http.ResponseWriter, *htt
 			case <-conn.Done():
 				return
 
+			case <-live.Changed():
+				if live.Revoked(r.TLS.PeerCertificates[0]) {
+					slog.Info("disconnecting revoked subscriber", "device", id, "remote", r.RemoteAddr)
+					conn.Close(websocket.ClosePolicyViolation)
+				}
+
 			case <-r.Context().Done():
 				conn.Close(websocket.CloseGoingAway)
 			}
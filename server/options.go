@@ -0,0 +1,185 @@
+package server
+
+import "github.com/primalmotion/netboard/protocol"
+
+type config struct {
+	store          Storage
+	limits         protocol.Limits
+	publishRate    RateLimit
+	connectionRate RateLimit
+	blobDir        string
+	blobThreshold  int64
+	sharedBlobs    bool
+
+	compressionThreshold int
+
+	serverID string
+	peers    []Peer
+
+	backplane Backplane
+
+	healthListenAddr string
+	version          string
+	commit           string
+
+	audit AuditSink
+
+	revoked []string
+	reload  <-chan Settings
+
+	webhooks       []Webhook
+	deadLetterPath string
+}
+
+func newConfig() config {
+	return config{
+		store:                NewMemoryStorage(Retention{MaxItems: 1}),
+		compressionThreshold: 1024,
+	}
+}
+
+// An Option configures the server.
+type Option func(*config)
+
+// OptStorage sets the Storage used to keep the published items.
+// By default, only the latest item is kept in memory.
+func OptStorage(store Storage) Option {
+	return func(c *config) {
+		c.store = store
+	}
+}
+
+// OptLimits sets the limits enforced on published items.
+// By default, there is no limit.
+func OptLimits(limits protocol.Limits) Option {
+	return func(c *config) {
+		c.limits = limits
+	}
+}
+
+// OptPublishRateLimit sets the rate limit of publications
+// per device. By default, there is no limit.
+func OptPublishRateLimit(limit RateLimit) Option {
+	return func(c *config) {
+		c.publishRate = limit
+	}
+}
+
+// OptConnectionRateLimit sets the rate limit of subscriptions
+// per device. By default, there is no limit.
+func OptConnectionRateLimit(limit RateLimit) Option {
+	return func(c *config) {
+		c.connectionRate = limit
+	}
+}
+
+// OptBlobs enables the upload of large items as blobs stored in
+// the given directory. Clients are told to use blobs for items
+// larger than threshold bytes. By default, blobs are disabled.
+func OptBlobs(dir string, threshold int64) Option {
+	return func(c *config) {
+		c.blobDir = dir
+		c.blobThreshold = threshold
+	}
+}
+
+// OptSharedBlobs tells the directory given to OptBlobs is
+// shared by all the replicas of the server, which is required
+// to use blobs with a backplane: the items received through
+// the backplane only reference their blob.
+func OptSharedBlobs() Option {
+	return func(c *config) {
+		c.sharedBlobs = true
+	}
+}
+
+// OptCompressionThreshold sets the size in bytes below which
+// the items sent to subscribers accepting compression are
+// not compressed. By default, it is 1024 bytes.
+func OptCompressionThreshold(threshold int) Option {
+	return func(c *config) {
+		c.compressionThreshold = threshold
+	}
+}
+
+// OptFederation makes the server republish the items of
+// the given peers. The given server ID is recorded in the
+// items going through the server, so they never loop
+// between peers. If empty, the fingerprint of the server
+// certificate is used. By default, there is no peer.
+func OptFederation(serverID string, peers ...Peer) Option {
+	return func(c *config) {
+		c.serverID = serverID
+		c.peers = peers
+	}
+}
+
+// OptBackplane makes the server share the published items with
+// its replicas through the given Backplane, so the clients of all
+// the replicas receive them. By default, there is no backplane.
+func OptBackplane(backplane Backplane) Option {
+	return func(c *config) {
+		c.backplane = backplane
+	}
+}
+
+// OptHealthListen serves /healthz, /readyz and /version on
+// the given address, in plain HTTP without client certificate,
+// so load balancers can probe the server. They are always
+// served on the main address too.
+func OptHealthListen(addr string) Option {
+	return func(c *config) {
+		c.healthListenAddr = addr
+	}
+}
+
+// OptVersion sets the version and commit
+// reported by the /version endpoint.
+func OptVersion(version string, commit string) Option {
+	return func(c *config) {
+		c.version = version
+		c.commit = commit
+	}
+}
+
+// OptAudit records the activity of the clients in the given
+// AuditSink: publications, subscriptions, disconnections and
+// rejected certificates, as well as the administrative actions.
+// By default, nothing is recorded.
+func OptAudit(sink AuditSink) Option {
+	return func(c *config) {
+		c.audit = sink
+	}
+}
+
+// OptRevoked rejects the client certificates with the given
+// fingerprints or common names. By default, none is revoked.
+func OptRevoked(revoked ...string) Option {
+	return func(c *config) {
+		c.revoked = revoked
+	}
+}
+
+// OptReload makes the server apply the Settings received from
+// the given channel while it runs. They replace the limits and
+// the revoked certificates, and the subscribers whose certificate
+// is revoked are disconnected. The federated subscriptions keep
+// the limits they started with. Every reload, limit change and
+// revocation is recorded in the AuditSink, if any. By default,
+// the settings never change.
+func OptReload(ch <-chan Settings) Option {
+	return func(c *config) {
+		c.reload = ch
+	}
+}
+
+// OptWebhooks posts the items published by the clients to the
+// given webhooks. The deliveries still failing after all their
+// attempts are appended to the file at deadLetterPath, if set.
+// By default, there is no webhook.
+func OptWebhooks(deadLetterPath string, hooks ...Webhook) Option {
+	return func(c *config) {
+		c.deadLetterPath = deadLetterPath
+		c.webhooks = hooks
+	}
+}
@@ -0,0 +1,111 @@
+package server
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// RateLimit configures a token bucket.
+type RateLimit struct {
+
+	// Rate is the number of tokens added per second.
+	// 0 disables the rate limit.
+	Rate float64 `mapstructure:"rate"`
+
+	// Burst is the maximum number of tokens.
+	Burst int `mapstructure:"burst"`
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// A rateLimiter applies a token bucket per device.
+type rateLimiter struct {
+	limit   RateLimit
+	buckets map[string]*bucket
+	calls   int
+
+	sync.Mutex
+}
+
+func newRateLimiter(limit RateLimit) *rateLimiter {
+
+	if limit.Burst < 1 {
+		limit.Burst = 1
+	}
+
+	return &rateLimiter{
+		limit:   limit,
+		buckets: map[string]*bucket{},
+	}
+}
+
+// Allow consumes a token for the device with the given id. If
+// there is none left, it returns false and the time to wait for
+// the next one.
+func (l *rateLimiter) Allow(id string) (bool, time.Duration) {
+
+	if l.limit.Rate <= 0 {
+		return true, 0
+	}
+
+	l.Lock()
+	defer l.Unlock()
+
+	now := time.Now()
+	burst := float64(l.limit.Burst)
+
+	l.calls++
+	if l.calls%1024 == 0 {
+		l.prune(now)
+	}
+
+	b, ok := l.buckets[id]
+	if !ok {
+		b = &bucket{tokens: burst, last: now}
+		l.buckets[id] = b
+	}
+
+	b.tokens = math.Min(burst, b.tokens+now.Sub(b.last).Seconds()*l.limit.Rate)
+	b.last = now
+
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / l.limit.Rate * float64(time.Second))
+	}
+
+	b.tokens--
+
+	return true, 0
+}
+
+// prune forgets the buckets that are full again.
+func (l *rateLimiter) prune(now time.Time) {
+
+	for id, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*l.limit.Rate >= float64(l.limit.Burst) {
+			delete(l.buckets, id)
+		}
+	}
+}
+
+// withRateLimit returns a handler calling the given one if the
+// device doing the request did not exceed the given rate limit.
+func withRateLimit(limiter *rateLimiter, handler func(http.ResponseWriter, *http.Request)) func(http.ResponseWriter, *http.Request) {
+
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		ok, wait := limiter.Allow(computeID(r))
+		if !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			http.Error(w, "too many requests", http.StatusTooManyRequests)
+			return
+		}
+
+		handler(w, r)
+	}
+}
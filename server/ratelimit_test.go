@@ -0,0 +1,104 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRateLimiterAllow(t *testing.T) {
+
+	tests := []struct {
+		name      string
+		limit     RateLimit
+		requests  int
+		wantAllow int
+	}{
+		{"disabled", RateLimit{}, 10, 10},
+		{"burst", RateLimit{Rate: 0.001, Burst: 3}, 10, 3},
+		{"burst of at least one", RateLimit{Rate: 0.001}, 10, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			l := newRateLimiter(tt.limit)
+
+			var allowed int
+			for i := 0; i < tt.requests; i++ {
+				ok, wait := l.Allow("laptop")
+				if ok {
+					allowed++
+					continue
+				}
+				if wait <= 0 {
+					t.Fatalf("refused request without delay")
+				}
+			}
+
+			if allowed != tt.wantAllow {
+				t.Fatalf("got %d allowed requests, want %d", allowed, tt.wantAllow)
+			}
+
+			// The devices have their own buckets.
+			if ok, _ := l.Allow("phone"); !ok {
+				t.Fatalf("another device is rate limited")
+			}
+		})
+	}
+}
+
+func TestRateLimiterRefill(t *testing.T) {
+
+	l := newRateLimiter(RateLimit{Rate: 1000, Burst: 1})
+
+	if ok, _ := l.Allow("laptop"); !ok {
+		t.Fatalf("first request refused")
+	}
+
+	ok, wait := l.Allow("laptop")
+	if ok {
+		t.Fatalf("second request allowed")
+	}
+	if wait > time.Millisecond {
+		t.Fatalf("got delay %s, want at most 1ms", wait)
+	}
+
+	time.Sleep(2 * time.Millisecond)
+
+	if ok, _ := l.Allow("laptop"); !ok {
+		t.Fatalf("request refused after refill")
+	}
+}
+
+func TestWithRateLimit(t *testing.T) {
+
+	handler := withRateLimit(newRateLimiter(RateLimit{Rate: 0.5, Burst: 1}), func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	tests := []struct {
+		name           string
+		wantStatus     int
+		wantRetryAfter string
+	}{
+		{"allowed", http.StatusNoContent, ""},
+		{"rate limited", http.StatusTooManyRequests, "2"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			w := httptest.NewRecorder()
+			handler(w, publishRequest(nil, nil))
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("got status %d, want %d", w.Code, tt.wantStatus)
+			}
+			if got := w.Header().Get("Retry-After"); got != tt.wantRetryAfter {
+				t.Fatalf("got Retry-After %q, want %q", got, tt.wantRetryAfter)
+			}
+		})
+	}
+}
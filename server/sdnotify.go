@@ -0,0 +1,65 @@
+package server
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+// sdNotify sends the given state to the service manager, if
+// the server was started by systemd with a notify socket.
+func sdNotify(state string) {
+
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return
+	}
+
+	// Abstract sockets start with @.
+	if socket[0] == '@' {
+		socket = "\x00" + socket[1:]
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		slog.Error("unable to connect to notify socket", "error", err)
+		return
+	}
+	defer conn.Close() // nolint
+
+	if _, err := conn.Write([]byte(state)); err != nil {
+		slog.Error("unable to notify service manager", "error", err)
+	}
+}
+
+// sdWatchdog pings the systemd watchdog at half the configured
+// interval until the context is canceled. It does nothing if the
+// watchdog is not enabled for the server.
+func sdWatchdog(ctx context.Context, healthy func() bool) {
+
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return
+	}
+
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return
+	}
+
+	ticker := time.NewTicker(time.Duration(usec) * time.Microsecond / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if healthy() {
+				sdNotify("WATCHDOG=1")
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// notifySocket listens on a notify socket at the given address,
+// and makes it the one used by sdNotify.
+func notifySocket(t *testing.T, name string, env string) *net.UnixConn {
+
+	t.Helper()
+
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: name, Net: "unixgram"})
+	if err != nil {
+		t.Fatalf("unable to listen on notify socket: %s", err)
+	}
+	t.Cleanup(func() { conn.Close() }) // nolint
+
+	t.Setenv("NOTIFY_SOCKET", env)
+
+	return conn
+}
+
+func readNotification(t *testing.T, conn *net.UnixConn) string {
+
+	t.Helper()
+
+	buf := make([]byte, 256)
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatalf("no notification received: %s", err)
+	}
+
+	return string(buf[:n])
+}
+
+func TestSdNotify(t *testing.T) {
+
+	path := filepath.Join(t.TempDir(), "notify")
+	abstract := fmt.Sprintf("netboard-test-%d", time.Now().UnixNano())
+
+	tests := []struct {
+		name   string
+		socket string
+		env    string
+	}{
+		{"path", path, path},
+		{"abstract", "\x00" + abstract, "@" + abstract},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			if tt.env[0] == '@' && runtime.GOOS != "linux" {
+				t.Skip("abstract sockets are only supported on linux")
+			}
+
+			conn := notifySocket(t, tt.socket, tt.env)
+
+			sdNotify("READY=1")
+
+			if got := readNotification(t, conn); got != "READY=1" {
+				t.Fatalf("got notification %q", got)
+			}
+		})
+	}
+}
+
+func TestSdWatchdog(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		usec     string
+		pid      string
+		healthy  bool
+		wantPing bool
+	}{
+		{"healthy", "20000", "", true, true},
+		{"healthy in this process", "20000", strconv.Itoa(os.Getpid()), true, true},
+		{"unhealthy", "20000", "", false, false},
+		{"other process", "20000", "1", true, false},
+		{"disabled", "", "", true, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			path := filepath.Join(t.TempDir(), "notify")
+			conn := notifySocket(t, path, path)
+			t.Setenv("WATCHDOG_USEC", tt.usec)
+			t.Setenv("WATCHDOG_PID", tt.pid)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			go sdWatchdog(ctx, func() bool { return tt.healthy })
+
+			buf := make([]byte, 256)
+			_ = conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+			n, err := conn.Read(buf)
+
+			if !tt.wantPing {
+				if err == nil {
+					t.Fatalf("got notification %q, want none", buf[:n])
+				}
+				return
+			}
+
+			if err != nil || string(buf[:n]) != "WATCHDOG=1" {
+				t.Fatalf("got notification %q, %v", buf[:n], err)
+			}
+		})
+	}
+}
@@ -4,45 +4,183 @@ import (
 	"context"
 	"crypto/tls"
 	"errors"
+	"fmt"
 	"net"
 	"net/http"
+	"sync/atomic"
 	"time"
+
+	"github.com/primalmotion/netboard/protocol"
 )
 
 // Serve starts the server that will handle and dispatch changes
 // to of the clipboard.
-func Serve(ctx context.Context, listenAddr string, tlsConf *tls.Config) error {
+func Serve(ctx context.Context, listenAddr string, tlsConf *tls.Config, options ...Option) error {
+
+	cfg := newConfig()
+	for _, opt := range options {
+		opt(&cfg)
+	}
+
+	if cfg.blobDir != "" {
+		cfg.limits.BlobThreshold = cfg.blobThreshold
+	}
+	cfg.limits.Encodings = protocol.Encodings
+
+	live := newSettings(cfg.limits, cfg.revoked)
+
+	tlsConf = revocableTLSConfig(tlsConf, live)
+	if cfg.audit != nil {
+		tlsConf = auditedTLSConfig(tlsConf, cfg.audit)
+	}
 
 	server := http.Server{
-		Addr:      listenAddr,
 		TLSConfig: tlsConf,
+		Handler:   withRevocation(live, cfg.audit, http.DefaultServeMux),
 		BaseContext: func(net.Listener) context.Context {
 			return ctx
 		},
 	}
 
-	dispatch := newDispatcher()
-	http.HandleFunc("/publish", makePublishHandler(dispatch))
-	http.HandleFunc("/subscribe/chunked", makeSubscribeChunkedHandler(dispatch))
-	http.HandleFunc("/subscribe/ws", makeSubscribeWSHandler(dispatch))
+	if cfg.backplane != nil && cfg.blobDir != "" && !cfg.sharedBlobs {
+		return errors.New("blobs require a blob directory shared by the replicas when using a backplane")
+	}
+
+	publishLimiter := newRateLimiter(cfg.publishRate)
+	connectionLimiter := newRateLimiter(cfg.connectionRate)
+
+	var blobs *blobStore
+	if cfg.blobDir != "" {
+		var err error
+		if blobs, err = newBlobStore(cfg.blobDir); err != nil {
+			return err
+		}
+		go collectBlobs(ctx, blobs, cfg.store)
+		http.HandleFunc("/blobs/", makeBlobsHandler(blobs, live, publishLimiter))
+	}
+
+	dispatch := newDispatcher(cfg.store, cfg.compressionThreshold)
+	if cfg.backplane != nil {
+		var err error
+		if dispatch.replicaID, err = newReplicaID(); err != nil {
+			return err
+		}
+		dispatch.backplane = cfg.backplane
+		dispatch.outgoing = make(chan []byte, backplaneQueueSize)
+		go sendBackplane(ctx, dispatch)
+		if err := listenBackplane(ctx, dispatch, blobs); err != nil {
+			return err
+		}
+	}
+	if err := restoreExpirations(dispatch); err != nil {
+		return err
+	}
+
+	if cfg.reload != nil {
+		go func() {
+			for {
+				select {
+				case next, ok := <-cfg.reload:
+					if !ok {
+						return
+					}
+					live.apply(next, cfg.audit)
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+
+	var hooks *webhooks
+	if len(cfg.webhooks) > 0 {
+		var err error
+		if hooks, err = newWebhooks(ctx, cfg.webhooks, cfg.deadLetterPath); err != nil {
+			return err
+		}
+		defer hooks.Close() // nolint
+	}
+
+	if len(cfg.peers) > 0 {
+		if cfg.serverID == "" && len(tlsConf.Certificates) > 0 {
+			cfg.serverID = protocol.Fingerprint(tlsConf.Certificates[0].Certificate[0])
+		}
+		for _, peer := range cfg.peers {
+			go federate(ctx, dispatch, blobs, cfg.serverID, cfg.limits, peer)
+		}
+	}
+
+	http.HandleFunc("/publish", withRateLimit(publishLimiter, makePublishHandler(dispatch, blobs, hooks, live, cfg.serverID, cfg.audit)))
+	http.HandleFunc("/limits", makeLimitsHandler(live))
+	http.HandleFunc("/history", makeHistoryHandler(dispatch))
+	http.HandleFunc("/history/", makeHistoryHandler(dispatch))
+	http.HandleFunc("/subscribe/chunked", withRateLimit(connectionLimiter, makeSubscribeChunkedHandler(dispatch, live, cfg.audit)))
+	http.HandleFunc("/subscribe/ws", withRateLimit(connectionLimiter, makeSubscribeWSHandler(dispatch, live, cfg.audit)))
+
+	var ready atomic.Bool
+
+	http.HandleFunc("/healthz", makeHealthzHandler())
+	http.HandleFunc("/readyz", makeReadyzHandler(dispatch, &ready))
+	http.HandleFunc("/version", makeVersionHandler(cfg.version, cfg.commit))
+
+	listener, err := net.Listen("tcp", listenAddr)
+	if err != nil {
+		return fmt.Errorf("unable to listen: %w", err)
+	}
 
 	// Start the server in a go routine
-	srvErrCh := make(chan error)
+	srvErrCh := make(chan error, 2)
 	go func() {
-		err := server.ListenAndServeTLS("", "")
+		err := server.ServeTLS(listener, "", "")
 		if !errors.Is(err, http.ErrServerClosed) {
 			srvErrCh <- err
 		}
 	}()
 
+	var healthServer *http.Server
+	if cfg.healthListenAddr != "" {
+
+		mux := http.NewServeMux()
+		mux.HandleFunc("/healthz", makeHealthzHandler())
+		mux.HandleFunc("/readyz", makeReadyzHandler(dispatch, &ready))
+		mux.HandleFunc("/version", makeVersionHandler(cfg.version, cfg.commit))
+
+		healthServer = &http.Server{
+			Addr:              cfg.healthListenAddr,
+			Handler:           mux,
+			ReadHeaderTimeout: 10 * time.Second,
+		}
+
+		go func() {
+			err := healthServer.ListenAndServe()
+			if !errors.Is(err, http.ErrServerClosed) {
+				srvErrCh <- fmt.Errorf("unable to serve health endpoints: %w", err)
+			}
+		}()
+	}
+
+	ready.Store(true)
+	sdNotify("READY=1")
+	audit(cfg.audit, AuditEvent{Event: AuditAdmin, Action: "start"})
+	go sdWatchdog(ctx, func() bool {
+		_, err := latest(dispatch.store)
+		return err == nil
+	})
+
 	// Wait for a shutdown indicator to either return the
 	// error or gracefully shutdown the server
 	select {
 	case err := <-srvErrCh:
 		return err
 	case <-ctx.Done():
+		ready.Store(false)
+		sdNotify("STOPPING=1")
+		audit(cfg.audit, AuditEvent{Event: AuditAdmin, Action: "stop"})
 		closeCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 		defer cancel()
+		if healthServer != nil {
+			_ = healthServer.Shutdown(closeCtx)
+		}
 		return server.Shutdown(closeCtx)
 	}
 }
@@ -0,0 +1,170 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/primalmotion/netboard/protocol"
+)
+
+var errRevoked = errors.New("revoked client certificate")
+
+// Settings are the settings of the server
+// that can change while it runs. See OptReload.
+type Settings struct {
+
+	// Limits are the limits enforced on published items.
+	// Their BlobThreshold and Encodings are ignored, as
+	// they depend on the server itself.
+	Limits protocol.Limits
+
+	// Revoked are the fingerprints or common names
+	// of the client certificates that are rejected.
+	Revoked []string
+}
+
+// settings holds the current Settings of a running server.
+// The changed channel is closed and replaced every time they
+// change, so the long running requests can check them again.
+type settings struct {
+	limits  protocol.Limits
+	revoked []string
+	changed chan struct{}
+
+	sync.RWMutex
+}
+
+func newSettings(limits protocol.Limits, revoked []string) *settings {
+	return &settings{
+		limits:  limits,
+		revoked: revoked,
+		changed: make(chan struct{}),
+	}
+}
+
+// Limits returns the current limits.
+func (s *settings) Limits() protocol.Limits {
+	s.RLock()
+	defer s.RUnlock()
+
+	return s.limits
+}
+
+// Revoked returns true if the given client certificate is
+// revoked, either by its fingerprint or its common name.
+func (s *settings) Revoked(cert *x509.Certificate) bool {
+	s.RLock()
+	defer s.RUnlock()
+
+	return slices.Contains(s.revoked, protocol.Fingerprint(cert.Raw)) ||
+		slices.Contains(s.revoked, cert.Subject.CommonName)
+}
+
+// Changed returns a channel closed when the settings change.
+func (s *settings) Changed() <-chan struct{} {
+	s.RLock()
+	defer s.RUnlock()
+
+	return s.changed
+}
+
+// apply replaces the current settings by the given ones, and
+// records the reload, the limit changes and the revocations in
+// the sink.
+func (s *settings) apply(next Settings, sink AuditSink) {
+
+	s.Lock()
+
+	limits := next.Limits
+	limits.BlobThreshold = s.limits.BlobThreshold
+	limits.Encodings = s.limits.Encodings
+
+	previous, revoked := s.limits, s.revoked
+	s.limits, s.revoked = limits, slices.Clone(next.Revoked)
+
+	close(s.changed)
+	s.changed = make(chan struct{})
+
+	s.Unlock()
+
+	slog.Info("settings reloaded", "revoked", len(next.Revoked))
+	audit(sink, AuditEvent{Event: AuditAdmin, Action: "reload"})
+
+	if previous.MaxSize != limits.MaxSize || !slices.Equal(previous.MIME, limits.MIME) {
+		slog.Info("limits changed", "limits", describeLimits(limits))
+		audit(sink, AuditEvent{Event: AuditAdmin, Action: "limits", Reason: describeLimits(limits)})
+	}
+
+	for _, device := range next.Revoked {
+		if !slices.Contains(revoked, device) {
+			slog.Info("certificate revoked", "device", device)
+			audit(sink, AuditEvent{Event: AuditAdmin, Action: "revoke", Device: device})
+		}
+	}
+
+	for _, device := range revoked {
+		if !slices.Contains(next.Revoked, device) {
+			slog.Info("certificate reinstated", "device", device)
+			audit(sink, AuditEvent{Event: AuditAdmin, Action: "reinstate", Device: device})
+		}
+	}
+}
+
+// describeLimits returns a short description of
+// the given limits, like "max size 1024, image/* 2048".
+func describeLimits(limits protocol.Limits) string {
+
+	parts := []string{fmt.Sprintf("max size %d", limits.MaxSize)}
+	for _, m := range limits.MIME {
+		parts = append(parts, fmt.Sprintf("%s %d", m.Type, m.MaxSize))
+	}
+
+	return strings.Join(parts, ", ")
+}
+
+// revocableTLSConfig returns a copy of the given config
+// rejecting the connections of the revoked client certificates.
+func revocableTLSConfig(tlsConf *tls.Config, live *settings) *tls.Config {
+
+	conf := tlsConf.Clone()
+	conf.VerifyConnection = func(cs tls.ConnectionState) error {
+
+		if len(cs.PeerCertificates) > 0 && live.Revoked(cs.PeerCertificates[0]) {
+			return errRevoked
+		}
+
+		if tlsConf.VerifyConnection != nil {
+			return tlsConf.VerifyConnection(cs)
+		}
+
+		return nil
+	}
+
+	return conf
+}
+
+// withRevocation rejects the requests of the revoked client
+// certificates. They are already rejected when connecting, but
+// the connections opened before their revocation stay alive.
+func withRevocation(live *settings, sink AuditSink, handler http.Handler) http.Handler {
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		if r.TLS != nil && len(r.TLS.PeerCertificates) > 0 && live.Revoked(r.TLS.PeerCertificates[0]) {
+			evt := newAuditEvent(AuditReject, r)
+			evt.Reason = errRevoked.Error()
+			audit(sink, evt)
+			http.Error(w, errRevoked.Error(), http.StatusForbidden)
+			return
+		}
+
+		handler.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,192 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/primalmotion/netboard/protocol"
+)
+
+func TestSettingsApply(t *testing.T) {
+
+	initial := protocol.Limits{
+		MaxSize:       1024,
+		BlobThreshold: 512,
+		Encodings:     protocol.Encodings,
+	}
+
+	tests := []struct {
+		name        string
+		next        Settings
+		wantActions []string
+		wantDevices []string
+		wantLimits  string
+	}{
+		{
+			"nothing changed",
+			Settings{Limits: protocol.Limits{MaxSize: 1024}, Revoked: []string{"laptop"}},
+			[]string{"reload"},
+			[]string{""},
+			"",
+		},
+		{
+			"limits changed",
+			Settings{Limits: protocol.Limits{MaxSize: 2048, MIME: []protocol.MIMELimit{{Type: "image/*", MaxSize: 4096}}}, Revoked: []string{"laptop"}},
+			[]string{"reload", "limits"},
+			[]string{"", ""},
+			"max size 2048, image/* 4096",
+		},
+		{
+			"certificate revoked",
+			Settings{Limits: protocol.Limits{MaxSize: 1024}, Revoked: []string{"laptop", "phone"}},
+			[]string{"reload", "revoke"},
+			[]string{"", "phone"},
+			"",
+		},
+		{
+			"certificate reinstated",
+			Settings{Limits: protocol.Limits{MaxSize: 1024}},
+			[]string{"reload", "reinstate"},
+			[]string{"", "laptop"},
+			"",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			sink := &memoryAuditSink{}
+			live := newSettings(initial, []string{"laptop"})
+			changed := live.Changed()
+
+			live.apply(tt.next, sink)
+
+			select {
+			case <-changed:
+			default:
+				t.Fatalf("changes not notified")
+			}
+
+			var actions, devices []string
+			for _, evt := range sink.Events() {
+				if evt.Event != AuditAdmin {
+					t.Fatalf("unexpected event: %+v", evt)
+				}
+				actions = append(actions, evt.Action)
+				devices = append(devices, evt.Device)
+				if evt.Action == "limits" && evt.Reason != tt.wantLimits {
+					t.Fatalf("limits event has reason %q, want %q", evt.Reason, tt.wantLimits)
+				}
+			}
+
+			if !reflect.DeepEqual(actions, tt.wantActions) {
+				t.Fatalf("got actions %v, want %v", actions, tt.wantActions)
+			}
+			if !reflect.DeepEqual(devices, tt.wantDevices) {
+				t.Fatalf("got devices %v, want %v", devices, tt.wantDevices)
+			}
+
+			// The limits depending on the server are kept.
+			limits := live.Limits()
+			if limits.MaxSize != tt.next.Limits.MaxSize || limits.BlobThreshold != 512 || !reflect.DeepEqual(limits.Encodings, protocol.Encodings) {
+				t.Fatalf("unexpected limits: %+v", limits)
+			}
+		})
+	}
+}
+
+func TestRevocation(t *testing.T) {
+
+	ca := newTestCA(t, "netboard ca")
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(ca.cert)
+
+	later := time.Now().Add(time.Hour)
+	laptop := ca.issue(t, "laptop", later, x509.ExtKeyUsageClientAuth)
+	phone := ca.issue(t, "phone", later, x509.ExtKeyUsageClientAuth)
+
+	live := newSettings(protocol.Limits{}, nil)
+
+	// Without audit, the standard verification runs first.
+	conf := revocableTLSConfig(&tls.Config{
+		Certificates: []tls.Certificate{ca.issue(t, "127.0.0.1", later, x509.ExtKeyUsageServerAuth)},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    clientCAs,
+	}, live)
+
+	laptopCert, _ := x509.ParseCertificate(laptop.Certificate[0])
+	phoneCert, _ := x509.ParseCertificate(phone.Certificate[0])
+
+	sink := &memoryAuditSink{}
+	dispatch := newDispatcher(NewMemoryStorage(Retention{}), 0)
+	handler := withRevocation(live, sink, http.HandlerFunc(makeSubscribeChunkedHandler(dispatch, live, nil)))
+
+	// The laptop subscribes before being revoked.
+	req := httptest.NewRequest(http.MethodGet, "/subscribe/chunked", nil)
+	req.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{laptopCert}}
+
+	done := make(chan struct{})
+	go func() {
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+		close(done)
+	}()
+
+	for dispatch.GetChannel(protocol.Fingerprint(laptopCert.Raw)) == nil {
+		time.Sleep(time.Millisecond)
+	}
+
+	live.apply(Settings{Revoked: []string{"laptop"}}, nil)
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("revoked subscriber not disconnected")
+	}
+
+	tests := []struct {
+		name       string
+		cert       tls.Certificate
+		parsed     *x509.Certificate
+		wantErr    bool
+		wantStatus int
+	}{
+		{"revoked", laptop, laptopCert, true, http.StatusForbidden},
+		{"not revoked", phone, phoneCert, false, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			err := handshake(t, conf, []tls.Certificate{tt.cert})
+			if tt.wantErr != (err != nil) {
+				t.Fatalf("got error %v, want error %t", err, tt.wantErr)
+			}
+			if err != nil && !strings.Contains(err.Error(), errRevoked.Error()) {
+				t.Fatalf("got error %s, want %s", err, errRevoked)
+			}
+
+			// The connections opened before the revocation
+			// are rejected on their next request.
+			req := httptest.NewRequest(http.MethodGet, "/limits", nil)
+			req.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{tt.parsed}}
+			w := httptest.NewRecorder()
+
+			withRevocation(live, sink, http.HandlerFunc(makeLimitsHandler(live))).ServeHTTP(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("got status %d, want %d", w.Code, tt.wantStatus)
+			}
+		})
+	}
+
+	events := sink.Events()
+	if len(events) != 1 || events[0].Event != AuditReject || events[0].CN != "laptop" || events[0].Reason != errRevoked.Error() {
+		t.Fatalf("unexpected events: %+v", events)
+	}
+}
@@ -0,0 +1,145 @@
+package server
+
+import (
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/primalmotion/netboard/protocol"
+)
+
+// A Storage stores the history of the published items.
+type Storage interface {
+
+	// Put stores the given item. If an item with the same
+	// ID is already stored, it is replaced.
+	Put(*protocol.Item) error
+
+	// Delete removes the item with the given ID.
+	Delete(id string) error
+
+	// List returns at most limit stored items, most
+	// recent first. If limit is 0, all items are returned.
+	List(limit int) ([]*protocol.Item, error)
+
+	// Close releases the resources used by the storage.
+	Close() error
+}
+
+// Retention configures how long a Storage keeps the items.
+type Retention struct {
+
+	// MaxItems is the maximum number of items to keep.
+	// 0 means no limit.
+	MaxItems int
+
+	// MaxAge is the maximum age of the items to keep.
+	// 0 means no limit.
+	MaxAge time.Duration
+}
+
+type memoryStorage struct {
+	items     []*protocol.Item
+	retention Retention
+
+	sync.RWMutex
+}
+
+// NewMemoryStorage returns a Storage keeping the items in memory.
+// The items are lost when the server stops.
+func NewMemoryStorage(retention Retention) Storage {
+	return &memoryStorage{
+		retention: retention,
+	}
+}
+
+func (s *memoryStorage) Put(item *protocol.Item) error {
+
+	s.Lock()
+	defer s.Unlock()
+
+	s.remove(item.ID)
+
+	// The items are kept ordered by time, as the replayed
+	// ones may be older than the ones already stored.
+	i := len(s.items)
+	for i > 0 && s.items[i-1].Time.After(item.Time) {
+		i--
+	}
+	s.items = slices.Insert(s.items, i, item)
+
+	s.prune()
+
+	return nil
+}
+
+func (s *memoryStorage) Delete(id string) error {
+
+	s.Lock()
+	defer s.Unlock()
+
+	s.remove(id)
+
+	return nil
+}
+
+func (s *memoryStorage) List(limit int) ([]*protocol.Item, error) {
+
+	s.Lock()
+	defer s.Unlock()
+
+	s.prune()
+
+	if limit <= 0 || limit > len(s.items) {
+		limit = len(s.items)
+	}
+
+	out := make([]*protocol.Item, 0, limit)
+	for i := len(s.items) - 1; i >= len(s.items)-limit; i-- {
+		out = append(out, s.items[i])
+	}
+
+	return out, nil
+}
+
+func (s *memoryStorage) Close() error {
+	return nil
+}
+
+func (s *memoryStorage) remove(id string) {
+
+	for i, item := range s.items {
+		if item.ID == id {
+			s.items = append(s.items[:i], s.items[i+1:]...)
+			return
+		}
+	}
+}
+
+func (s *memoryStorage) prune() {
+
+	if s.retention.MaxAge > 0 {
+		limit := time.Now().Add(-s.retention.MaxAge)
+		i := 0
+		for i < len(s.items) && s.items[i].Time.Before(limit) {
+			i++
+		}
+		s.items = s.items[i:]
+	}
+
+	if s.retention.MaxItems > 0 && len(s.items) > s.retention.MaxItems {
+		s.items = s.items[len(s.items)-s.retention.MaxItems:]
+	}
+}
+
+// latest returns the most recent item
+// of the given storage, or nil.
+func latest(s Storage) (*protocol.Item, error) {
+
+	items, err := s.List(1)
+	if err != nil || len(items) == 0 {
+		return nil, err
+	}
+
+	return items[0], nil
+}
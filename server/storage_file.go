@@ -0,0 +1,331 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/primalmotion/netboard/protocol"
+)
+
+const (
+	fileOpPut    = "put"
+	fileOpDelete = "delete"
+
+	// compactionRatio is the number of records in the log,
+	// relative to the number of stored items, above which
+	// the log is compacted.
+	compactionRatio = 4
+
+	// compactionMinRecords is the minimum number of records
+	// in the log before considering a compaction.
+	compactionMinRecords = 128
+)
+
+type fileRecord struct {
+	Op   string         `json:"op"`
+	ID   string         `json:"id,omitempty"`
+	Item *protocol.Item `json:"item,omitempty"`
+}
+
+type fileStorage struct {
+	path    string
+	mem     *memoryStorage
+	aead    cipher.AEAD
+	file    *os.File
+	records int
+
+	// expiring are the IDs of the items with an expiration
+	// time written in the log since the last compaction.
+	expiring map[string]struct{}
+
+	sync.Mutex
+}
+
+// NewFileStorage returns a Storage keeping the items in memory and
+// persisting them in an append-only log at the given path, so they
+// survive restarts. The log is compacted when it grows too big
+// compared to the number of retained items. If key is not empty,
+// each record is encrypted with AES-GCM using a key derived from it.
+// The log is also compacted when an item with an expiration time is
+// deleted, so its content does not outlive it on the disk.
+func NewFileStorage(path string, retention Retention, key []byte) (Storage, error) {
+
+	s := &fileStorage{
+		path:     path,
+		mem:      NewMemoryStorage(retention).(*memoryStorage),
+		expiring: map[string]struct{}{},
+	}
+
+	if len(key) > 0 {
+		k := sha256.Sum256(key)
+		block, err := aes.NewCipher(k[:])
+		if err != nil {
+			return nil, fmt.Errorf("unable to prepare cipher: %w", err)
+		}
+		if s.aead, err = cipher.NewGCM(block); err != nil {
+			return nil, fmt.Errorf("unable to prepare cipher: %w", err)
+		}
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, fmt.Errorf("unable to create storage directory: %w", err)
+	}
+
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+
+	if err := s.compact(); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+func (s *fileStorage) Put(item *protocol.Item) error {
+
+	s.Lock()
+	defer s.Unlock()
+
+	if err := s.mem.Put(item); err != nil {
+		return err
+	}
+
+	return s.append(fileRecord{Op: fileOpPut, Item: item})
+}
+
+func (s *fileStorage) Delete(id string) error {
+
+	s.Lock()
+	defer s.Unlock()
+
+	if err := s.mem.Delete(id); err != nil {
+		return err
+	}
+
+	if _, ok := s.expiring[id]; ok {
+		return s.compact()
+	}
+
+	return s.append(fileRecord{Op: fileOpDelete, ID: id})
+}
+
+func (s *fileStorage) List(limit int) ([]*protocol.Item, error) {
+	return s.mem.List(limit)
+}
+
+func (s *fileStorage) Close() error {
+
+	s.Lock()
+	defer s.Unlock()
+
+	if s.file == nil {
+		return nil
+	}
+
+	err := s.file.Close()
+	s.file = nil
+
+	return err
+}
+
+func (s *fileStorage) load() error {
+
+	f, err := os.Open(s.path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return fmt.Errorf("unable to open storage: %w", err)
+	}
+	defer f.Close() // nolint
+
+	reader := bufio.NewReader(f)
+
+	for n := 1; ; n++ {
+
+		line, err := reader.ReadBytes('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return fmt.Errorf("unable to read storage: %w", err)
+		}
+
+		if line = bytes.TrimSpace(line); len(line) > 0 {
+
+			rec, derr := s.decode(line)
+			if derr != nil {
+				// A truncated last line is the sign of
+				// a crash while writing. We can skip it.
+				if errors.Is(err, io.EOF) {
+					break
+				}
+				return fmt.Errorf("unable to decode storage record %d: %w", n, derr)
+			}
+
+			switch rec.Op {
+			case fileOpPut:
+				if rec.Item != nil {
+					_ = s.mem.Put(rec.Item)
+				}
+			case fileOpDelete:
+				_ = s.mem.Delete(rec.ID)
+			}
+		}
+
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+	}
+
+	return nil
+}
+
+// compact rewrites the log so it only contains
+// the currently retained items.
+func (s *fileStorage) compact() error {
+
+	items, err := s.mem.List(0)
+	if err != nil {
+		return err
+	}
+
+	expiring := map[string]struct{}{}
+
+	buf := bytes.NewBuffer(nil)
+	for i := len(items) - 1; i >= 0; i-- {
+		if items[i].Expires != nil {
+			expiring[items[i].ID] = struct{}{}
+		}
+		line, err := s.encode(fileRecord{Op: fileOpPut, Item: items[i]})
+		if err != nil {
+			return err
+		}
+		buf.Write(line)
+	}
+
+	tmp := s.path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("unable to create compacted storage: %w", err)
+	}
+
+	if _, err := f.Write(buf.Bytes()); err != nil {
+		f.Close() // nolint
+		return fmt.Errorf("unable to write compacted storage: %w", err)
+	}
+
+	if err := f.Sync(); err != nil {
+		f.Close() // nolint
+		return fmt.Errorf("unable to sync compacted storage: %w", err)
+	}
+
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("unable to close compacted storage: %w", err)
+	}
+
+	if err := os.Rename(tmp, s.path); err != nil {
+		return fmt.Errorf("unable to replace storage: %w", err)
+	}
+
+	if s.file != nil {
+		s.file.Close() // nolint
+	}
+
+	if s.file, err = os.OpenFile(s.path, os.O_APPEND|os.O_WRONLY, 0600); err != nil {
+		return fmt.Errorf("unable to open storage: %w", err)
+	}
+
+	s.records = len(items)
+	s.expiring = expiring
+
+	return nil
+}
+
+func (s *fileStorage) append(rec fileRecord) error {
+
+	if s.file == nil {
+		return fmt.Errorf("storage is closed")
+	}
+
+	line, err := s.encode(rec)
+	if err != nil {
+		return err
+	}
+
+	if _, err := s.file.Write(line); err != nil {
+		return fmt.Errorf("unable to write storage record: %w", err)
+	}
+
+	s.records++
+	if rec.Item != nil && rec.Item.Expires != nil {
+		s.expiring[rec.Item.ID] = struct{}{}
+	}
+
+	items, err := s.mem.List(0)
+	if err != nil {
+		return err
+	}
+
+	if s.records > compactionMinRecords && s.records > compactionRatio*len(items) {
+		return s.compact()
+	}
+
+	return nil
+}
+
+func (s *fileStorage) encode(rec fileRecord) ([]byte, error) {
+
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return nil, fmt.Errorf("unable to encode storage record: %w", err)
+	}
+
+	if s.aead != nil {
+		nonce := make([]byte, s.aead.NonceSize())
+		if _, err := rand.Read(nonce); err != nil {
+			return nil, fmt.Errorf("unable to generate nonce: %w", err)
+		}
+		data = []byte(base64.StdEncoding.EncodeToString(s.aead.Seal(nonce, nonce, data, nil)))
+	}
+
+	return append(data, '\n'), nil
+}
+
+func (s *fileStorage) decode(line []byte) (fileRecord, error) {
+
+	rec := fileRecord{}
+
+	if s.aead != nil {
+
+		sealed, err := base64.StdEncoding.DecodeString(string(line))
+		if err != nil {
+			return rec, fmt.Errorf("unable to decode encrypted record: %w", err)
+		}
+
+		if len(sealed) < s.aead.NonceSize() {
+			return rec, fmt.Errorf("encrypted record is too short")
+		}
+
+		nonce, ciphertext := sealed[:s.aead.NonceSize()], sealed[s.aead.NonceSize():]
+		if line, err = s.aead.Open(nil, nonce, ciphertext, nil); err != nil {
+			return rec, fmt.Errorf("unable to decrypt record (wrong key?): %w", err)
+		}
+	}
+
+	if err := json.Unmarshal(line, &rec); err != nil {
+		return rec, err
+	}
+
+	return rec, nil
+}
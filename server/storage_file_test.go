@@ -0,0 +1,296 @@
+package server
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/primalmotion/netboard/protocol"
+)
+
+func TestFileStorageReopen(t *testing.T) {
+
+	key := []byte("a storage key")
+
+	tests := []struct {
+		name      string
+		writeKey  []byte
+		readKey   []byte
+		tamper    func([]byte) []byte
+		wantErr   string
+		wantItems []string
+	}{
+		{
+			"plain",
+			nil, nil, nil,
+			"",
+			[]string{"third", "second"},
+		},
+		{
+			"encrypted",
+			key, key, nil,
+			"",
+			[]string{"third", "second"},
+		},
+		{
+			"wrong key",
+			key, []byte("another key"), nil,
+			"wrong key",
+			nil,
+		},
+		{
+			"missing key",
+			key, nil, nil,
+			"unable to decode storage record 1",
+			nil,
+		},
+		{
+			"encrypted without key",
+			nil, key, nil,
+			"unable to decode encrypted record",
+			nil,
+		},
+		{
+			"tampered record",
+			key, key,
+			func(data []byte) []byte {
+				// Changing a character of the ciphertext
+				// keeps valid base64 but breaks the tag.
+				data = bytes.Clone(data)
+				if data[40] == 'A' {
+					data[40] = 'B'
+				} else {
+					data[40] = 'A'
+				}
+				return data
+			},
+			"unable to decrypt record",
+			nil,
+		},
+		{
+			"truncated last record",
+			key, key,
+			func(data []byte) []byte {
+				return data[:len(data)-10]
+			},
+			"",
+			[]string{"second", "first"},
+		},
+		{
+			"corrupted record",
+			key, key,
+			func(data []byte) []byte {
+				return append([]byte("garbage\n"), data...)
+			},
+			"unable to decode storage record 1",
+			nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			path := filepath.Join(t.TempDir(), "history.log")
+
+			s, err := NewFileStorage(path, Retention{MaxItems: 2}, tt.writeKey)
+			if err != nil {
+				t.Fatalf("unable to create storage: %s", err)
+			}
+
+			now := time.Now()
+			for i, data := range []string{"first", "second", "third"} {
+				if err := s.Put(protocol.NewItem([]byte(data), now.Add(time.Duration(i)*time.Second))); err != nil {
+					t.Fatalf("unable to put item: %s", err)
+				}
+			}
+			if err := s.Close(); err != nil {
+				t.Fatalf("unable to close storage: %s", err)
+			}
+
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatalf("unable to read storage: %s", err)
+			}
+
+			// Nothing of the items is readable in the
+			// file when the records are encrypted.
+			if plain := bytes.Contains(data, []byte("second")) || bytes.Contains(data, []byte(`"op"`)); plain == (tt.writeKey != nil) {
+				t.Fatalf("file contains plain records: %t", plain)
+			}
+
+			if tt.tamper != nil {
+				if err := os.WriteFile(path, tt.tamper(data), 0600); err != nil {
+					t.Fatalf("unable to tamper storage: %s", err)
+				}
+			}
+
+			s, err = NewFileStorage(path, Retention{MaxItems: 2}, tt.readKey)
+
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unable to reopen storage: %s", err)
+			}
+			defer s.Close() // nolint
+
+			items, err := s.List(0)
+			if err != nil {
+				t.Fatalf("unable to list items: %s", err)
+			}
+
+			var got []string
+			for _, item := range items {
+				got = append(got, string(item.Data))
+			}
+			if strings.Join(got, ",") != strings.Join(tt.wantItems, ",") {
+				t.Fatalf("got items %v, want %v", got, tt.wantItems)
+			}
+		})
+	}
+}
+
+func TestFileStorageCompaction(t *testing.T) {
+
+	tests := []struct {
+		name string
+		key  []byte
+	}{
+		{"plain", nil},
+		{"encrypted", []byte("a storage key")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			path := filepath.Join(t.TempDir(), "history.log")
+
+			s, err := NewFileStorage(path, Retention{MaxItems: 4}, tt.key)
+			if err != nil {
+				t.Fatalf("unable to create storage: %s", err)
+			}
+			defer s.Close() // nolint
+
+			now := time.Now()
+			for i := 0; i < 3*compactionMinRecords; i++ {
+				item := protocol.NewItem([]byte(fmt.Sprintf("item %d", i)), now.Add(time.Duration(i)*time.Millisecond))
+				if err := s.Put(item); err != nil {
+					t.Fatalf("unable to put item: %s", err)
+				}
+				if i%2 == 0 {
+					if err := s.Delete(item.ID); err != nil {
+						t.Fatalf("unable to delete item: %s", err)
+					}
+				}
+			}
+
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatalf("unable to read storage: %s", err)
+			}
+
+			if lines := bytes.Count(data, []byte("\n")); lines > compactionMinRecords+1 {
+				t.Fatalf("storage has %d records, want at most %d", lines, compactionMinRecords+1)
+			}
+
+			// The compacted log holds the same items.
+			if err := os.WriteFile(path+".copy", data, 0600); err != nil {
+				t.Fatalf("unable to copy storage: %s", err)
+			}
+			reopened, err := NewFileStorage(path+".copy", Retention{MaxItems: 4}, tt.key)
+			if err != nil {
+				t.Fatalf("unable to reopen storage: %s", err)
+			}
+			defer reopened.Close() // nolint
+
+			want, _ := s.List(0)
+			got, _ := reopened.List(0)
+			if len(got) != len(want) {
+				t.Fatalf("got %d items, want %d", len(got), len(want))
+			}
+			for i := range want {
+				if got[i].ID != want[i].ID {
+					t.Fatalf("item %d is %s, want %s", i, got[i].ID, want[i].ID)
+				}
+			}
+		})
+	}
+}
+
+func TestFileStorageExpiredItems(t *testing.T) {
+
+	tests := []struct {
+		name      string
+		maxItems  int
+		expiring  bool
+		wantFound bool
+	}{
+		{"expired item", 0, true, false},
+		{"expired item already pruned", 1, true, false},
+		{"deleted item without expiration", 0, false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			path := filepath.Join(t.TempDir(), "history.log")
+
+			s, err := NewFileStorage(path, Retention{MaxItems: tt.maxItems}, nil)
+			if err != nil {
+				t.Fatalf("unable to create storage: %s", err)
+			}
+			defer s.Close() // nolint
+
+			now := time.Now()
+
+			secret := protocol.NewItem([]byte("one time password"), now)
+			if tt.expiring {
+				expires := now.Add(time.Minute)
+				secret.Expires = &expires
+			}
+
+			for _, item := range []*protocol.Item{
+				protocol.NewItem([]byte("before"), now.Add(-time.Second)),
+				secret,
+				protocol.NewItem([]byte("after"), now.Add(time.Second)),
+			} {
+				if err := s.Put(item); err != nil {
+					t.Fatalf("unable to put item: %s", err)
+				}
+			}
+
+			if err := s.Delete(secret.ID); err != nil {
+				t.Fatalf("unable to delete item: %s", err)
+			}
+
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatalf("unable to read storage: %s", err)
+			}
+
+			// The content is base64 encoded in the records.
+			found := bytes.Contains(data, []byte(base64.StdEncoding.EncodeToString(secret.Data)))
+			if found != tt.wantFound {
+				t.Fatalf("content found in the storage: %t, want %t", found, tt.wantFound)
+			}
+
+			// The other items are still there.
+			items, err := s.List(0)
+			if err != nil {
+				t.Fatalf("unable to list items: %s", err)
+			}
+			if len(items) == 0 || string(items[0].Data) != "after" {
+				t.Fatalf("unexpected items after deletion: %d", len(items))
+			}
+		})
+	}
+}
@@ -0,0 +1,435 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/primalmotion/netboard/client"
+	"github.com/primalmotion/netboard/protocol"
+)
+
+// Headers sent with the webhook deliveries.
+const (
+	// WebhookEventHeader holds the event that triggered the delivery.
+	WebhookEventHeader = "X-Netboard-Event"
+
+	// WebhookDeliveryHeader holds the unique ID of the delivery,
+	// which stays the same when it is retried.
+	WebhookDeliveryHeader = "X-Netboard-Delivery"
+
+	// WebhookSignatureHeader holds the signature of the body.
+	// See SignWebhook.
+	WebhookSignatureHeader = "X-Netboard-Signature"
+)
+
+const (
+	// webhookQueueSize is the number of deliveries waiting
+	// for a webhook, above which they are dead-lettered.
+	webhookQueueSize = 64
+
+	defaultWebhookAttempts = 5
+	defaultWebhookTimeout  = 10 * time.Second
+)
+
+// A Webhook posts the published items to an URL.
+type Webhook struct {
+
+	// URL is the address receiving the items.
+	URL string `mapstructure:"url"`
+
+	// Secret is the optional key used to sign the deliveries.
+	Secret string `mapstructure:"secret"`
+
+	// Devices are the fingerprints or the common names of the
+	// devices whose items are posted. Empty means all of them.
+	Devices []string `mapstructure:"devices"`
+
+	// Groups are the organizations or organizational units
+	// of the certificates of the devices whose items are
+	// posted. Empty means all of them.
+	Groups []string `mapstructure:"groups"`
+
+	// MIME are the MIME type patterns (like text/*) of
+	// the items to post. Empty means all of them.
+	MIME []string `mapstructure:"mime"`
+
+	// MaxAttempts is the number of attempts before the
+	// delivery is dead-lettered. 0 means 5.
+	MaxAttempts int `mapstructure:"max-attempts"`
+
+	// Timeout is the timeout of each attempt. 0 means 10s.
+	Timeout time.Duration `mapstructure:"timeout"`
+}
+
+// A WebhookPayload is the body posted to the webhooks.
+type WebhookPayload struct {
+	Event  string    `json:"event"`
+	ID     string    `json:"id"`
+	Time   time.Time `json:"time"`
+	Device string    `json:"device"`
+	CN     string    `json:"cn,omitempty"`
+	Groups []string  `json:"groups,omitempty"`
+	MIME   string    `json:"mime,omitempty"`
+	Size   int64     `json:"size"`
+
+	// Text is the content of the textual items, so chat
+	// services accepting a text field can display it.
+	Text string `json:"text,omitempty"`
+
+	// Data is the content of the other items. Neither Text nor
+	// Data are set for the blobs, which stay on the server, nor
+	// for the expiring items, whose content would outlive them.
+	Data []byte `json:"data,omitempty"`
+
+	Blob    bool       `json:"blob,omitempty"`
+	Expires *time.Time `json:"expires,omitempty"`
+}
+
+// SignWebhook returns the signature of the given body
+// with the given secret, as sent in WebhookSignatureHeader.
+// It is the hex encoded HMAC-SHA256 of the body prefixed
+// with sha256=.
+func SignWebhook(secret string, body []byte) string {
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body) // nolint
+
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifyWebhook returns true if the given signature
+// is the one of the given body with the given secret.
+func VerifyWebhook(secret string, body []byte, signature string) bool {
+	return hmac.Equal([]byte(SignWebhook(secret, body)), []byte(signature))
+}
+
+type delivery struct {
+	id   string
+	body []byte
+}
+
+type webhook struct {
+	Webhook
+	queue chan delivery
+}
+
+// matches returns true if the item published by the
+// device with the given certificate must be posted.
+func (h *webhook) matches(item *protocol.Item, cert *x509.Certificate) bool {
+
+	if len(h.Devices) > 0 {
+		id := protocol.Fingerprint(cert.Raw)
+		var found bool
+		for _, d := range h.Devices {
+			if strings.EqualFold(d, id) || d == cert.Subject.CommonName {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	if len(h.Groups) > 0 && !inGroups(certGroups(cert), h.Groups) {
+		return false
+	}
+
+	if len(h.MIME) > 0 {
+		for _, p := range h.MIME {
+			if ok, _ := path.Match(p, item.MIME); ok {
+				return true
+			}
+		}
+		return false
+	}
+
+	return true
+}
+
+type deadLetter struct {
+	Time     time.Time       `json:"time"`
+	URL      string          `json:"url"`
+	Delivery string          `json:"delivery"`
+	Attempts int             `json:"attempts"`
+	Error    string          `json:"error"`
+	Payload  json.RawMessage `json:"payload"`
+}
+
+// webhooks delivers the published items to the webhooks.
+// Each webhook has its own queue, so a slow one does not
+// delay the others, and gets the items in order.
+type webhooks struct {
+	hooks      []*webhook
+	client     *http.Client
+	backoff    client.Backoff
+	deadLetter *os.File
+
+	sync.Mutex
+}
+
+// newWebhooks returns webhooks delivering to the given hooks
+// until the context is canceled. The deliveries failing after all
+// the attempts are appended to the file at deadLetterPath, if set.
+func newWebhooks(ctx context.Context, hooks []Webhook, deadLetterPath string) (*webhooks, error) {
+
+	w := &webhooks{
+		client:  &http.Client{},
+		backoff: client.DefaultBackoff,
+	}
+
+	for _, h := range hooks {
+
+		u, err := url.Parse(h.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("invalid webhook url: %s", h.URL)
+		}
+
+		if h.MaxAttempts <= 0 {
+			h.MaxAttempts = defaultWebhookAttempts
+		}
+		if h.Timeout <= 0 {
+			h.Timeout = defaultWebhookTimeout
+		}
+
+		w.hooks = append(w.hooks, &webhook{
+			Webhook: h,
+			queue:   make(chan delivery, webhookQueueSize),
+		})
+	}
+
+	if deadLetterPath != "" {
+
+		if err := os.MkdirAll(filepath.Dir(deadLetterPath), 0700); err != nil {
+			return nil, fmt.Errorf("unable to create dead letter directory: %w", err)
+		}
+
+		f, err := os.OpenFile(deadLetterPath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+		if err != nil {
+			return nil, fmt.Errorf("unable to open dead letter log: %w", err)
+		}
+		w.deadLetter = f
+	}
+
+	for _, h := range w.hooks {
+		go w.run(ctx, h)
+	}
+
+	return w, nil
+}
+
+// Trigger queues the delivery of the given item, published
+// by the device with the given certificate, to the matching
+// webhooks.
+func (w *webhooks) Trigger(item *protocol.Item, cert *x509.Certificate) {
+
+	if w == nil {
+		return
+	}
+
+	var body []byte
+
+	for _, h := range w.hooks {
+
+		if !h.matches(item, cert) {
+			continue
+		}
+
+		if body == nil {
+			var err error
+			if body, err = json.Marshal(newWebhookPayload(item, cert)); err != nil {
+				slog.Error("unable to encode webhook payload", "item", item.ID, "error", err)
+				return
+			}
+		}
+
+		d := delivery{id: newDeliveryID(), body: body}
+
+		select {
+		case h.queue <- d:
+		default:
+			w.bury(h, d, 0, fmt.Errorf("too many pending deliveries"))
+		}
+	}
+}
+
+// Close closes the dead letter log.
+func (w *webhooks) Close() error {
+
+	w.Lock()
+	defer w.Unlock()
+
+	if w.deadLetter == nil {
+		return nil
+	}
+
+	return w.deadLetter.Close()
+}
+
+func (w *webhooks) run(ctx context.Context, h *webhook) {
+
+	for {
+		select {
+		case d := <-h.queue:
+			w.deliver(ctx, h, d)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// deliver posts the given delivery, retrying with a backoff
+// when it fails. It is dead-lettered after the last attempt.
+func (w *webhooks) deliver(ctx context.Context, h *webhook, d delivery) {
+
+	var attempt int
+
+	for {
+
+		attempt++
+
+		retry, err := w.post(ctx, h, d)
+		if err == nil {
+			slog.Debug("webhook delivered", "url", h.URL, "delivery", d.id, "attempt", attempt)
+			return
+		}
+
+		if !retry || attempt >= h.MaxAttempts {
+			w.bury(h, d, attempt, err)
+			return
+		}
+
+		delay := w.backoff.Delay(attempt)
+		slog.Warn("webhook delivery failed", "url", h.URL, "delivery", d.id, "attempt", attempt, "retry", delay, "error", err)
+
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			w.bury(h, d, attempt, ctx.Err())
+			return
+		}
+	}
+}
+
+// post posts the given delivery once. It returns
+// true if the failure is worth another attempt.
+func (w *webhooks) post(ctx context.Context, h *webhook, d delivery) (bool, error) {
+
+	ctx, cancel := context.WithTimeout(ctx, h.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.URL, bytes.NewReader(d.body))
+	if err != nil {
+		return false, fmt.Errorf("unable to prepare request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(WebhookEventHeader, AuditPublish)
+	req.Header.Set(WebhookDeliveryHeader, d.id)
+	if h.Secret != "" {
+		req.Header.Set(WebhookSignatureHeader, SignWebhook(h.Secret, d.body))
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close() // nolint
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		retry := resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests
+		return retry, fmt.Errorf("webhook answered: %s", resp.Status)
+	}
+
+	return false, nil
+}
+
+// bury records the given delivery in the dead letter log.
+func (w *webhooks) bury(h *webhook, d delivery, attempts int, err error) {
+
+	slog.Error("webhook delivery dead-lettered", "url", h.URL, "delivery", d.id, "attempts", attempts, "error", err)
+
+	w.Lock()
+	defer w.Unlock()
+
+	if w.deadLetter == nil {
+		return
+	}
+
+	data, jErr := json.Marshal(deadLetter{
+		Time:     time.Now(),
+		URL:      h.URL,
+		Delivery: d.id,
+		Attempts: attempts,
+		Error:    err.Error(),
+		Payload:  d.body,
+	})
+	if jErr != nil {
+		slog.Error("unable to encode dead letter", "delivery", d.id, "error", jErr)
+		return
+	}
+
+	if _, err := w.deadLetter.Write(append(data, '\n')); err != nil {
+		slog.Error("unable to write dead letter", "delivery", d.id, "error", err)
+	}
+}
+
+func newWebhookPayload(item *protocol.Item, cert *x509.Certificate) WebhookPayload {
+
+	p := WebhookPayload{
+		Event:   AuditPublish,
+		ID:      item.ID,
+		Time:    item.Time,
+		Device:  protocol.Fingerprint(cert.Raw),
+		CN:      cert.Subject.CommonName,
+		Groups:  certGroups(cert),
+		MIME:    item.MIME,
+		Size:    int64(len(item.Data)),
+		Blob:    item.Blob,
+		Expires: item.Expires,
+	}
+
+	if item.Blob {
+		p.Size = item.Size
+		return p
+	}
+
+	// The content of the expiring items is neither posted
+	// nor dead-lettered, as nothing would expire it there.
+	if item.Expires != nil {
+		return p
+	}
+
+	if strings.HasPrefix(item.MIME, "text/") && utf8.Valid(item.Data) {
+		p.Text = string(item.Data)
+	} else {
+		p.Data = item.Data
+	}
+
+	return p
+}
+
+func newDeliveryID() string {
+
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,356 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/primalmotion/netboard/client"
+	"github.com/primalmotion/netboard/protocol"
+)
+
+func TestSignWebhook(t *testing.T) {
+
+	body := []byte(`{"event":"publish"}`)
+	signature := SignWebhook("secret", body)
+
+	tests := []struct {
+		name      string
+		secret    string
+		body      []byte
+		signature string
+		want      bool
+	}{
+		{"valid signature", "secret", body, signature, true},
+		{"wrong secret", "other", body, signature, false},
+		{"tampered body", "secret", []byte(`{"event":"clear"}`), signature, false},
+		{"missing prefix", "secret", body, signature[len("sha256="):], false},
+		{"empty signature", "secret", body, "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := VerifyWebhook(tt.secret, tt.body, tt.signature); got != tt.want {
+				t.Fatalf("got %t, want %t", got, tt.want)
+			}
+		})
+	}
+
+	// The well known HMAC-SHA256 test vector of RFC 4231, case 2.
+	want := "sha256=5bdcc146bf60754e6a042426089575c75a003f089d2739839dec58b964ec3843"
+	if got := SignWebhook("Jefe", []byte("what do ya want for nothing?")); got != want {
+		t.Fatalf("got signature %s, want %s", got, want)
+	}
+}
+
+func TestWebhookMatches(t *testing.T) {
+
+	cert := &x509.Certificate{
+		Raw: []byte("laptop certificate"),
+		Subject: pkix.Name{
+			CommonName:         "laptop",
+			Organization:       []string{"example"},
+			OrganizationalUnit: []string{"support"},
+		},
+	}
+
+	tests := []struct {
+		name string
+		hook Webhook
+		mime string
+		want bool
+	}{
+		{"no filter", Webhook{}, "text/plain", true},
+		{"device by common name", Webhook{Devices: []string{"laptop"}}, "text/plain", true},
+		{"device by fingerprint", Webhook{Devices: []string{protocol.Fingerprint(cert.Raw)}}, "text/plain", true},
+		{"other device", Webhook{Devices: []string{"desktop"}}, "text/plain", false},
+		{"group by organizational unit", Webhook{Groups: []string{"support"}}, "text/plain", true},
+		{"group by organization", Webhook{Groups: []string{"example"}}, "text/plain", true},
+		{"other group", Webhook{Groups: []string{"engineering"}}, "text/plain", false},
+		{"matching mime", Webhook{MIME: []string{"text/*"}}, "text/plain", true},
+		{"other mime", Webhook{MIME: []string{"text/*"}}, "image/png", false},
+		{"group and other mime", Webhook{Groups: []string{"support"}, MIME: []string{"image/*"}}, "text/plain", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			h := &webhook{Webhook: tt.hook}
+
+			item := protocol.NewItem([]byte("hello"), time.Now())
+			item.MIME = tt.mime
+
+			if got := h.matches(item, cert); got != tt.want {
+				t.Fatalf("got %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+// webhookRecorder is a webhook receiver answering
+// the given statuses in turn, and recording the
+// requests it received.
+type webhookRecorder struct {
+	statuses []int
+
+	requests []*http.Request
+	bodies   [][]byte
+	times    []time.Time
+
+	sync.Mutex
+}
+
+func (rec *webhookRecorder) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+
+	body, _ := io.ReadAll(r.Body)
+
+	rec.Lock()
+	defer rec.Unlock()
+
+	status := rec.statuses[len(rec.requests)%len(rec.statuses)]
+	rec.requests = append(rec.requests, r)
+	rec.bodies = append(rec.bodies, body)
+	rec.times = append(rec.times, time.Now())
+
+	w.WriteHeader(status)
+}
+
+func TestWebhooksDeliver(t *testing.T) {
+
+	backoff := client.Backoff{Initial: 10 * time.Millisecond, Factor: 2}
+
+	tests := []struct {
+		name         string
+		secret       string
+		statuses     []int
+		maxAttempts  int
+		wantAttempts int
+		wantBuried   bool
+	}{
+		{"delivered", "secret", []int{http.StatusNoContent}, 3, 1, false},
+		{"delivered without secret", "", []int{http.StatusOK}, 3, 1, false},
+		{"retried after server error", "secret", []int{http.StatusBadGateway, http.StatusTooManyRequests, http.StatusOK}, 3, 3, false},
+		{"dead-lettered after last attempt", "secret", []int{http.StatusServiceUnavailable}, 3, 3, true},
+		{"dead-lettered on client error", "secret", []int{http.StatusBadRequest}, 3, 1, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			rec := &webhookRecorder{statuses: tt.statuses}
+			ts := httptest.NewServer(rec)
+			defer ts.Close()
+
+			deadLetterPath := filepath.Join(t.TempDir(), "dead-letter.log")
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			w, err := newWebhooks(ctx, []Webhook{{URL: ts.URL, Secret: tt.secret, MaxAttempts: tt.maxAttempts}}, deadLetterPath)
+			if err != nil {
+				t.Fatalf("unable to create webhooks: %s", err)
+			}
+			defer w.Close() // nolint
+			w.backoff = backoff
+
+			body := []byte(`{"event":"publish","id":"ba7995e5"}`)
+			w.deliver(ctx, w.hooks[0], delivery{id: "delivery-id", body: body})
+
+			rec.Lock()
+			defer rec.Unlock()
+
+			if len(rec.requests) != tt.wantAttempts {
+				t.Fatalf("got %d attempts, want %d", len(rec.requests), tt.wantAttempts)
+			}
+
+			for i, r := range rec.requests {
+
+				if r.Header.Get(WebhookDeliveryHeader) != "delivery-id" {
+					t.Fatalf("attempt %d has delivery %q", i+1, r.Header.Get(WebhookDeliveryHeader))
+				}
+
+				if r.Header.Get(WebhookEventHeader) != AuditPublish {
+					t.Fatalf("attempt %d has event %q", i+1, r.Header.Get(WebhookEventHeader))
+				}
+
+				signature := r.Header.Get(WebhookSignatureHeader)
+				switch {
+				case tt.secret == "" && signature != "":
+					t.Fatalf("attempt %d is signed without secret", i+1)
+				case tt.secret != "" && !VerifyWebhook(tt.secret, rec.bodies[i], signature):
+					t.Fatalf("attempt %d has invalid signature %q", i+1, signature)
+				}
+
+				if i > 0 {
+					if gap, min := rec.times[i].Sub(rec.times[i-1]), backoff.Delay(i); gap < min {
+						t.Fatalf("attempt %d came %s after the previous one, want at least %s", i+1, gap, min)
+					}
+				}
+			}
+
+			letters := readDeadLetters(t, deadLetterPath)
+
+			if !tt.wantBuried {
+				if len(letters) != 0 {
+					t.Fatalf("got %d dead letters, want none", len(letters))
+				}
+				return
+			}
+
+			if len(letters) != 1 {
+				t.Fatalf("got %d dead letters, want 1", len(letters))
+			}
+
+			l := letters[0]
+			if l.URL != ts.URL || l.Delivery != "delivery-id" || l.Attempts != tt.wantAttempts || l.Error == "" {
+				t.Fatalf("unexpected dead letter: %+v", l)
+			}
+			if string(l.Payload) != string(body) {
+				t.Fatalf("dead letter payload is %s", l.Payload)
+			}
+		})
+	}
+}
+
+func TestWebhooksTriggerQueueFull(t *testing.T) {
+
+	deadLetterPath := filepath.Join(t.TempDir(), "dead-letter.log")
+
+	f, err := os.Create(deadLetterPath)
+	if err != nil {
+		t.Fatalf("unable to create dead letter log: %s", err)
+	}
+
+	// Nothing reads the queue of the webhook.
+	w := &webhooks{
+		hooks:      []*webhook{{Webhook: Webhook{URL: "http://127.0.0.1:1"}, queue: make(chan delivery, webhookQueueSize)}},
+		deadLetter: f,
+	}
+	defer w.Close() // nolint
+
+	cert := &x509.Certificate{Raw: []byte("laptop certificate"), Subject: pkix.Name{CommonName: "laptop"}}
+
+	for i := 0; i < webhookQueueSize+2; i++ {
+		w.Trigger(protocol.NewItem([]byte{byte(i)}, time.Now()), cert)
+	}
+
+	if letters := readDeadLetters(t, deadLetterPath); len(letters) != 2 {
+		t.Fatalf("got %d dead letters, want 2", len(letters))
+	}
+}
+
+func readDeadLetters(t *testing.T, path string) []deadLetter {
+
+	t.Helper()
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("unable to open dead letter log: %s", err)
+	}
+	defer f.Close() // nolint
+
+	var letters []deadLetter
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		l := deadLetter{}
+		if err := json.Unmarshal(scanner.Bytes(), &l); err != nil {
+			t.Fatalf("unable to decode dead letter: %s", err)
+		}
+		letters = append(letters, l)
+	}
+
+	return letters
+}
+
+func TestNewWebhookPayload(t *testing.T) {
+
+	cert := &x509.Certificate{Raw: []byte("laptop certificate"), Subject: pkix.Name{CommonName: "laptop"}}
+	expires := time.Now().Add(time.Minute)
+
+	tests := []struct {
+		name     string
+		mime     string
+		blob     bool
+		expires  *time.Time
+		wantText string
+		wantData bool
+	}{
+		{"text", "text/plain", false, nil, "secret", false},
+		{"binary", "image/png", false, nil, "", true},
+		{"blob", "image/png", true, nil, "", false},
+		{"expiring text", "text/plain", false, &expires, "", false},
+		{"expiring binary", "image/png", false, &expires, "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			item := protocol.NewItem([]byte("secret"), time.Now())
+			item.MIME = tt.mime
+			item.Blob = tt.blob
+			item.Size = int64(len(item.Data))
+			item.Expires = tt.expires
+
+			p := newWebhookPayload(item, cert)
+
+			if p.Text != tt.wantText {
+				t.Fatalf("got text %q, want %q", p.Text, tt.wantText)
+			}
+			if (p.Data != nil) != tt.wantData {
+				t.Fatalf("got data %q", p.Data)
+			}
+			if p.ID != item.ID || p.MIME != tt.mime || p.Size != 6 || p.Expires != tt.expires {
+				t.Fatalf("unexpected payload: %+v", p)
+			}
+		})
+	}
+}
+
+func TestWebhooksExpiringItemBuried(t *testing.T) {
+
+	deadLetterPath := filepath.Join(t.TempDir(), "dead-letter.log")
+
+	f, err := os.Create(deadLetterPath)
+	if err != nil {
+		t.Fatalf("unable to create dead letter log: %s", err)
+	}
+
+	// The queue of the webhook is full.
+	w := &webhooks{
+		hooks:      []*webhook{{Webhook: Webhook{URL: "http://127.0.0.1:1"}, queue: make(chan delivery)}},
+		deadLetter: f,
+	}
+	defer w.Close() // nolint
+
+	cert := &x509.Certificate{Raw: []byte("laptop certificate"), Subject: pkix.Name{CommonName: "laptop"}}
+
+	item := protocol.NewItem([]byte("one time password"), time.Now())
+	item.MIME = "text/plain"
+	expires := time.Now().Add(time.Minute)
+	item.Expires = &expires
+
+	w.Trigger(item, cert)
+
+	data, err := os.ReadFile(deadLetterPath)
+	if err != nil {
+		t.Fatalf("unable to read dead letter log: %s", err)
+	}
+	if len(readDeadLetters(t, deadLetterPath)) != 1 {
+		t.Fatalf("delivery not dead-lettered")
+	}
+	if strings.Contains(string(data), "one time password") {
+		t.Fatalf("dead letter holds the content of the item")
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"reflect"
+	"time"
+
+	"github.com/primalmotion/netboard/tray"
+)
+
+// trayRefreshInterval is the interval at which
+// the tray icon reflects the state of listen.
+const trayRefreshInterval = 2 * time.Second
+
+// runTray shows an icon in the system tray reflecting the state
+// of the listen loop, which it drives through the given control
+// channel, until the context is canceled.
+func runTray(ctx context.Context, ch chan<- controlRequest) error {
+
+	t, err := tray.New("netboard", "netboard")
+	if err != nil {
+		return fmt.Errorf("unable to show tray icon: %w", err)
+	}
+
+	call := func(command string, args ...string) controlReply {
+
+		req := newControlRequest(command, args...)
+
+		select {
+		case ch <- req:
+		case <-ctx.Done():
+			return controlReply{Error: "listen is stopping"}
+		}
+
+		select {
+		case reply := <-req.reply:
+			return reply
+		case <-ctx.Done():
+			return controlReply{Error: "listen is stopping"}
+		}
+	}
+
+	refresh := make(chan struct{}, 1)
+
+	// act runs the given command from a menu entry,
+	// and refreshes the icon right away.
+	act := func(command string, args ...string) func() {
+		return func() {
+			if reply := call(command, args...); reply.Error != "" {
+				slog.Warn("unable to run tray action", "action", command, "error", reply.Error)
+			}
+			select {
+			case refresh <- struct{}{}:
+			default:
+			}
+		}
+	}
+
+	go func() {
+
+		defer t.Close() // nolint
+
+		ticker := time.NewTicker(trayRefreshInterval)
+		defer ticker.Stop()
+
+		var lastStatus controlStatus
+		var lastItems []controlItem
+		var shown bool
+
+		for {
+
+			status, ok := call("status").Data.(controlStatus)
+			items, _ := call("recent").Data.([]controlItem)
+
+			if ok && (!shown || !reflect.DeepEqual(status, lastStatus) || !reflect.DeepEqual(items, lastItems)) {
+				updateTray(t, status, items, act)
+				lastStatus, lastItems, shown = status, items, true
+			}
+
+			select {
+			case <-ticker.C:
+			case <-refresh:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return nil
+}
+
+// updateTray sets the icon, the tooltip and the
+// menu of the tray for the given status and items.
+func updateTray(t *tray.Tray, status controlStatus, items []controlItem, act func(string, ...string) func()) {
+
+	summary := fmt.Sprintf("%s to %s", status.State, status.URL)
+
+	switch {
+	case status.State != "connected":
+		t.SetIcon("network-offline")
+		t.SetStatus(tray.StatusNeedsAttention)
+	case status.Staged != nil:
+		t.SetIcon("edit-paste")
+		t.SetStatus(tray.StatusNeedsAttention)
+		summary = "an item is waiting to be accepted"
+	case status.Paused:
+		t.SetIcon("media-playback-pause")
+		t.SetStatus(tray.StatusActive)
+		summary = "sync is paused"
+	default:
+		t.SetIcon("edit-paste")
+		t.SetStatus(tray.StatusActive)
+	}
+
+	t.SetToolTip("netboard", summary)
+
+	pause := "pause"
+	if status.Paused {
+		pause = "resume"
+	}
+
+	menu := []tray.MenuItem{
+		{Label: fmt.Sprintf("Server: %s (%s)", status.URL, status.State), Disabled: true},
+		{Label: fmt.Sprintf("Direction: %s", status.Direction), Disabled: true},
+		{Label: "Pause sync", Checkbox: true, Checked: status.Paused, OnClick: act(pause)},
+	}
+
+	if status.Staged != nil {
+		menu = append(menu,
+			tray.MenuItem{Label: fmt.Sprintf("Accept %s", status.Staged), OnClick: act("accept", status.Staged.ID)},
+			tray.MenuItem{Label: "Reject waiting item", OnClick: act("reject", status.Staged.ID)},
+		)
+	}
+
+	recent := tray.MenuItem{Label: "Copy again"}
+	for _, item := range items {
+		recent.Children = append(recent.Children, tray.MenuItem{
+			Label:   fmt.Sprintf("%s: %s", item.Source, item),
+			OnClick: act("copy", item.ID),
+		})
+	}
+	if len(recent.Children) == 0 {
+		recent.Disabled = true
+	}
+
+	menu = append(menu,
+		tray.MenuItem{Separator: true},
+		recent,
+		tray.MenuItem{Separator: true},
+		tray.MenuItem{Label: "Reconnect", OnClick: act("reconnect")},
+	)
+
+	t.SetMenu(menu)
+}
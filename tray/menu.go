@@ -0,0 +1,255 @@
+package tray
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/godbus/dbus/v5"
+)
+
+const (
+	menuPath      = "/MenuBar"
+	menuInterface = "com.canonical.dbusmenu"
+)
+
+// A MenuItem is an entry of the menu of the tray icon.
+type MenuItem struct {
+
+	// Label is the text of the entry.
+	Label string
+
+	// Disabled greys out the entry.
+	Disabled bool
+
+	// Checkbox shows a checkbox, checked if Checked is true.
+	Checkbox bool
+	Checked  bool
+
+	// Separator makes the entry a separator. The
+	// other fields are ignored.
+	Separator bool
+
+	// Children are the entries of the submenu of the entry.
+	Children []MenuItem
+
+	// OnClick is called when the entry is clicked.
+	OnClick func()
+}
+
+// menuLayout is the D-Bus representation of an entry
+// and its children, of signature (ia{sv}av).
+type menuLayout struct {
+	ID         int32
+	Properties map[string]dbus.Variant
+	Children   []dbus.Variant
+}
+
+type menuProperties struct {
+	ID         int32
+	Properties map[string]dbus.Variant
+}
+
+type menuEvent struct {
+	ID        int32
+	EventID   string
+	Data      dbus.Variant
+	Timestamp uint32
+}
+
+// menuNode is an entry of the menu, with its D-Bus ID.
+type menuNode struct {
+	id       int32
+	item     MenuItem
+	children []*menuNode
+}
+
+func (n *menuNode) properties() map[string]dbus.Variant {
+
+	props := map[string]dbus.Variant{}
+
+	if n.id == 0 {
+		props["children-display"] = dbus.MakeVariant("submenu")
+		return props
+	}
+
+	if n.item.Separator {
+		props["type"] = dbus.MakeVariant("separator")
+		return props
+	}
+
+	// Underscores introduce access keys in the labels.
+	props["label"] = dbus.MakeVariant(strings.ReplaceAll(n.item.Label, "_", "__"))
+	props["enabled"] = dbus.MakeVariant(!n.item.Disabled)
+
+	if n.item.Checkbox {
+		state := int32(0)
+		if n.item.Checked {
+			state = 1
+		}
+		props["toggle-type"] = dbus.MakeVariant("checkmark")
+		props["toggle-state"] = dbus.MakeVariant(state)
+	}
+
+	if len(n.children) > 0 {
+		props["children-display"] = dbus.MakeVariant("submenu")
+	}
+
+	return props
+}
+
+func (n *menuNode) layout(depth int32) menuLayout {
+
+	l := menuLayout{
+		ID:         n.id,
+		Properties: n.properties(),
+		Children:   []dbus.Variant{},
+	}
+
+	if depth == 0 {
+		return l
+	}
+
+	for _, child := range n.children {
+		l.Children = append(l.Children, dbus.MakeVariant(child.layout(depth-1)))
+	}
+
+	return l
+}
+
+// menu implements the com.canonical.dbusmenu interface. All
+// its exported methods are exposed on the bus.
+type menu struct {
+	conn     *dbus.Conn
+	root     *menuNode
+	nodes    map[int32]*menuNode
+	revision uint32
+
+	sync.Mutex
+}
+
+func newMenu(conn *dbus.Conn) *menu {
+
+	m := &menu{conn: conn}
+	m.set(nil)
+
+	return m
+}
+
+// set replaces the entries of the menu and
+// tells the host the layout changed.
+func (m *menu) set(items []MenuItem) {
+
+	m.Lock()
+
+	m.root = &menuNode{}
+	m.nodes = map[int32]*menuNode{0: m.root}
+
+	var next int32
+	var add func(parent *menuNode, items []MenuItem)
+	add = func(parent *menuNode, items []MenuItem) {
+		for _, item := range items {
+			next++
+			n := &menuNode{id: next, item: item}
+			m.nodes[n.id] = n
+			parent.children = append(parent.children, n)
+			add(n, item.Children)
+		}
+	}
+	add(m.root, items)
+
+	m.revision++
+	revision := m.revision
+
+	m.Unlock()
+
+	_ = m.conn.Emit(menuPath, menuInterface+".LayoutUpdated", revision, int32(0))
+}
+
+func (m *menu) GetLayout(parentID int32, recursionDepth int32, propertyNames []string) (uint32, menuLayout, *dbus.Error) {
+
+	m.Lock()
+	defer m.Unlock()
+
+	n, ok := m.nodes[parentID]
+	if !ok {
+		return 0, menuLayout{}, dbus.MakeFailedError(errUnknownEntry)
+	}
+
+	return m.revision, n.layout(recursionDepth), nil
+}
+
+func (m *menu) GetGroupProperties(ids []int32, propertyNames []string) ([]menuProperties, *dbus.Error) {
+
+	m.Lock()
+	defer m.Unlock()
+
+	out := []menuProperties{}
+	for _, id := range ids {
+		if n, ok := m.nodes[id]; ok {
+			out = append(out, menuProperties{ID: id, Properties: n.properties()})
+		}
+	}
+
+	return out, nil
+}
+
+func (m *menu) GetProperty(id int32, name string) (dbus.Variant, *dbus.Error) {
+
+	m.Lock()
+	defer m.Unlock()
+
+	n, ok := m.nodes[id]
+	if !ok {
+		return dbus.Variant{}, dbus.MakeFailedError(errUnknownEntry)
+	}
+
+	v, ok := n.properties()[name]
+	if !ok {
+		return dbus.Variant{}, dbus.MakeFailedError(errUnknownProperty)
+	}
+
+	return v, nil
+}
+
+func (m *menu) Event(id int32, eventID string, data dbus.Variant, timestamp uint32) *dbus.Error {
+
+	if eventID != "clicked" {
+		return nil
+	}
+
+	m.Lock()
+	n, ok := m.nodes[id]
+	m.Unlock()
+
+	if !ok {
+		return dbus.MakeFailedError(errUnknownEntry)
+	}
+
+	// The callback may update the menu, which
+	// must not block the handling of the call.
+	if n.item.OnClick != nil && !n.item.Disabled {
+		go n.item.OnClick()
+	}
+
+	return nil
+}
+
+func (m *menu) EventGroup(events []menuEvent) ([]int32, *dbus.Error) {
+
+	notFound := []int32{}
+	for _, e := range events {
+		if err := m.Event(e.ID, e.EventID, e.Data, e.Timestamp); err != nil {
+			notFound = append(notFound, e.ID)
+		}
+	}
+
+	return notFound, nil
+}
+
+func (m *menu) AboutToShow(id int32) (bool, *dbus.Error) {
+	return false, nil
+}
+
+func (m *menu) AboutToShowGroup(ids []int32) ([]int32, []int32, *dbus.Error) {
+	return []int32{}, []int32{}, nil
+}
@@ -0,0 +1,312 @@
+package tray
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/godbus/dbus/v5"
+	"github.com/godbus/dbus/v5/introspect"
+	"github.com/godbus/dbus/v5/prop"
+)
+
+const (
+	itemPath      = "/StatusNotifierItem"
+	itemInterface = "org.kde.StatusNotifierItem"
+
+	watcherName      = "org.kde.StatusNotifierWatcher"
+	watcherPath      = "/StatusNotifierWatcher"
+	watcherInterface = "org.kde.StatusNotifierWatcher"
+)
+
+// Various values of the status of the icon.
+const (
+	StatusPassive        = "Passive"
+	StatusActive         = "Active"
+	StatusNeedsAttention = "NeedsAttention"
+)
+
+var (
+	errUnknownEntry    = errors.New("unknown menu entry")
+	errUnknownProperty = errors.New("unknown menu property")
+)
+
+const itemIntrospection = `
+	<interface name="org.kde.StatusNotifierItem">
+		<method name="ContextMenu">
+			<arg name="x" type="i" direction="in"/>
+			<arg name="y" type="i" direction="in"/>
+		</method>
+		<method name="Activate">
+			<arg name="x" type="i" direction="in"/>
+			<arg name="y" type="i" direction="in"/>
+		</method>
+		<method name="SecondaryActivate">
+			<arg name="x" type="i" direction="in"/>
+			<arg name="y" type="i" direction="in"/>
+		</method>
+		<method name="Scroll">
+			<arg name="delta" type="i" direction="in"/>
+			<arg name="orientation" type="s" direction="in"/>
+		</method>
+		<signal name="NewTitle"/>
+		<signal name="NewIcon"/>
+		<signal name="NewToolTip"/>
+		<signal name="NewStatus">
+			<arg name="status" type="s"/>
+		</signal>
+		<property name="Category" type="s" access="read"/>
+		<property name="Id" type="s" access="read"/>
+		<property name="Title" type="s" access="read"/>
+		<property name="Status" type="s" access="read"/>
+		<property name="WindowId" type="i" access="read"/>
+		<property name="IconName" type="s" access="read"/>
+		<property name="ToolTip" type="(sa(iiay)ss)" access="read"/>
+		<property name="ItemIsMenu" type="b" access="read"/>
+		<property name="Menu" type="o" access="read"/>
+	</interface>`
+
+const menuIntrospection = `
+	<interface name="com.canonical.dbusmenu">
+		<method name="GetLayout">
+			<arg name="parentId" type="i" direction="in"/>
+			<arg name="recursionDepth" type="i" direction="in"/>
+			<arg name="propertyNames" type="as" direction="in"/>
+			<arg name="revision" type="u" direction="out"/>
+			<arg name="layout" type="(ia{sv}av)" direction="out"/>
+		</method>
+		<method name="GetGroupProperties">
+			<arg name="ids" type="ai" direction="in"/>
+			<arg name="propertyNames" type="as" direction="in"/>
+			<arg name="properties" type="a(ia{sv})" direction="out"/>
+		</method>
+		<method name="GetProperty">
+			<arg name="id" type="i" direction="in"/>
+			<arg name="name" type="s" direction="in"/>
+			<arg name="value" type="v" direction="out"/>
+		</method>
+		<method name="Event">
+			<arg name="id" type="i" direction="in"/>
+			<arg name="eventId" type="s" direction="in"/>
+			<arg name="data" type="v" direction="in"/>
+			<arg name="timestamp" type="u" direction="in"/>
+		</method>
+		<method name="EventGroup">
+			<arg name="events" type="a(isvu)" direction="in"/>
+			<arg name="idErrors" type="ai" direction="out"/>
+		</method>
+		<method name="AboutToShow">
+			<arg name="id" type="i" direction="in"/>
+			<arg name="needUpdate" type="b" direction="out"/>
+		</method>
+		<method name="AboutToShowGroup">
+			<arg name="ids" type="ai" direction="in"/>
+			<arg name="updatesNeeded" type="ai" direction="out"/>
+			<arg name="idErrors" type="ai" direction="out"/>
+		</method>
+		<signal name="LayoutUpdated">
+			<arg name="revision" type="u"/>
+			<arg name="parent" type="i"/>
+		</signal>
+		<property name="Version" type="u" access="read"/>
+		<property name="TextDirection" type="s" access="read"/>
+		<property name="Status" type="s" access="read"/>
+		<property name="IconThemePath" type="as" access="read"/>
+	</interface>`
+
+// toolTip is the D-Bus representation of
+// a tooltip, of signature (sa(iiay)ss).
+type toolTip struct {
+	IconName   string
+	IconPixmap []struct {
+		Width  int32
+		Height int32
+		Data   []byte
+	}
+	Title string
+	Text  string
+}
+
+// item implements the org.kde.StatusNotifierItem
+// interface. All its exported methods are exposed on
+// the bus. Clicking the icon shows the menu.
+type item struct{}
+
+func (item) ContextMenu(x int32, y int32) *dbus.Error       { return nil }
+func (item) Activate(x int32, y int32) *dbus.Error          { return nil }
+func (item) SecondaryActivate(x int32, y int32) *dbus.Error { return nil }
+func (item) Scroll(delta int32, orientation string) *dbus.Error {
+	return nil
+}
+
+// A Tray shows an icon with a menu in the system tray, using the
+// StatusNotifierItem protocol over the D-Bus session bus. It is
+// supported by KDE, waybar, and GNOME with the AppIndicator
+// extension, among others.
+type Tray struct {
+	conn  *dbus.Conn
+	name  string
+	props *prop.Properties
+	menu  *menu
+	done  chan struct{}
+}
+
+// New shows an icon in the tray, with the given id and title,
+// until Close is called. If no tray is running, the icon is shown
+// as soon as one starts.
+func New(id string, title string) (*Tray, error) {
+
+	conn, err := dbus.ConnectSessionBus()
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect to session bus: %w", err)
+	}
+
+	t := &Tray{
+		conn: conn,
+		name: fmt.Sprintf("org.kde.StatusNotifierItem-%d-1", os.Getpid()),
+		menu: newMenu(conn),
+		done: make(chan struct{}),
+	}
+
+	if err := t.export(id, title); err != nil {
+		conn.Close() // nolint
+		return nil, err
+	}
+
+	reply, err := conn.RequestName(t.name, dbus.NameFlagDoNotQueue)
+	if err != nil {
+		conn.Close() // nolint
+		return nil, fmt.Errorf("unable to request bus name: %w", err)
+	}
+	if reply != dbus.RequestNameReplyPrimaryOwner {
+		conn.Close() // nolint
+		return nil, fmt.Errorf("bus name %s is already taken", t.name)
+	}
+
+	// The icon must be registered again each
+	// time the tray, which owns the watcher, starts.
+	if err := conn.AddMatchSignal(
+		dbus.WithMatchInterface("org.freedesktop.DBus"),
+		dbus.WithMatchMember("NameOwnerChanged"),
+		dbus.WithMatchArg(0, watcherName),
+	); err != nil {
+		conn.Close() // nolint
+		return nil, fmt.Errorf("unable to watch the tray: %w", err)
+	}
+
+	signals := make(chan *dbus.Signal, 8)
+	conn.Signal(signals)
+
+	go func() {
+		for {
+			select {
+			case sig, ok := <-signals:
+				if !ok {
+					return
+				}
+				if len(sig.Body) == 3 && sig.Body[0] == watcherName && sig.Body[2] != "" {
+					_ = t.register()
+				}
+			case <-t.done:
+				return
+			}
+		}
+	}()
+
+	// There may be no tray yet.
+	_ = t.register()
+
+	return t, nil
+}
+
+// SetStatus sets the status of the icon. Trays may
+// hide the icons with the StatusPassive status.
+func (t *Tray) SetStatus(status string) {
+	t.props.SetMust(itemInterface, "Status", status)
+	_ = t.conn.Emit(itemPath, itemInterface+".NewStatus", status)
+}
+
+// SetIcon sets the icon, as a freedesktop icon name.
+func (t *Tray) SetIcon(name string) {
+	t.props.SetMust(itemInterface, "IconName", name)
+	_ = t.conn.Emit(itemPath, itemInterface+".NewIcon")
+}
+
+// SetToolTip sets the tooltip of the icon.
+func (t *Tray) SetToolTip(title string, text string) {
+	t.props.SetMust(itemInterface, "ToolTip", toolTip{Title: title, Text: text})
+	_ = t.conn.Emit(itemPath, itemInterface+".NewToolTip")
+}
+
+// SetMenu replaces the entries of the menu of the icon.
+func (t *Tray) SetMenu(items []MenuItem) {
+	t.menu.set(items)
+}
+
+// Close removes the icon from the tray.
+func (t *Tray) Close() error {
+
+	close(t.done)
+
+	return t.conn.Close()
+}
+
+func (t *Tray) export(id string, title string) error {
+
+	if err := t.conn.Export(item{}, itemPath, itemInterface); err != nil {
+		return fmt.Errorf("unable to export item: %w", err)
+	}
+
+	if err := t.conn.Export(t.menu, menuPath, menuInterface); err != nil {
+		return fmt.Errorf("unable to export menu: %w", err)
+	}
+
+	props, err := prop.Export(t.conn, itemPath, prop.Map{
+		itemInterface: {
+			"Category":   {Value: "ApplicationStatus", Emit: prop.EmitFalse},
+			"Id":         {Value: id, Emit: prop.EmitFalse},
+			"Title":      {Value: title, Emit: prop.EmitFalse},
+			"Status":     {Value: StatusActive, Emit: prop.EmitFalse},
+			"WindowId":   {Value: int32(0), Emit: prop.EmitFalse},
+			"IconName":   {Value: "edit-paste", Emit: prop.EmitFalse},
+			"ToolTip":    {Value: toolTip{Title: title}, Emit: prop.EmitFalse},
+			"ItemIsMenu": {Value: true, Emit: prop.EmitFalse},
+			"Menu":       {Value: dbus.ObjectPath(menuPath), Emit: prop.EmitFalse},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("unable to export item properties: %w", err)
+	}
+	t.props = props
+
+	if _, err := prop.Export(t.conn, menuPath, prop.Map{
+		menuInterface: {
+			"Version":       {Value: uint32(3), Emit: prop.EmitFalse},
+			"TextDirection": {Value: "ltr", Emit: prop.EmitFalse},
+			"Status":        {Value: "normal", Emit: prop.EmitFalse},
+			"IconThemePath": {Value: []string{}, Emit: prop.EmitFalse},
+		},
+	}); err != nil {
+		return fmt.Errorf("unable to export menu properties: %w", err)
+	}
+
+	for path, data := range map[dbus.ObjectPath]string{itemPath: itemIntrospection, menuPath: menuIntrospection} {
+		node := `<node>` + introspect.IntrospectDataString + prop.IntrospectDataString + data + `</node>`
+		if err := t.conn.Export(introspect.Introspectable(node), path, "org.freedesktop.DBus.Introspectable"); err != nil {
+			return fmt.Errorf("unable to export introspection data: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// register registers the icon to the watcher of the tray.
+func (t *Tray) register() error {
+
+	obj := t.conn.Object(watcherName, watcherPath)
+	if err := obj.Call(watcherInterface+".RegisterStatusNotifierItem", 0, t.name).Err; err != nil {
+		return fmt.Errorf("unable to register to the tray: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,284 @@
+package tray
+
+import (
+	"bufio"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+)
+
+// privateBus starts a session bus for the test, and makes it
+// the one used by New. It skips the test if none can be started.
+func privateBus(t *testing.T) string {
+
+	t.Helper()
+
+	path, err := exec.LookPath("dbus-daemon")
+	if err != nil {
+		t.Skip("dbus-daemon not found: no private session bus available")
+	}
+
+	cmd := exec.Command(path, "--session", "--nofork", "--print-address")
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatalf("unable to read bus address: %s", err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Skipf("unable to start a private session bus: %s", err)
+	}
+	t.Cleanup(func() {
+		cmd.Process.Kill() // nolint
+		cmd.Wait()         // nolint
+	})
+
+	address, err := bufio.NewReader(out).ReadString('\n')
+	if err != nil {
+		t.Skipf("unable to start a private session bus: %s", err)
+	}
+	address = strings.TrimSpace(address)
+
+	t.Setenv("DBUS_SESSION_BUS_ADDRESS", address)
+
+	return address
+}
+
+// watcher is a fake org.kde.StatusNotifierWatcher.
+type watcher struct {
+	registered chan string
+}
+
+func (w *watcher) RegisterStatusNotifierItem(service string) *dbus.Error {
+	w.registered <- service
+	return nil
+}
+
+// startWatcher runs a watcher on its own connection
+// to the given bus, as a tray would.
+func startWatcher(t *testing.T, address string) (*dbus.Conn, *watcher) {
+
+	t.Helper()
+
+	conn, err := dbus.Connect(address)
+	if err != nil {
+		t.Fatalf("unable to connect to bus: %s", err)
+	}
+	t.Cleanup(func() { conn.Close() }) // nolint
+
+	w := &watcher{registered: make(chan string, 4)}
+	if err := conn.Export(w, watcherPath, watcherInterface); err != nil {
+		t.Fatalf("unable to export watcher: %s", err)
+	}
+
+	if reply, err := conn.RequestName(watcherName, dbus.NameFlagDoNotQueue); err != nil || reply != dbus.RequestNameReplyPrimaryOwner {
+		t.Fatalf("unable to own watcher name: %d, %v", reply, err)
+	}
+
+	return conn, w
+}
+
+func waitRegistration(t *testing.T, w *watcher) string {
+
+	t.Helper()
+
+	select {
+	case name := <-w.registered:
+		return name
+	case <-time.After(5 * time.Second):
+		t.Fatalf("icon not registered to the watcher")
+		return ""
+	}
+}
+
+func TestTray(t *testing.T) {
+
+	address := privateBus(t)
+	conn, w := startWatcher(t, address)
+
+	tr, err := New("netboard", "netboard")
+	if err != nil {
+		t.Fatalf("unable to create tray: %s", err)
+	}
+	defer tr.Close() // nolint
+
+	name := waitRegistration(t, w)
+	if !strings.HasPrefix(name, "org.kde.StatusNotifierItem-") {
+		t.Fatalf("registered unexpected name %s", name)
+	}
+
+	clicked := make(chan string, 4)
+
+	tr.SetStatus(StatusNeedsAttention)
+	tr.SetIcon("network-offline")
+	tr.SetToolTip("netboard", "disconnected")
+	tr.SetMenu([]MenuItem{
+		{Label: "Server: netboard_1", Disabled: true},
+		{Label: "Pause sync", Checkbox: true, Checked: true, OnClick: func() { clicked <- "pause" }},
+		{Separator: true},
+		{Label: "Copy again", Children: []MenuItem{
+			{Label: "first", OnClick: func() { clicked <- "first" }},
+		}},
+	})
+
+	obj := conn.Object(name, itemPath)
+
+	properties := []struct {
+		name string
+		want any
+	}{
+		{"Id", "netboard"},
+		{"Status", StatusNeedsAttention},
+		{"IconName", "network-offline"},
+		{"ItemIsMenu", true},
+		{"Menu", dbus.ObjectPath(menuPath)},
+	}
+
+	for _, p := range properties {
+		v, err := obj.GetProperty(itemInterface + "." + p.name)
+		if err != nil {
+			t.Fatalf("unable to get property %s: %s", p.name, err)
+		}
+		if v.Value() != p.want {
+			t.Fatalf("property %s is %v, want %v", p.name, v.Value(), p.want)
+		}
+	}
+
+	tip := toolTip{}
+	v, err := obj.GetProperty(itemInterface + ".ToolTip")
+	if err != nil {
+		t.Fatalf("unable to get tooltip: %s", err)
+	}
+	if err := v.Store(&tip); err != nil || tip.Title != "netboard" || tip.Text != "disconnected" {
+		t.Fatalf("unexpected tooltip %+v: %v", tip, err)
+	}
+
+	menuObj := conn.Object(name, menuPath)
+
+	var revision uint32
+	layout := menuLayout{}
+	if err := menuObj.Call(menuInterface+".GetLayout", 0, int32(0), int32(-1), []string{}).Store(&revision, &layout); err != nil {
+		t.Fatalf("unable to get menu layout: %s", err)
+	}
+
+	if len(layout.Children) != 4 {
+		t.Fatalf("menu has %d entries, want 4", len(layout.Children))
+	}
+
+	first := menuLayout{}
+	if err := dbus.Store([]any{layout.Children[0].Value()}, &first); err != nil {
+		t.Fatalf("unable to decode menu entry: %s", err)
+	}
+	if label := first.Properties["label"].Value(); label != "Server: netboard__1" {
+		t.Fatalf("first entry is labeled %v", label)
+	}
+	if enabled := first.Properties["enabled"].Value(); enabled != false {
+		t.Fatalf("first entry is enabled")
+	}
+
+	// The entries are numbered depth first, so the
+	// entry of the submenu comes after its parent.
+	events := []struct {
+		id   int32
+		want string
+	}{
+		{2, "pause"},
+		{5, "first"},
+	}
+
+	for _, e := range events {
+		if err := menuObj.Call(menuInterface+".Event", 0, e.id, "clicked", dbus.MakeVariant(""), uint32(0)).Err; err != nil {
+			t.Fatalf("unable to click entry %d: %s", e.id, err)
+		}
+		select {
+		case got := <-clicked:
+			if got != e.want {
+				t.Fatalf("clicking entry %d ran %s, want %s", e.id, got, e.want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("clicking entry %d ran nothing", e.id)
+		}
+	}
+
+	// Clicking a disabled entry does nothing.
+	if err := menuObj.Call(menuInterface+".Event", 0, int32(1), "clicked", dbus.MakeVariant(""), uint32(0)).Err; err != nil {
+		t.Fatalf("unable to click disabled entry: %s", err)
+	}
+	if err := menuObj.Call(menuInterface+".Event", 0, int32(42), "clicked", dbus.MakeVariant(""), uint32(0)).Err; err == nil {
+		t.Fatalf("clicking an unknown entry succeeded")
+	}
+
+	// The icon registers again when the tray restarts.
+	if _, err := conn.ReleaseName(watcherName); err != nil {
+		t.Fatalf("unable to release watcher name: %s", err)
+	}
+	if _, err := conn.RequestName(watcherName, dbus.NameFlagDoNotQueue); err != nil {
+		t.Fatalf("unable to own watcher name again: %s", err)
+	}
+	if again := waitRegistration(t, w); again != name {
+		t.Fatalf("registered %s again, want %s", again, name)
+	}
+
+	select {
+	case got := <-clicked:
+		t.Fatalf("disabled entry ran %s", got)
+	default:
+	}
+}
+
+func TestMenuProperties(t *testing.T) {
+
+	tests := []struct {
+		name string
+		node *menuNode
+		want map[string]any
+	}{
+		{
+			"root",
+			&menuNode{},
+			map[string]any{"children-display": "submenu"},
+		},
+		{
+			"separator",
+			&menuNode{id: 1, item: MenuItem{Label: "ignored", Separator: true}},
+			map[string]any{"type": "separator"},
+		},
+		{
+			"entry with access key characters",
+			&menuNode{id: 1, item: MenuItem{Label: "a_b"}},
+			map[string]any{"label": "a__b", "enabled": true},
+		},
+		{
+			"disabled entry",
+			&menuNode{id: 1, item: MenuItem{Label: "a", Disabled: true}},
+			map[string]any{"label": "a", "enabled": false},
+		},
+		{
+			"checked checkbox",
+			&menuNode{id: 1, item: MenuItem{Label: "a", Checkbox: true, Checked: true}},
+			map[string]any{"label": "a", "enabled": true, "toggle-type": "checkmark", "toggle-state": int32(1)},
+		},
+		{
+			"submenu",
+			&menuNode{id: 1, item: MenuItem{Label: "a"}, children: []*menuNode{{id: 2}}},
+			map[string]any{"label": "a", "enabled": true, "children-display": "submenu"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			got := tt.node.properties()
+
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d properties, want %d: %v", len(got), len(tt.want), got)
+			}
+			for k, want := range tt.want {
+				if v, ok := got[k]; !ok || v.Value() != want {
+					t.Fatalf("property %s is %v, want %v", k, v, want)
+				}
+			}
+		})
+	}
+}